@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runActions is the entry point for GitHub Actions workflows, configured with
+// action inputs instead of arguments:
+//
+//	INPUT_RECIPIENTS  handles, separated by whitespace or commas, with optional
+//	                  @ prefix and # comments
+//	INPUT_FILES       files to encrypt, one per line, each into FILE.age;
+//	                  if empty, recipients are only resolved
+//	INPUT_ARMOR       "true" to encrypt to ASCII-armored format
+//
+// Resolved recipients are listed in job summary (GITHUB_STEP_SUMMARY), and
+// reported as step outputs (GITHUB_OUTPUT): "fingerprints" and "recipients",
+// one per line, and "files" holding names of encrypted files. Keys are
+// public, so nothing is masked in the log.
+func runActions(ctx context.Context, r *resolver, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: age-github actions, configured with INPUT_RECIPIENTS, INPUT_FILES, and INPUT_ARMOR variables")
+	}
+	handles := actionsList(os.Getenv("INPUT_RECIPIENTS"))
+	if len(handles) == 0 && len(r.cfg.Recipients) == 0 {
+		return errors.New("no recipients given in INPUT_RECIPIENTS")
+	}
+	var files []string
+	for _, line := range strings.Split(os.Getenv("INPUT_FILES"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	armor := r.cfg.Armor
+	if v := os.Getenv("INPUT_ARMOR"); v != "" {
+		var err error
+		if armor, err = strconv.ParseBool(v); err != nil {
+			return errors.New("INPUT_ARMOR: boolean expected")
+		}
+	}
+	r.Prefetch(ctx, handles)
+	var list []resolve.Recipient
+	for _, handle := range handles {
+		rcs, err := r.Recipients(ctx, handle)
+		if err != nil {
+			return err
+		}
+		list = append(list, rcs...)
+	}
+	var keys, fingerprints []string
+	for _, rc := range list {
+		keys = append(keys, rc.String())
+		fingerprints = append(fingerprints, rc.Fingerprint)
+	}
+	if r.cfg.Self != "" {
+		self, err := r.selfKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("self recipient: %w", err)
+		}
+		keys = append(keys, self...)
+	}
+	defaults, err := r.resolveList(ctx, r.cfg.Recipients)
+	if err != nil {
+		return fmt.Errorf("recipients setting: %w", err)
+	}
+	keys = uniqueStrings(append(keys, defaults...))
+	var outputs []string
+	if len(files) != 0 {
+		ageBin, err := exec.LookPath(r.cfg.Backend)
+		if err != nil {
+			return err
+		}
+		err = runJobs(len(files), r.cfg.Jobs, func(i int) error {
+			ageArgs := []string{"-e", "-o", files[i] + ".age"}
+			if armor {
+				ageArgs = append(ageArgs, "-a")
+			}
+			for _, k := range keys {
+				ageArgs = append(ageArgs, "-r", k)
+			}
+			if err := runBackend(ctx, ageBin, append(ageArgs, files[i])); err != nil {
+				return fmt.Errorf("encrypting %s: %w", files[i], err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range files {
+			outputs = append(outputs, name+".age")
+		}
+	}
+	if err := writeStepSummary(list, outputs); err != nil {
+		return fmt.Errorf("writing job summary: %w", err)
+	}
+	return writeStepOutputs([][2]string{
+		{"fingerprints", strings.Join(uniqueStrings(fingerprints), "\n")},
+		{"recipients", strings.Join(keys, "\n")},
+		{"files", strings.Join(outputs, "\n")},
+	})
+}
+
+// actionsList splits action input into items separated by whitespace or
+// commas, dropping # comments and @ prefixes.
+func actionsList(input string) []string {
+	var out []string
+	for _, line := range strings.Split(input, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, s := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			out = append(out, strings.TrimPrefix(s, "@"))
+		}
+	}
+	return out
+}
+
+// writeStepSummary appends markdown table of recipients, and list of
+// encrypted files, to GITHUB_STEP_SUMMARY file, if it's set.
+func writeStepSummary(list []resolve.Recipient, files []string) error {
+	name := os.Getenv("GITHUB_STEP_SUMMARY")
+	if name == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString("### age-github recipients\n\n| Handle | Key type | Fingerprint | Source |\n| --- | --- | --- | --- |\n")
+	for _, rc := range list {
+		fmt.Fprintf(&buf, "| @%s | %s | `%s` | %s |\n", rc.Handle, rc.KeyType, rc.Fingerprint, rc.Source)
+	}
+	if len(files) != 0 {
+		buf.WriteString("\nEncrypted files:\n\n")
+		for _, name := range files {
+			fmt.Fprintf(&buf, "- `%s`\n", name)
+		}
+	}
+	buf.WriteString("\n")
+	return appendFile(name, buf.Bytes())
+}
+
+// writeStepOutputs appends step outputs to GITHUB_OUTPUT file, using
+// delimited syntax for multi-line values. Outside of Actions, outputs are
+// printed to stdout as name=value lines.
+func writeStepOutputs(outputs [][2]string) error {
+	name := os.Getenv("GITHUB_OUTPUT")
+	if name == "" {
+		for _, kv := range outputs {
+			fmt.Printf("%s=%s\n", kv[0], strings.Replace(kv[1], "\n", " ", -1))
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, kv := range outputs {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		delim := "EOF_" + hex.EncodeToString(b)
+		fmt.Fprintf(&buf, "%s<<%s\n%s\n%s\n", kv[0], delim, kv[1], delim)
+	}
+	if err := appendFile(name, buf.Bytes()); err != nil {
+		return fmt.Errorf("writing step outputs: %w", err)
+	}
+	return nil
+}
+
+func appendFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ageFlagNames maps all spellings of age flags, without leading dashes, to
+// their short names.
+var ageFlagNames = map[string]string{
+	"e": "e", "encrypt": "e",
+	"d": "d", "decrypt": "d",
+	"o": "o", "output": "o",
+	"a": "a", "armor": "a",
+	"p": "p", "passphrase": "p",
+	"r": "r", "recipient": "r",
+	"R": "R", "recipients-file": "R",
+	"i": "i", "identity": "i",
+	"j": "j",
+	"h": "h", "help": "h",
+	"version": "version",
+}
+
+// ageValueFlags are short names of age flags taking a value.
+var ageValueFlags = map[string]bool{"o": true, "r": true, "R": true, "i": true, "j": true}
+
+// ageFlag is a single flag of age command line.
+type ageFlag struct {
+	name  string   // short name, or flag as given if age doesn't know it
+	value string   // empty for boolean flags
+	raw   []string // flag as given, with its value if that is a separate argument
+}
+
+// ageCommand is age command line split into flags and positional arguments.
+type ageCommand struct {
+	flags []ageFlag
+	args  []string // positional arguments, age takes a single input file
+}
+
+// parseAgeArgs parses age arguments the way age does: flags may have one or
+// two leading dashes, short or long names, and values either as the next
+// argument or after "=". Unlike age, it also takes flags following positional
+// arguments, until "--". Flags age doesn't know are kept as is, and are
+// assumed to take no value.
+func parseAgeArgs(args []string) (*ageCommand, error) {
+	cmd := new(ageCommand)
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			cmd.args = append(cmd.args, args[i+1:]...)
+			break
+		}
+		if len(a) < 2 || a[0] != '-' {
+			cmd.args = append(cmd.args, a)
+			continue
+		}
+		name, value, hasValue := strings.TrimPrefix(a[1:], "-"), "", false
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		f := ageFlag{name: a, raw: args[i : i+1]}
+		short, ok := ageFlagNames[name]
+		switch {
+		case !ok:
+		case ageValueFlags[short] && !hasValue:
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("age flag %s needs a value", a)
+			}
+			i++
+			f.name, f.value, f.raw = short, args[i], args[i-1:i+1]
+		case ageValueFlags[short]:
+			f.name, f.value = short, value
+		case hasValue:
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid boolean value %q of age flag %s", value, a[:len(a)-len(value)-1])
+			}
+			if !on {
+				continue // same as if flag was not given
+			}
+			f.name = short
+		default:
+			f.name = short
+		}
+		cmd.flags = append(cmd.flags, f)
+	}
+	return cmd, nil
+}
+
+// has reports whether command line has a flag with short name.
+func (c *ageCommand) has(name string) bool {
+	for _, f := range c.flags {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// values returns values of all flags with short name, in order.
+func (c *ageCommand) values(name string) []string {
+	var out []string
+	for _, f := range c.flags {
+		if f.name == name {
+			out = append(out, f.value)
+		}
+	}
+	return out
+}
+
+// decrypt reports whether age is called to decrypt.
+func (c *ageCommand) decrypt() bool { return c.has("d") }
+
+// encrypt reports whether age is called to encrypt to recipients, as opposed
+// to decryption, passphrase encryption, or printing help or version.
+func (c *ageCommand) encrypt() bool {
+	return !c.has("d") && !c.has("p") && !c.has("h") && !c.has("version")
+}
+
+// input returns input file name, empty if input is stdin.
+func (c *ageCommand) input() string {
+	if len(c.args) == 0 || c.args[len(c.args)-1] == "-" {
+		return ""
+	}
+	return c.args[len(c.args)-1]
+}
+
+// inputArgs returns positional arguments to put after all flags, preceded by
+// "--" if any of them looks like a flag.
+func (c *ageCommand) inputArgs() []string {
+	for _, a := range c.args {
+		if len(a) > 1 && a[0] == '-' {
+			return append([]string{"--"}, c.args...)
+		}
+	}
+	return c.args
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAgeArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		args       []string
+		flags      []ageFlag
+		positional []string
+		wantErr    bool
+	}{
+		{
+			name: "short flags",
+			args: []string{"-r", "@alice", "-a", "-o", "out.age", "in.txt"},
+			flags: []ageFlag{
+				{name: "r", value: "@alice", raw: []string{"-r", "@alice"}},
+				{name: "a", raw: []string{"-a"}},
+				{name: "o", value: "out.age", raw: []string{"-o", "out.age"}},
+			},
+			positional: []string{"in.txt"},
+		},
+		{
+			name: "long flags with values after =",
+			args: []string{"--recipient=@x", "-recipients-file=team.txt", "--armor"},
+			flags: []ageFlag{
+				{name: "r", value: "@x", raw: []string{"--recipient=@x"}},
+				{name: "R", value: "team.txt", raw: []string{"-recipients-file=team.txt"}},
+				{name: "a", raw: []string{"--armor"}},
+			},
+		},
+		{
+			name: "flags after input file",
+			args: []string{"-e", "in.txt", "-r", "@alice", "--decrypt"},
+			flags: []ageFlag{
+				{name: "e", raw: []string{"-e"}},
+				{name: "r", value: "@alice", raw: []string{"-r", "@alice"}},
+				{name: "d", raw: []string{"--decrypt"}},
+			},
+			positional: []string{"in.txt"},
+		},
+		{
+			name:       "double dash",
+			args:       []string{"-r", "@alice", "--", "-in.txt", "-a"},
+			flags:      []ageFlag{{name: "r", value: "@alice", raw: []string{"-r", "@alice"}}},
+			positional: []string{"-in.txt", "-a"},
+		},
+		{
+			name:       "stdin",
+			args:       []string{"-d", "-"},
+			flags:      []ageFlag{{name: "d", raw: []string{"-d"}}},
+			positional: []string{"-"},
+		},
+		{
+			name:  "bool flag disabled",
+			args:  []string{"--armor=false", "-r", "@alice"},
+			flags: []ageFlag{{name: "r", value: "@alice", raw: []string{"-r", "@alice"}}},
+		},
+		{
+			name:  "bool flag enabled",
+			args:  []string{"-a=true"},
+			flags: []ageFlag{{name: "a", raw: []string{"-a=true"}}},
+		},
+		{
+			name: "unknown flags",
+			args: []string{"--plugin-flag", "-x=1", "in.txt"},
+			flags: []ageFlag{
+				{name: "--plugin-flag", raw: []string{"--plugin-flag"}},
+				{name: "-x=1", raw: []string{"-x=1"}},
+			},
+			positional: []string{"in.txt"},
+		},
+		{
+			name:    "missing value",
+			args:    []string{"-a", "-r"},
+			wantErr: true,
+		},
+		{
+			name:    "missing long value",
+			args:    []string{"--output"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid bool value",
+			args:    []string{"--armor=maybe"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, err := parseAgeArgs(tc.args)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want error", cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cmd.flags, tc.flags) {
+				t.Errorf("flags: got %+v, want %+v", cmd.flags, tc.flags)
+			}
+			if !reflect.DeepEqual(cmd.args, tc.positional) {
+				t.Errorf("args: got %q, want %q", cmd.args, tc.positional)
+			}
+		})
+	}
+}
+
+func TestAgeCommand(t *testing.T) {
+	cmd, err := parseAgeArgs([]string{"-r", "@a", "in.txt", "--recipient", "@b", "-R", "f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cmd.values("r"), []string{"@a", "@b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values: got %q, want %q", got, want)
+	}
+	if !cmd.encrypt() || cmd.decrypt() {
+		t.Error("command should encrypt")
+	}
+	if got := cmd.input(); got != "in.txt" {
+		t.Errorf("input: got %q", got)
+	}
+	cmd, err = parseAgeArgs([]string{"-d", "--", "-secret.age"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.encrypt() || !cmd.decrypt() {
+		t.Error("command should decrypt")
+	}
+	if got, want := cmd.inputArgs(), []string{"--", "-secret.age"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("inputArgs: got %q, want %q", got, want)
+	}
+	for _, args := range [][]string{{"-p", "x"}, {"--version"}, {"-h"}} {
+		if cmd, err := parseAgeArgs(args); err != nil || cmd.encrypt() {
+			t.Errorf("%q: should not encrypt to recipients (err: %v)", args, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// stanza is a recipient stanza of age file header.
+type stanza struct {
+	Type string
+	Args []string
+}
+
+const armorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+
+// readAgeHeader returns recipient stanzas from the header of age encrypted
+// file, which may be ASCII-armored. It also reports whether file is armored.
+func readAgeHeader(name string) (stanzas []stanza, armored bool, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if b, _ := br.Peek(len(armorHeader)); string(b) == armorHeader {
+		armored = true
+		if r, err = dearmorHeader(br); err != nil {
+			return nil, true, err
+		}
+	}
+	stanzas, err = parseAgeHeader(bufio.NewReader(r))
+	return stanzas, armored, err
+}
+
+// dearmorHeader decodes enough of ASCII-armored age file to hold its header.
+func dearmorHeader(br *bufio.Reader) (io.Reader, error) {
+	if _, err := br.ReadString('\n'); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-----END") || (line == "" && err != nil) {
+			break
+		}
+		b, derr := base64.StdEncoding.DecodeString(line)
+		if derr != nil {
+			return nil, errors.New("malformed armor")
+		}
+		buf.Write(b)
+		if bytes.Contains(buf.Bytes(), []byte("\n--- ")) || err != nil {
+			break
+		}
+	}
+	return &buf, nil
+}
+
+// parseAgeHeader parses age v1 header up to its mac line.
+func parseAgeHeader(br *bufio.Reader) ([]stanza, error) {
+	line, err := br.ReadString('\n')
+	if err != nil || line != "age-encryption.org/v1\n" {
+		return nil, errors.New("not an age encrypted file")
+	}
+	var out []stanza
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("malformed age header")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			return out, nil
+		case strings.HasPrefix(line, "-> "):
+			fields := strings.Fields(line[3:])
+			if len(fields) == 0 {
+				return nil, errors.New("malformed age header")
+			}
+			out = append(out, stanza{Type: fields[0], Args: fields[1:]})
+		}
+		// other lines are stanza bodies
+	}
+}
+
+// sshKeyTag returns tag identifying ssh key in age stanzas: first 4 bytes of
+// SHA-256 of key in ssh wire format, base64-encoded without padding.
+func sshKeyTag(key string) (string, error) {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return "", errors.New("malformed ssh key")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(blob)
+	return base64.RawStdEncoding.EncodeToString(sum[:4]), nil
+}
+
+// stanzaID returns an identifier of recipient stanza, comparable to
+// recipientID of the key it was encrypted to, or an empty string if stanza
+// recipient cannot be identified.
+func stanzaID(s stanza) string {
+	switch s.Type {
+	case "ssh-ed25519", "ssh-rsa":
+		if len(s.Args) != 0 {
+			return s.Type + " " + s.Args[0]
+		}
+	case "X25519":
+		return "X25519"
+	}
+	return ""
+}
+
+// recipientID returns an identifier of recipient, comparable to stanzaID.
+// Native age recipients can't be told apart by stanzas, so they all have
+// the same identifier.
+func recipientID(key string) string {
+	switch {
+	case strings.HasPrefix(key, "age1"):
+		return "X25519"
+	case strings.HasPrefix(key, "ssh-ed25519 "), strings.HasPrefix(key, "ssh-rsa "):
+		tag, err := sshKeyTag(key)
+		if err != nil {
+			return ""
+		}
+		return strings.Fields(key)[0] + " " + tag
+	}
+	return ""
+}
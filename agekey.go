@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// sshToAgeRecipient converts ssh-ed25519 public key to native age X25519
+// recipient, the same way ssh-to-age tool does: ed25519 public key is mapped
+// to its Montgomery form. Files encrypted to such recipient can be decrypted
+// with identity derived from the matching ed25519 private key.
+func sshToAgeRecipient(key string) (string, error) {
+	pub, err := ed25519PublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	u, err := edwardsToMontgomery(pub)
+	if err != nil {
+		return "", err
+	}
+	return bech32Encode("age", u), nil
+}
+
+// ed25519PublicKey extracts raw 32-byte public key from ssh-ed25519 key in
+// authorized_keys format.
+func ed25519PublicKey(key string) ([]byte, error) {
+	fields := strings.Fields(key)
+	if len(fields) < 2 || fields[0] != "ssh-ed25519" {
+		return nil, errors.New("not an ssh-ed25519 key")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	var parts [][]byte
+	for len(blob) > 0 {
+		if len(blob) < 4 {
+			return nil, errors.New("malformed ssh-ed25519 key")
+		}
+		n := binary.BigEndian.Uint32(blob)
+		if uint64(len(blob)-4) < uint64(n) {
+			return nil, errors.New("malformed ssh-ed25519 key")
+		}
+		parts = append(parts, blob[4:4+n])
+		blob = blob[4+n:]
+	}
+	if len(parts) != 2 || string(parts[0]) != "ssh-ed25519" || len(parts[1]) != 32 {
+		return nil, errors.New("malformed ssh-ed25519 key")
+	}
+	return parts[1], nil
+}
+
+// edwardsToMontgomery converts ed25519 public key to x25519 one using
+// birational map u = (1+y)/(1-y).
+func edwardsToMontgomery(pub []byte) ([]byte, error) {
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	le := make([]byte, 32)
+	copy(le, pub)
+	le[31] &= 0x7f // drop sign bit of x
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(p) >= 0 {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, p)
+	if den.Sign() == 0 {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, p))
+	u.Mod(u, p)
+	out := make([]byte, 32)
+	b := u.Bytes()
+	copy(out[32-len(b):], b)
+	return reverse(out), nil
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// bech32Encode encodes data with bech32 (BIP 173) using given human-readable
+// part, as age does for its recipients.
+func bech32Encode(hrp string, data []byte) string {
+	const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	// regroup 8-bit bytes into 5-bit groups
+	var values []byte
+	var acc, bits uint
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits&31))
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits)&31))
+	}
+	polymod := func(values []byte) uint32 {
+		gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+		chk := uint32(1)
+		for _, v := range values {
+			top := chk >> 25
+			chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+			for i := 0; i < 5; i++ {
+				if top>>uint(i)&1 == 1 {
+					chk ^= gen[i]
+				}
+			}
+		}
+		return chk
+	}
+	var expanded []byte
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	mod := polymod(append(append(expanded, values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(charset[mod>>uint(5*(5-i))&31])
+	}
+	return sb.String()
+}
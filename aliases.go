@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// aliasMap maps short memorable names to full user handles, which may be
+// provider-qualified (i.e. "user@host").
+type aliasMap map[string]string
+
+// expand returns handle an alias name points to, or name itself if it is not
+// an alias.
+func (m aliasMap) expand(name string) string {
+	if v, ok := m[name]; ok {
+		return v
+	}
+	return name
+}
+
+// loadAliases reads aliases from "age-github/aliases" file under
+// os.UserConfigDir directory. Missing file is not an error.
+//
+// Each non-empty line of the file holds alias name and a handle it expands to,
+// separated by whitespace; both may have an optional @ prefix. Lines starting
+// with # are ignored:
+//
+//	# name    handle
+//	k8s-bot   @corp-k8s-automation@ghe.corp
+func loadAliases() (aliasMap, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil || dir == "" {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(dir, "age-github", "aliases"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseAliases(f.Name(), bufio.NewScanner(f))
+}
+
+func parseAliases(filename string, scanner *bufio.Scanner) (aliasMap, error) {
+	m := make(aliasMap)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want alias name and handle separated by whitespace", filename, n)
+		}
+		name := strings.TrimPrefix(fields[0], "@")
+		target := strings.TrimPrefix(fields[1], "@")
+		if name == "" || target == "" {
+			return nil, fmt.Errorf("%s:%d: empty alias name or handle", filename, n)
+		}
+		m[name] = target
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runArchive runs age with the given arguments (first one being age binary),
+// either feeding it with tar archive of opts.archive directory, or, if
+// decrypt is set, extracting its output as tar archive into opts.archive
+// directory. When decrypting, age reads stdin if stdin is set, and input file
+// from its arguments otherwise. Archive may be compressed with zstd, which
+// requires zstd tool.
+func runArchive(ctx context.Context, ageArgs []string, decrypt, stdin bool, opts ageOptions) error {
+	age := exec.CommandContext(ctx, ageArgs[0], ageArgs[1:]...)
+	age.Stderr = os.Stderr
+	if decrypt {
+		if stdin {
+			age.Stdin = os.Stdin
+		}
+		ageOut, err := age.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := age.Start(); err != nil {
+			return err
+		}
+		if err = extractArchive(ctx, ageOut, opts.archive); err != nil {
+			// age blocks writing to pipe nobody reads otherwise
+			_, _ = io.Copy(ioutil.Discard, ageOut)
+		}
+		if werr := age.Wait(); werr != nil {
+			return werr
+		}
+		return err
+	}
+	age.Stdout = os.Stdout
+	ageIn, err := age.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := age.Start(); err != nil {
+		return err
+	}
+	var w io.WriteCloser = ageIn
+	var zst *exec.Cmd
+	if opts.zstd {
+		zst = exec.CommandContext(ctx, "zstd", "-q", "-c")
+		zst.Stdout, zst.Stderr = ageIn, os.Stderr
+		if w, err = zst.StdinPipe(); err != nil {
+			return err
+		}
+		if err := zst.Start(); err != nil {
+			ageIn.Close()
+			_ = age.Wait()
+			return err
+		}
+	}
+	err = writeTar(w, opts.archive)
+	w.Close()
+	if zst != nil {
+		if zerr := zst.Wait(); err == nil {
+			err = zerr
+		}
+		ageIn.Close()
+	}
+	if werr := age.Wait(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// noSymlinkParents returns error if any of existing directories name is in,
+// below dir, is a symlink, as names are only checked as strings, and chains
+// of symlinks, i.e. "a" -> "." and "a/b" -> "..", can lead out of dir.
+func noSymlinkParents(dir, name string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(name))
+	if err != nil || rel == "." {
+		return err
+	}
+	p := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", p)
+		}
+	}
+	return nil
+}
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// extractArchive extracts tar archive, possibly zstd-compressed, from r into
+// dir. Empty input is an error, as it's not an archive, even of an empty
+// directory.
+func extractArchive(ctx context.Context, r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if len(magic) == 0 {
+		if err == io.EOF {
+			return errors.New("decrypted archive is empty")
+		}
+		return err
+	}
+	if !bytes.Equal(magic, zstdMagic) {
+		return extractTar(br, dir)
+	}
+	zst := exec.CommandContext(ctx, "zstd", "-q", "-d", "-c")
+	zst.Stdin, zst.Stderr = br, os.Stderr
+	out, err := zst.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := zst.Start(); err != nil {
+		return err
+	}
+	if err = extractTar(out, dir); err != nil {
+		_, _ = io.Copy(ioutil.Discard, out)
+	}
+	if werr := zst.Wait(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// writeTar writes tar archive of dir contents to w, with file names relative
+// to dir.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(name); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractTar extracts tar archive into dir, refusing entries that would end
+// up outside of it, either by their names, or by being placed under symlinks
+// earlier entries made.
+func extractTar(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	inside := func(name string) bool {
+		rel, err := filepath.Rel(dir, name)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(hdr.Name) || !inside(name) {
+			return fmt.Errorf("archive entry %q points outside of %s", hdr.Name, dir)
+		}
+		if err := noSymlinkParents(dir, name); err != nil {
+			return fmt.Errorf("archive entry %q: %w", hdr.Name, err)
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !inside(filepath.Join(filepath.Dir(name), hdr.Linkname)) {
+				return fmt.Errorf("archive symlink %q points outside of %s", hdr.Name, dir)
+			}
+			if err := os.Symlink(hdr.Linkname, name); err != nil {
+				return err
+			}
+		default:
+			return errors.New("unsupported archive entry type for " + hdr.Name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is an entry of test archive: directory if name ends with "/",
+// symlink if link is set, and regular file otherwise.
+type tarEntry struct {
+	name, link, body string
+}
+
+func makeTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestExtractTar(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []tarEntry
+		wantErr bool
+		files   map[string]string // expected regular files under dir
+	}{
+		{
+			name: "regular",
+			entries: []tarEntry{
+				{name: "a/"},
+				{name: "a/b.txt", body: "hello"},
+				{name: "c/d.txt", body: "world"},
+				{name: "link", link: "a/b.txt"},
+			},
+			files: map[string]string{"a/b.txt": "hello", "c/d.txt": "world"},
+		},
+		{
+			name:    "dot-dot name",
+			entries: []tarEntry{{name: "../x", body: "x"}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute name",
+			entries: []tarEntry{{name: "/tmp/x", body: "x"}},
+			wantErr: true,
+		},
+		{
+			name:    "symlink outside",
+			entries: []tarEntry{{name: "a", link: "../.."}},
+			wantErr: true,
+		},
+		{
+			name: "symlink chain",
+			entries: []tarEntry{
+				{name: "a", link: "."},
+				{name: "a/b", link: ".."},
+				{name: "b/x", body: "escaped"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file under symlink",
+			entries: []tarEntry{
+				{name: "d/"},
+				{name: "a", link: "d"},
+				{name: "a/x", body: "x"},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "age-github-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			dir := filepath.Join(root, "out")
+			err = extractTar(makeTar(t, tc.entries), dir)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("extractTar succeeded, want error")
+				}
+				if _, err := os.Lstat(filepath.Join(root, "x")); err == nil {
+					t.Fatal("file was written outside of target directory")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tc.files {
+				got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchiveEmpty(t *testing.T) {
+	root, err := ioutil.TempDir("", "age-github-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := extractArchive(context.Background(), new(bytes.Buffer), filepath.Join(root, "empty")); err == nil {
+		t.Error("extractArchive of empty input succeeded, want error")
+	}
+	// archive of empty directory is not empty input
+	if err := extractArchive(context.Background(), makeTar(t, nil), filepath.Join(root, "dir")); err != nil {
+		t.Errorf("extractArchive of empty directory archive: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// auditLog records every resolution of a user as a JSON object on a separate
+// line, see audit_log setting.
+type auditLog struct {
+	user, host string // who runs age-github, and where
+	pid        int
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// openAuditLog opens audit log at dest, which is either a file name, or
+// "syslog".
+func openAuditLog(dest string) (*auditLog, error) {
+	a := &auditLog{pid: os.Getpid()}
+	if dest == "syslog" {
+		w, err := syslogWriter()
+		if err != nil {
+			return nil, err
+		}
+		a.w = w
+	} else {
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.w = f
+	}
+	if u, err := user.Current(); err == nil {
+		a.user = u.Username
+	} else {
+		a.user = os.Getenv("USER")
+	}
+	a.host, _ = os.Hostname()
+	return a, nil
+}
+
+// record writes resolution event to the log. Failures to write are reported
+// as warnings, as resolution itself has already happened.
+func (a *auditLog) record(ev resolve.Event) {
+	entry := struct {
+		Time      time.Time  `json:"time"`
+		User      string     `json:"invoker"`
+		Host      string     `json:"host"`
+		PID       int        `json:"pid"`
+		Handle    string     `json:"handle"`
+		Provider  string     `json:"provider,omitempty"`
+		Login     string     `json:"user,omitempty"`
+		Keys      []string   `json:"keys,omitempty"`
+		Source    string     `json:"source,omitempty"`
+		FetchedAt *time.Time `json:"fetched_at,omitempty"`
+		Error     string     `json:"error,omitempty"`
+	}{
+		Time:     ev.Time.UTC(),
+		User:     a.user,
+		Host:     a.host,
+		PID:      a.pid,
+		Handle:   ev.Handle,
+		Provider: ev.Provider,
+		Login:    ev.User,
+		Keys:     ev.Keys,
+		Source:   ev.Source,
+	}
+	if !ev.FetchedAt.IsZero() {
+		t := ev.FetchedAt.UTC()
+		entry.FetchedAt = &t
+	}
+	if ev.Err != nil {
+		entry.Error = ev.Err.Error()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		warnf("audit log: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(append(data, '\n')); err != nil {
+		warnf("audit log: %v", err)
+	}
+}
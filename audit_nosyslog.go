@@ -0,0 +1,15 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// syslogWriter returns writer sending audit log entries to syslog, which is
+// not available on this platform.
+func syslogWriter() (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform, set audit_log to a file name")
+}
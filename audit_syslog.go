@@ -0,0 +1,14 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// syslogWriter returns writer sending audit log entries to syslog.
+func syslogWriter() (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "age-github")
+}
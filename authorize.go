@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// Markers of authorized_keys block managed by authorize -update.
+const (
+	authorizedBegin = "# BEGIN age-github managed keys, changes are overwritten"
+	authorizedEnd   = "# END age-github managed keys"
+)
+
+// runAuthorize prints all ssh keys of the given handles as authorized_keys
+// lines, each commented with handle it was fetched for. With -update flag it
+// instead replaces a marked block of authorized_keys file with these lines,
+// keeping the rest of the file intact.
+func runAuthorize(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("authorize", flag.ContinueOnError)
+	update := fs.Bool("update", false, "replace age-github managed block of authorized_keys file instead of printing keys")
+	file := fs.String("file", "", "authorized_keys `file` to update, ~/.ssh/authorized_keys by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github authorize [-update [-file path]] @handle...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	handles, err := collectHandles(fs.Args(), os.Stdin)
+	if err != nil {
+		return err
+	}
+	lines, err := r.authorizedLines(ctx, handles)
+	if err != nil {
+		return err
+	}
+	if !*update {
+		_, err := os.Stdout.WriteString(strings.Join(lines, ""))
+		return err
+	}
+	name := *file
+	if name == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		name = filepath.Join(home, ".ssh", "authorized_keys")
+	}
+	changed, err := updateAuthorizedKeys(name, lines)
+	if err != nil {
+		return err
+	}
+	if changed {
+		fmt.Fprintf(os.Stderr, "%s: %d key(s) authorized\n", name, len(lines))
+	}
+	return nil
+}
+
+// authorizedLines returns authorized_keys lines, with trailing newlines, for
+// all ssh keys of the given handles, which may be group handles. Native age
+// recipients, and recipients organization publishes for users without keys,
+// are skipped. Any error aborts, so that a temporary failure can't revoke
+// access.
+func (r *resolver) authorizedLines(ctx context.Context, handles []string) ([]string, error) {
+	r.Prefetch(ctx, handles)
+	var lines []string
+	seen := make(map[string]struct{})
+	for _, handle := range handles {
+		h := r.ExpandAlias(handle)
+		users := []string{h}
+		if resolve.IsGroupHandle(h) {
+			var err error
+			if users, err = r.ExpandGroup(ctx, h); err != nil {
+				return nil, fmt.Errorf("expanding group %q: %w", h, err)
+			}
+			r.Prefetch(ctx, users)
+		}
+		for _, u := range users {
+			list, err := r.ResolveAll(ctx, u)
+			if err != nil {
+				return nil, err
+			}
+			for _, rc := range list {
+				if rc.Org {
+					warnf("@%s publishes no keys, recipients of organization are not authorized", displayHandle(u, r.cfg.DefaultProvider))
+					break
+				}
+				if resolve.IsAgeRecipient(rc.PublicKey) {
+					continue
+				}
+				if _, ok := seen[rc.PublicKey]; ok {
+					continue
+				}
+				seen[rc.PublicKey] = struct{}{}
+				lines = append(lines, fmt.Sprintf("%s age-github:@%s\n", rc.PublicKey, displayHandle(u, r.cfg.DefaultProvider)))
+			}
+		}
+	}
+	return lines, nil
+}
+
+// updateAuthorizedKeys replaces managed block of authorized_keys file with
+// lines, appending the block if file doesn't have one yet. It reports whether
+// file was changed.
+func updateAuthorizedKeys(name string, lines []string) (bool, error) {
+	old, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	var block bytes.Buffer
+	block.WriteString(authorizedBegin + "\n")
+	for _, l := range lines {
+		block.WriteString(l)
+	}
+	block.WriteString(authorizedEnd + "\n")
+
+	var buf bytes.Buffer
+	begin := bytes.Index(old, []byte(authorizedBegin+"\n"))
+	end := bytes.Index(old, []byte(authorizedEnd+"\n"))
+	switch {
+	case begin >= 0 && end > begin:
+		buf.Write(old[:begin])
+		buf.Write(block.Bytes())
+		buf.Write(old[end+len(authorizedEnd)+1:])
+	case begin >= 0 || end >= 0:
+		return false, fmt.Errorf("%s: managed block markers are damaged, fix them manually", name)
+	default:
+		buf.Write(old)
+		if len(old) != 0 && old[len(old)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		buf.Write(block.Bytes())
+	}
+	if bytes.Equal(buf.Bytes(), old) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return false, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".age-github-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// batchManifest describes encryptions done by batch subcommand, in YAML or
+// JSON:
+//
+//	sets:
+//	  release: ["@alice", "@team:corp/release"]
+//	output_template: "{{.Input}}.age" # names outputs not given explicitly
+//	jobs:
+//	  - input: dist/app.tar.gz
+//	    output: dist/app.tar.gz.age # input name with .age suffix by default
+//	    recipients: ["release", "@bob", "age1..."]
+//	    armor: true
+//
+// Job recipients are @handles, literal keys, or names of recipient sets.
+// Paths are relative to manifest directory. Output template fields are
+// described by outputFields.
+type batchManifest struct {
+	Sets           map[string][]string `yaml:"sets"`
+	OutputTemplate string              `yaml:"output_template"`
+	Jobs           []batchJob          `yaml:"jobs"`
+}
+
+type batchJob struct {
+	Input      string   `yaml:"input"`
+	Output     string   `yaml:"output"`
+	Recipients []string `yaml:"recipients"`
+	Armor      bool     `yaml:"armor"`
+}
+
+// readBatchManifest reads manifest, naming outputs not given explicitly with
+// outTemplate, if set, or with manifest output_template.
+func readBatchManifest(name, outTemplate string) (*batchManifest, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	m := new(batchManifest)
+	if err := yaml.Unmarshal(data, m); err != nil { // JSON is valid YAML
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(m.Jobs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs", name)
+	}
+	if outTemplate == "" {
+		outTemplate = m.OutputTemplate
+	}
+	var tmpl *template.Template
+	if outTemplate != "" {
+		if tmpl, err = parseOutputTemplate(outTemplate); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	dir := filepath.Dir(name)
+	outputs := make(map[string]int, len(m.Jobs))
+	for i := range m.Jobs {
+		job := &m.Jobs[i]
+		if job.Input == "" || len(job.Recipients) == 0 {
+			return nil, fmt.Errorf("%s: job #%d must have both input and recipients", name, i+1)
+		}
+		switch {
+		case job.Output != "":
+		case tmpl != nil:
+			if job.Output, err = outputName(tmpl, job.Input, ""); err != nil {
+				return nil, fmt.Errorf("%s: job #%d: %w", name, i+1, err)
+			}
+		default:
+			job.Output = job.Input + ".age"
+		}
+		job.Input = filepath.Join(dir, filepath.FromSlash(job.Input))
+		job.Output = filepath.Join(dir, filepath.FromSlash(job.Output))
+		if j, ok := outputs[job.Output]; ok {
+			return nil, fmt.Errorf("%s: jobs #%d and #%d write the same %s", name, j+1, i+1, job.Output)
+		}
+		outputs[job.Output] = i
+		for _, s := range job.Recipients {
+			if _, ok := m.Sets[s]; !ok && !isBatchRecipient(s) {
+				return nil, fmt.Errorf("%s: job #%d: %q is neither a @handle, a key, nor a recipient set", name, i+1, s)
+			}
+		}
+	}
+	for set, list := range m.Sets {
+		for _, s := range list {
+			if !isBatchRecipient(s) {
+				return nil, fmt.Errorf("%s: set %q: %q is neither a @handle nor a key", name, set, s)
+			}
+		}
+	}
+	return m, nil
+}
+
+func isBatchRecipient(s string) bool {
+	return strings.HasPrefix(s, "@") || strings.HasPrefix(s, "age1") || strings.HasPrefix(s, "ssh-")
+}
+
+// recipients returns job recipients with sets expanded.
+func (m *batchManifest) recipients(job batchJob) []string {
+	var out []string
+	for _, s := range job.Recipients {
+		if set, ok := m.Sets[s]; ok {
+			out = append(out, set...)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// runBatch runs all encryptions described by manifest, see batchManifest.
+// Recipients of all jobs are resolved up front, so nothing is encrypted if any
+// of them fails, and jobs are then run in parallel, see runJobs.
+func runBatch(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	dryRun := fs.Bool("n", false, "only resolve recipients and print what would be done")
+	outTemplate := fs.String("output-template", "", "`template` of outputs names, overrides output_template of manifest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: age-github batch [-n] [-output-template template] manifest.yaml")
+	}
+	m, err := readBatchManifest(fs.Arg(0), *outTemplate)
+	if err != nil {
+		return err
+	}
+	var handles []string
+	for _, job := range m.Jobs {
+		for _, s := range m.recipients(job) {
+			if strings.HasPrefix(s, "@") {
+				handles = append(handles, s[1:])
+			}
+		}
+	}
+	r.Prefetch(ctx, uniqueStrings(handles))
+	var self []string
+	if r.cfg.Self != "" {
+		if self, err = r.selfKeys(ctx); err != nil {
+			return fmt.Errorf("self recipient: %w", err)
+		}
+	}
+	defaults, err := r.resolveList(ctx, r.cfg.Recipients)
+	if err != nil {
+		return fmt.Errorf("recipients setting: %w", err)
+	}
+	self = append(self, defaults...)
+	jobKeys := make([][]string, len(m.Jobs))
+	for i, job := range m.Jobs {
+		keys, err := r.resolveList(ctx, m.recipients(job))
+		if err != nil {
+			return fmt.Errorf("job #%d: %w", i+1, err)
+		}
+		jobKeys[i] = uniqueStrings(append(keys, self...))
+	}
+	if *dryRun {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "INPUT\tOUTPUT\tRECIPIENTS")
+		for i, job := range m.Jobs {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", job.Input, job.Output, len(jobKeys[i]))
+		}
+		return tw.Flush()
+	}
+	ageBin, err := exec.LookPath(r.cfg.Backend)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(m.Jobs))
+	_ = runJobs(len(m.Jobs), r.cfg.Jobs, func(i int) error {
+		job := m.Jobs[i]
+		if err := os.MkdirAll(filepath.Dir(job.Output), 0777); err != nil {
+			errs[i] = err
+			return err
+		}
+		ageArgs := []string{"-e", "-o", job.Output}
+		if job.Armor || r.cfg.Armor {
+			ageArgs = append(ageArgs, "-a")
+		}
+		for _, k := range jobKeys[i] {
+			ageArgs = append(ageArgs, "-r", k)
+		}
+		errs[i] = runBackend(ctx, ageBin, append(ageArgs, job.Input))
+		return errs[i]
+	})
+	var failed int
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OUTPUT\tRECIPIENTS\tSTATUS")
+	for i, job := range m.Jobs {
+		status := "ok"
+		if errs[i] != nil {
+			status = "FAIL: " + strings.Replace(errs[i].Error(), "\n", "; ", -1)
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", job.Output, len(jobKeys[i]), status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d job(s) failed", failed, len(m.Jobs))
+	}
+	return nil
+}
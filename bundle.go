@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// bundle holds keys of users resolved on one machine, to be used on another,
+// possibly offline one.
+type bundle struct {
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Users   []bundleEntry `json:"users"`
+}
+
+type bundleEntry struct {
+	Handle    string    `json:"handle"` // "user@host", so that it doesn't depend on provider names
+	Keys      []string  `json:"keys"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+const bundleVersion = 1
+
+// runBundle exports keys of users into a bundle file, and imports them from
+// it into keys_dir directory.
+func runBundle(ctx context.Context, r *resolver, args []string) error {
+	const usage = "usage: age-github bundle export [-f roster]... [-o file [-ssh-key file|-minisign file]] [@handle...], or age-github bundle import file"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "export":
+		return r.exportBundle(ctx, args[1:])
+	case "import":
+		return r.importBundle(ctx, args[1:])
+	}
+	return errors.New(usage)
+}
+
+// exportBundle writes bundle with all keys of users given by roster files and
+// handles to stdout, or to a file, optionally signing it, see signFile.
+func (r *resolver) exportBundle(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bundle export", flag.ContinueOnError)
+	var rosters stringList
+	fs.Var(&rosters, "f", "roster `file` with handles to export, may be repeated")
+	output := fs.String("o", "", "`file` to write bundle to instead of stdout")
+	sshKey := fs.String("ssh-key", "", "ssh private key `file` to sign bundle with, requires -o")
+	minisignKey := fs.String("minisign", "", "minisign secret key `file` to sign bundle with, requires -o")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *sshKey != "" && *minisignKey != "" {
+		return errors.New("only one of -ssh-key and -minisign can be used")
+	}
+	if (*sshKey != "" || *minisignKey != "") && *output == "" {
+		return errors.New("signing bundle requires -o flag, as signature is written next to it")
+	}
+	var handles []string
+	for _, name := range rosters {
+		list, err := readRoster(name)
+		if err != nil {
+			return err
+		}
+		handles = append(handles, list...)
+	}
+	for _, arg := range fs.Args() {
+		handles = append(handles, strings.TrimPrefix(arg, "@"))
+	}
+	if len(handles) == 0 {
+		return errors.New("no handles given")
+	}
+	var users []string
+	for _, h := range handles {
+		h = r.cfg.Aliases.expand(h)
+		if !resolve.IsGroupHandle(h) {
+			users = append(users, h)
+			continue
+		}
+		members, err := r.ExpandGroup(ctx, h)
+		if err != nil {
+			return fmt.Errorf("expanding group %q: %w", h, err)
+		}
+		users = append(users, members...)
+	}
+	users = uniqueStrings(users)
+	r.Prefetch(ctx, users)
+	b := bundle{Version: bundleVersion, Created: time.Now().UTC()}
+	seen := make(map[string]struct{})
+	for _, u := range users {
+		name, p, err := r.LookupProvider(u)
+		if err != nil {
+			return err
+		}
+		e := bundleEntry{Handle: name + "@" + p.Host}
+		if _, ok := seen[e.Handle]; ok {
+			continue
+		}
+		seen[e.Handle] = struct{}{}
+		list, err := r.FetchRecipients(ctx, u)
+		if err != nil {
+			return err
+		}
+		for _, rc := range list {
+			e.Keys = append(e.Keys, rc.PublicKey)
+			e.FetchedAt = rc.FetchedAt.UTC()
+		}
+		b.Users = append(b.Users, e)
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+	if *sshKey == "" && *minisignKey == "" {
+		return nil
+	}
+	return signFile(ctx, *output, *sshKey, *minisignKey)
+}
+
+// importBundle writes keys of users from bundle file into keys_dir files, so
+// that they are used without fetching them, dated by when keys were fetched,
+// so that pin_max_age applies. Signature of bundle, if any, is verified first,
+// see verifySignature.
+func (r *resolver) importBundle(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: age-github bundle import file")
+	}
+	if r.cfg.KeysDir == "" {
+		return errors.New("bundle import requires keys directory, see keys_dir setting")
+	}
+	signer, err := r.verifySignature(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var b bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if b.Version != bundleVersion {
+		return fmt.Errorf("%s: unsupported bundle version %d", args[0], b.Version)
+	}
+	// validate everything first, so that bundle is imported either fully,
+	// or not at all
+	for _, e := range b.Users {
+		i := strings.LastIndexByte(e.Handle, '@')
+		if i <= 0 || strings.ContainsAny(e.Handle, `/\`) || strings.HasPrefix(e.Handle, ".") {
+			return fmt.Errorf("%s: invalid handle %q", args[0], e.Handle)
+		}
+		for _, k := range e.Keys {
+			if resolve.Fingerprint(k) == "" && !resolve.IsAgeRecipient(k) || strings.ContainsAny(k, "\r\n") {
+				return fmt.Errorf("%s: @%s: invalid key %q", args[0], e.Handle, k)
+			}
+		}
+	}
+	if err := os.MkdirAll(r.cfg.KeysDir, 0700); err != nil {
+		return err
+	}
+	for _, e := range b.Users {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# imported from %s, fetched at %s\n", filepath.Base(args[0]), e.FetchedAt.Format(time.RFC3339))
+		for _, k := range e.Keys {
+			buf.WriteString(k + "\n")
+		}
+		name := filepath.Join(r.cfg.KeysDir, e.Handle)
+		if err := ioutil.WriteFile(name, buf.Bytes(), 0600); err != nil {
+			return err
+		}
+		if !e.FetchedAt.IsZero() {
+			if err := os.Chtimes(name, e.FetchedAt, e.FetchedAt); err != nil {
+				return err
+			}
+		}
+	}
+	if signer != "" {
+		fmt.Fprintf(os.Stderr, "%s: signed by %s\n", args[0], signer)
+	}
+	fmt.Fprintf(os.Stderr, "imported keys of %d user(s) into %s\n", len(b.Users), r.cfg.KeysDir)
+	return nil
+}
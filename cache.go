@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// runCache manages on-disk cache of fetched keys: removes old entries, or
+// reports its usage.
+func runCache(ctx context.Context, r *resolver, args []string) error {
+	const usage = "usage: age-github cache prune, or age-github cache stats [-json]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if r.cfg.CacheDir == "" {
+		return errors.New("cache is disabled, see cache_dir setting")
+	}
+	switch args[0] {
+	case "prune":
+		return r.pruneCache(args[1:])
+	case "stats":
+		return r.cacheStats(args[1:])
+	}
+	return errors.New(usage)
+}
+
+// pruneCache removes stale cache entries, and least recently used ones over
+// cache_max_entries and cache_max_size limits.
+func (r *resolver) pruneCache(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: age-github cache prune")
+	}
+	st, err := r.PruneCache()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d entries, %d responses (%s), kept %d entries (%s)\n",
+		st.Entries, st.Objects, byteSize(st.Bytes), st.EntriesLeft, byteSize(st.BytesLeft))
+	return nil
+}
+
+// cacheStats prints number and size of cache entries, hits and misses of
+// cache lookups, oldest and newest entries, and the same by provider.
+func (r *resolver) cacheStats(args []string) error {
+	fs := flag.NewFlagSet("cache stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print stats as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	st, err := r.CacheStats()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		type usage struct {
+			Entries int   `json:"entries"`
+			Size    int64 `json:"size"`
+			Hits    int64 `json:"hits"`
+			Misses  int64 `json:"misses"`
+		}
+		out := struct {
+			usage
+			Expired   int              `json:"expired"`
+			Oldest    string           `json:"oldest,omitempty"`
+			OldestAt  *time.Time       `json:"oldest_at,omitempty"`
+			Newest    string           `json:"newest,omitempty"`
+			NewestAt  *time.Time       `json:"newest_at,omitempty"`
+			Providers map[string]usage `json:"providers"`
+		}{
+			usage:     usage(st.CacheUsage),
+			Expired:   st.Expired,
+			Oldest:    st.Oldest,
+			Newest:    st.Newest,
+			Providers: make(map[string]usage, len(st.Providers)),
+		}
+		if st.Entries != 0 {
+			out.OldestAt, out.NewestAt = &st.OldestAt, &st.NewestAt
+		}
+		for name, u := range st.Providers {
+			out.Providers[name] = usage(*u)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	fmt.Printf("entries: %d (%d expired), %s\n", st.Entries, st.Expired, byteSize(st.Size))
+	fmt.Printf("lookups: %d hits, %d misses%s\n", st.Hits, st.Misses, hitRate(st.Hits, st.Misses))
+	if st.Entries != 0 {
+		fmt.Printf("oldest: @%s, fetched %s ago\n", st.Oldest, time.Since(st.OldestAt).Round(time.Second))
+		fmt.Printf("newest: @%s, fetched %s ago\n", st.Newest, time.Since(st.NewestAt).Round(time.Second))
+	}
+	if len(st.Providers) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(st.Providers))
+	for name := range st.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tENTRIES\tSIZE\tHITS\tMISSES")
+	for _, name := range names {
+		u := st.Providers[name]
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\n", name, u.Entries, byteSize(u.Size), u.Hits, u.Misses)
+	}
+	return w.Flush()
+}
+
+// hitRate returns " (N% hit rate)" suffix, or empty string if there were no
+// lookups.
+func hitRate(hits, misses int64) string {
+	if hits+misses == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d%% hit rate)", hits*100/(hits+misses))
+}
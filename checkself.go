@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runCheckSelf compares public keys found in ~/.ssh directory and ssh agent
+// with keys published by user, telling whether files encrypted to that user
+// can be decrypted locally. User is given as argument, or taken from "self"
+// setting, or is the owner of default provider token.
+func runCheckSelf(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("check-self", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github check-self [@handle]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("too many arguments")
+	}
+	handle, err := r.selfHandle(ctx, strings.TrimPrefix(fs.Arg(0), "@"))
+	if err != nil {
+		return err
+	}
+	local, err := localKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if len(local) == 0 {
+		return errors.New("no ssh public keys found in ~/.ssh directory or ssh agent")
+	}
+	// keys may have just been published, don't trust caches
+	ctx = resolve.WithoutCache(ctx)
+	published, err := r.FetchRecipients(ctx, handle)
+	if err != nil {
+		return err
+	}
+	used, err := r.Resolve(ctx, handle)
+	if err != nil && !errors.Is(err, resolve.ErrUnsupportedKeyType) {
+		return err
+	}
+	isPublished := make(map[string]bool, len(published))
+	for _, rc := range published {
+		isPublished[rc.PublicKey] = true
+	}
+	var usable bool
+	for _, k := range local {
+		state := "not published"
+		if isPublished[k.key] {
+			state = "published"
+		}
+		for _, rc := range used {
+			if rc.PublicKey == k.key {
+				state, usable = "published, used for encryption", true
+			}
+		}
+		fmt.Printf("%s\t%s\t%s\n", k.source, resolve.Fingerprint(k.key), state)
+	}
+	for _, rc := range used {
+		if resolve.IsAgeRecipient(rc.PublicKey) {
+			// identities of native age recipients can't be checked
+			fmt.Printf("profile repository\t%s\tpublished, used for encryption\n", rc.PublicKey)
+			usable = true
+		}
+	}
+	if !usable {
+		return fmt.Errorf("files encrypted to @%s can't be decrypted with any of local keys, publish one with age-github publish-key, or adjust \"key\" setting", handle)
+	}
+	return nil
+}
+
+// selfHandle returns handle of the user running check-self: handle itself if
+// not empty, or handle from "self" setting, or the owner of default provider
+// token.
+func (r *resolver) selfHandle(ctx context.Context, handle string) (string, error) {
+	switch {
+	case handle != "":
+		return handle, nil
+	case strings.HasPrefix(r.cfg.Self, "@"):
+		return r.cfg.Self[1:], nil
+	}
+	p := r.cfg.Providers[r.cfg.DefaultProvider]
+	login, err := r.tokenUser(ctx, p)
+	if err != nil {
+		return "", fmt.Errorf("can't tell who you are, give @handle argument or set \"self\" setting: %w", err)
+	}
+	return login, nil
+}
+
+// localKey is an ssh public key found locally.
+type localKey struct {
+	source string // file name, or "agent"
+	key    string // key type and base64-encoded key
+}
+
+// localKeys returns public keys from ~/.ssh/*.pub files and from ssh agent.
+func localKeys(ctx context.Context) ([]localKey, error) {
+	var out []localKey
+	seen := make(map[string]bool)
+	add := func(source, line string) {
+		if k := keyID(strings.TrimSpace(line)); strings.HasPrefix(k, "ssh-") && !seen[k] {
+			seen[k] = true
+			out = append(out, localKey{source: source, key: k})
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		names, err := filepath.Glob(filepath.Join(home, ".ssh", "*.pub"))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			f, err := os.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			scanner := bufio.NewScanner(f)
+			if scanner.Scan() {
+				add(name, scanner.Text())
+			}
+			f.Close()
+		}
+	}
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		// ssh-add exits with non-zero status if agent has no keys
+		out, _ := exec.CommandContext(ctx, "ssh-add", "-L").Output()
+		for _, line := range strings.Split(string(out), "\n") {
+			add("agent", line)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// subcommands are commands handled by age-github itself, selected by the
+// first argument.
+var subcommands = map[string]func(ctx context.Context, r *resolver, args []string) error{
+	"resolve":       runResolve,
+	"export":        runExport,
+	"serve":         runServe,
+	"sops-config":   runSopsConfig,
+	"passage":       runPassage,
+	"sync":          runSync,
+	"rotate":        runRotate,
+	"update":        runUpdate,
+	"fanout":        runFanout,
+	"token":         runToken,
+	"doctor":        runDoctor,
+	"inspect":       runInspect,
+	"who":           runWho,
+	"index":         runIndex,
+	"check-self":    runCheckSelf,
+	"publish-key":   runPublishKey,
+	"verify-commit": runVerifyCommit,
+	"authorize":     runAuthorize,
+	"report":        runReport,
+	"bundle":        runBundle,
+	"sign":          runSign,
+	"verify":        runVerify,
+	"pin":           runPin,
+	"actions":       runActions,
+	"batch":         runBatch,
+	"encrypt":       runEncrypt,
+	"decrypt":       runDecrypt,
+	"k8s":           runK8s,
+	"cache":         runCache,
+	"complete-user": runCompleteUser,
+}
+
+// Output formats of resolve and export subcommands.
+const (
+	formatText           = "text"            // "@handle key fingerprint" lines
+	formatAge            = "age"             // age recipients file, with "# @handle" comments
+	formatAuthorizedKeys = "authorized_keys" // ssh authorized_keys file, keys commented with handles
+	formatJSON           = "json"            // array of {"handle": ..., "keys": [...]} objects
+	formatAllowedSigners = "allowed_signers" // ssh allowed signers file, for git signature verification
+)
+
+// runResolve prints keys of the given handles, by default one line per key
+// holding handle, key, and its fingerprint.
+func runResolve(ctx context.Context, r *resolver, args []string) error {
+	return printKeys(ctx, r, "resolve", formatText, args)
+}
+
+// runExport prints keys of the given handles, by default in format of age
+// recipients file, suitable to be used with age -R flag.
+func runExport(ctx context.Context, r *resolver, args []string) error {
+	return printKeys(ctx, r, "export", formatAge, args)
+}
+
+// printKeys implements resolve and export subcommands, which only differ in
+// their default output format.
+func printKeys(ctx context.Context, r *resolver, name, format string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&format, "format", format, "output `format`: text, age, authorized_keys, json, or allowed_signers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch format {
+	case formatText, formatAge, formatAuthorizedKeys, formatJSON, formatAllowedSigners:
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+	handles, err := collectHandles(fs.Args(), os.Stdin)
+	if err != nil {
+		return err
+	}
+	r.Prefetch(ctx, handles)
+	type result struct {
+		Handle       string   `json:"handle"`
+		Keys         []string `json:"keys"`
+		Fingerprints []string `json:"fingerprints"`
+	}
+	var results []result
+	emails := make(map[string][]string) // user emails by handle, for allowed_signers format
+	w := bufio.NewWriter(os.Stdout)
+	for _, handle := range handles {
+		list, err := r.Recipients(ctx, handle)
+		if err != nil {
+			return err
+		}
+		switch format {
+		case formatText:
+			for _, rc := range list {
+				if resolve.IsAgeRecipient(rc.PublicKey) {
+					fmt.Fprintf(w, "@%s %s\n", handle, rc.PublicKey)
+					continue
+				}
+				fmt.Fprintf(w, "@%s %s %s\n", handle, rc.PublicKey, rc.Fingerprint)
+			}
+		case formatAge:
+			fmt.Fprintf(w, "# @%s\n", handle)
+			for _, rc := range list {
+				fmt.Fprintln(w, rc)
+			}
+		case formatAuthorizedKeys:
+			for _, rc := range list {
+				if !resolve.IsAgeRecipient(rc.PublicKey) {
+					fmt.Fprintf(w, "%s @%s\n", rc.PublicKey, handle)
+				}
+			}
+		case formatAllowedSigners:
+			fmt.Fprintf(w, "# @%s\n", handle)
+			for _, rc := range list {
+				if resolve.IsAgeRecipient(rc.PublicKey) {
+					continue
+				}
+				user := rc.UserID + "@" + rc.Provider
+				principals, ok := emails[user]
+				if !ok {
+					if principals, err = r.userEmails(ctx, user); err != nil {
+						return fmt.Errorf("getting emails of @%s: %w", user, err)
+					}
+					emails[user] = principals
+				}
+				if len(principals) == 0 {
+					warnf("no emails known for @%s, skipping its key", user)
+					continue
+				}
+				fmt.Fprintf(w, "%s namespaces=\"git\" %s\n", strings.Join(principals, ","), rc.PublicKey)
+			}
+		case formatJSON:
+			res := result{Handle: handle, Keys: keyStrings(list)}
+			for _, rc := range list {
+				res.Fingerprints = append(res.Fingerprints, rc.Fingerprint)
+			}
+			results = append(results, res)
+		}
+	}
+	if format == formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// userEmails returns emails git commits of user with "user@provider" handle
+// are likely made with: public profile email, if set, and noreply address the
+// provider assigns to users hiding their emails.
+func (r *resolver) userEmails(ctx context.Context, handle string) ([]string, error) {
+	name, p, err := r.LookupProvider(handle)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	var user struct {
+		ID          int64  `json:"id"`
+		Email       string `json:"email"`        // GitHub
+		PublicEmail string `json:"public_email"` // GitLab
+	}
+	var noreply string
+	switch p.Type {
+	case resolve.ProviderGitlab:
+		var users []struct {
+			ID int64 `json:"id"`
+		}
+		if _, err := r.APIGet(ctx, p, p.APIURL("/users")+"?username="+url.QueryEscape(name), &users); err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, resolve.ErrUserNotFound
+		}
+		if _, err := r.APIGet(ctx, p, p.APIURL("/users/"+strconv.FormatInt(users[0].ID, 10)), &user); err != nil {
+			return nil, err
+		}
+		noreply = fmt.Sprintf("%d-%s@users.noreply.%s", user.ID, name, p.Host)
+	default:
+		if _, err := r.APIGet(ctx, p, p.APIURL("/users/"+url.PathEscape(name)), &user); err != nil {
+			return nil, err
+		}
+		noreply = fmt.Sprintf("%d+%s@users.noreply.%s", user.ID, name, p.Host)
+	}
+	var out []string
+	for _, email := range []string{user.Email, user.PublicEmail} {
+		if email != "" {
+			out = append(out, email)
+		}
+	}
+	if user.ID != 0 {
+		out = append(out, noreply)
+	}
+	return out, nil
+}
+
+// collectHandles returns handles from args, in order. Handles may have an
+// optional @ prefix. The "-" argument is replaced by handles read from stdin,
+// see scanHandles for the format.
+func collectHandles(args []string, stdin io.Reader) ([]string, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no handles given, use - to read them from stdin")
+	}
+	var out []string
+	for _, arg := range args {
+		if arg != "-" {
+			out = append(out, strings.TrimPrefix(arg, "@"))
+			continue
+		}
+		if err := scanHandles(stdin, func(handle string) error {
+			out = append(out, handle)
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("stdin:%w", err)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runCompleteUser prints handles starting with prefix, one per line, for
+// shell completion scripts: aliases, and users found with provider user
+// search. Prefix may start with "@", and end with "@provider" part, which
+// printed handles keep.
+func runCompleteUser(ctx context.Context, r *resolver, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: age-github complete-user prefix")
+	}
+	prefix := args[0]
+	at := ""
+	if strings.HasPrefix(prefix, "@") {
+		at, prefix = "@", prefix[1:]
+	}
+	var out []string
+	if !strings.ContainsRune(prefix, '@') {
+		for name := range r.cfg.Aliases {
+			if strings.HasPrefix(name, prefix) {
+				out = append(out, name)
+			}
+		}
+		sort.Strings(out)
+	}
+	user, p, err := r.LookupProvider(prefix)
+	if errors.Is(err, resolve.ErrUnknownProvider) {
+		// provider name is still being typed
+		i := strings.LastIndexByte(prefix, '@')
+		for name := range r.cfg.Providers {
+			if strings.HasPrefix(name, prefix[i+1:]) {
+				out = append(out, prefix[:i+1]+name)
+			}
+		}
+		sort.Strings(out)
+	} else if err != nil {
+		return err
+	} else if user != "" && p.Type == resolve.ProviderGithub {
+		logins, err := r.SearchUsers(ctx, p, user)
+		if err != nil {
+			return err
+		}
+		for _, login := range logins {
+			out = append(out, login+prefix[len(user):])
+		}
+	}
+	for _, s := range uniqueStrings(out) {
+		fmt.Println(at + s)
+	}
+	return nil
+}
@@ -0,0 +1,870 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// config holds program settings. Its zero value is not usable, see
+// defaultConfig.
+type config struct {
+	CacheDir         string // if empty, cache is disabled
+	SharedCacheDir   string // read-only cache consulted on CacheDir misses, see resolve.Config.SharedCacheDir
+	KeysDir          string // directory of locally maintained keys, see resolve.Config.KeysDir
+	CacheTTL         time.Duration
+	GroupCacheTTL    time.Duration // how long group members are cached, see resolve.Config.GroupCacheTTL
+	CacheMaxEntries  int           // max number of cache entries, 0 means no limit
+	CacheMaxSize     int64         // max total size of cache entries, in bytes, 0 means no limit
+	Timeout          time.Duration
+	KeyPolicy        string   // one of resolve.KeyPolicy* constants
+	MaxKeys          int      // max number of keys considered per user, 0 means no limit
+	MaxResponseSize  int64    // max size of keys response, in bytes
+	Proxy            string   // proxy url, if empty, environment is used
+	ProxyCommand     string   // command to connect through, see commandDialer
+	Backend          string   // age implementation binary: name or path
+	DefaultProvider  string   // provider used for handles without @provider suffix
+	Org              string   // organization for team: groups without org part
+	Socket           string   // daemon unix socket path, if empty, daemon is not used
+	Armor            bool     // encrypt to ASCII-armored format by default
+	Self             string   // recipient added on every encryption: @handle, key, or identity file
+	Keychain         bool     // read tokens not configured otherwise from OS keychain
+	OrgPolicy        bool     // enforce organization policies on keys of group members
+	AuditLog         string   // file to log resolutions to, or "syslog", if empty, no log is kept
+	RequireOrg       string   // organization all resolved users must be members of
+	Require2FA       bool     // require RequireOrg members to have 2FA enabled
+	CredentialHelper string   // command to get tokens not configured otherwise
+	Deny             []string // key fingerprints and handles never used, see resolve.Config.Deny
+	KeyTypes         []string // key types allowed as recipients, if empty, all supported by age
+	RSAKeys          string   // one of resolve.RSAKeys* constants
+	RequireKeyType   string   // ssh key type users must have, narrows KeyTypes to it
+	Signers          []string // handles of users whose ssh signatures on rosters and bundles are trusted
+	MinisignKey      string   // minisign public key trusted to sign rosters and bundles
+	RequireSignature bool     // refuse to use unsigned rosters, recipients files, and bundles
+	Aliases          aliasMap
+	Providers        map[string]*resolve.Provider // keyed by provider name
+
+	// ProfileRecipients enables preferring native age recipients users
+	// publish in age.txt file of their GitHub profile repository.
+	ProfileRecipients bool
+
+	// GistRecipients enables looking up native age recipients users publish
+	// as a public gist with age.pub or age-recipients.txt file.
+	GistRecipients bool
+
+	// Sigstore enables verifying Sigstore signatures of recipients files
+	// fetched from repositories and gists, see verifySigstore.
+	Sigstore bool
+
+	// TransparencyLog is url of Rekor instance, or "rekor" for the public
+	// one, where bindings of resolved handles to keys are logged, see
+	// transparencyLog. If empty, they're not.
+	TransparencyLog string
+
+	// PinMaxAge is how long keys from keys_dir files are trusted without
+	// re-confirmation with pin subcommand, 0 means forever.
+	PinMaxAge time.Duration
+
+	// Keyoxide maps handles to fingerprints of OpenPGP keys whose Keyoxide
+	// identity proofs must link to user account before user keys are
+	// trusted, see verifyKeyoxide. Keys are fetched from Keyserver.
+	Keyoxide  map[string]string
+	Keyserver string
+
+	// Jobs is how many independent encryptions, i.e. fanout copies, run
+	// at once, see runJobs.
+	Jobs int
+
+	// TokenCommands are shell commands printing tokens of providers
+	// that have none configured, keyed by provider name.
+	TokenCommands map[string]string
+
+	// FallbackProviders are providers tried in order after DefaultProvider
+	// for handles of users it doesn't know, see resolve.Config.
+	FallbackProviders []string
+
+	// Recipients are @handles and keys added to every encryption, much like
+	// Self, but also when no recipients are given.
+	Recipients []string
+
+	// Progress enables progress reporting of age running on large inputs,
+	// when stderr is a terminal, see runWithProgress.
+	Progress bool
+
+	// Dial, if set, is used to make network connections instead of
+	// connecting directly or through proxy.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// topLevelSettings lists settings that can be set at top level of config
+// file, with environment variables named as AGE_GITHUB_ prefix followed by
+// upper-cased setting name, and with command line flags named as setting name
+// with underscores replaced by hyphens.
+var topLevelSettings = []string{
+	"cache_dir",
+	"shared_cache_dir",
+	"keys_dir",
+	"cache_ttl",
+	"group_cache_ttl",
+	"cache_max_entries",
+	"cache_max_size",
+	"timeout",
+	"key",
+	"max_keys",
+	"max_response_size",
+	"proxy",
+	"token",
+	"token_command",
+	"backend",
+	"default_provider",
+	"org",
+	"socket",
+	"armor",
+	"self",
+	"keys_url",
+	"keychain",
+	"credential_helper",
+	"proxy_command",
+	"deny",
+	"key_types",
+	"rsa_keys",
+	"require_key_type",
+	"org_policy",
+	"audit_log",
+	"require_org",
+	"require_2fa",
+	"signers",
+	"minisign_key",
+	"require_signature",
+	"pin_max_age",
+	"profile_recipients",
+	"gist_recipients",
+	"keyserver",
+	"sigstore",
+	"transparency_log",
+	"jobs",
+	"progress",
+	"recipients",
+	"fallback_providers",
+}
+
+// providerSettings lists settings of [providers.NAME] config file sections,
+// also set with AGE_GITHUB_PROVIDER_<NAME>_<SETTING> environment variables.
+var providerSettings = []string{
+	"type",
+	"host",
+	"token",
+	"token_command",
+	"keys_url",
+	"org",
+	"api",
+	"path",
+	"base_dn",
+	"bind_dn",
+	"tls",
+	"cache_ttl",
+	"mirrors",
+}
+
+// boolSettings lists top-level settings which are booleans, so that their
+// command line flags can be given without value.
+var boolSettings = map[string]bool{
+	"armor":              true,
+	"keychain":           true,
+	"org_policy":         true,
+	"require_2fa":        true,
+	"require_signature":  true,
+	"profile_recipients": true,
+	"gist_recipients":    true,
+	"sigstore":           true,
+	"progress":           true,
+}
+
+// githubProviderName is the name of always configured github.com provider.
+const githubProviderName = "github"
+
+func defaultConfig() *config {
+	cfg := &config{
+		CacheTTL:        time.Hour,
+		GroupCacheTTL:   5 * time.Minute,
+		Timeout:         10 * time.Second,
+		KeyPolicy:       resolve.KeyPolicyFirst,
+		RSAKeys:         resolve.RSAKeysWarn,
+		MaxKeys:         10,
+		Jobs:            runtime.NumCPU(),
+		MaxResponseSize: 256 << 10,
+		Backend:         "age",
+		DefaultProvider: githubProviderName,
+		Aliases:         make(aliasMap),
+		Keyoxide:        make(map[string]string),
+		TokenCommands:   make(map[string]string),
+		Keyserver:       "https://keys.openpgp.org",
+		Providers: map[string]*resolve.Provider{
+			githubProviderName: {Name: githubProviderName, Type: resolve.ProviderGithub, Host: "github.com"},
+		},
+	}
+	// in GitHub Actions workflows, including ones of GitHub Enterprise,
+	// built-in provider is the instance workflow runs on
+	if u, err := url.Parse(os.Getenv("GITHUB_SERVER_URL")); err == nil && u.Host != "" {
+		p := cfg.Providers[githubProviderName]
+		p.Host = u.Host
+		if api := os.Getenv("GITHUB_API_URL"); api != "" {
+			p.API = strings.TrimSuffix(api, "/")
+		}
+	}
+	if dir, err := os.UserCacheDir(); err == nil && dir != "" {
+		cfg.CacheDir = filepath.Join(dir, "age-github")
+		cfg.Socket = filepath.Join(cfg.CacheDir, "daemon.sock")
+	}
+	if dir, err := os.UserConfigDir(); err == nil && dir != "" {
+		cfg.KeysDir = filepath.Join(dir, "age-github", "keys.d")
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		cfg.Socket = filepath.Join(dir, "age-github.sock")
+	}
+	return cfg
+}
+
+// loadConfig reads config file and returns config with defaults overridden by
+// file values. If name is empty, "age-github/config.toml" file under
+// os.UserConfigDir directory is used, and it's not an error if it does not
+// exist.
+func loadConfig(name string) (*config, error) {
+	cfg := defaultConfig()
+	aliases, err := loadAliases()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range aliases {
+		cfg.Aliases[k] = v
+	}
+	optional := name == ""
+	if optional {
+		dir, err := os.UserConfigDir()
+		if err != nil || dir == "" {
+			return cfg, nil
+		}
+		name = filepath.Join(dir, "age-github", "config.toml")
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if err := parseConfig(f, func(section, key string, value interface{}) error {
+		return cfg.set(section, key, value)
+	}); err != nil {
+		return nil, fmt.Errorf("%s:%w", name, err)
+	}
+	return cfg, nil
+}
+
+// set applies a single config file value. Value is either a string, an
+// int64, a bool, or a []string.
+func (c *config) set(section, key string, value interface{}) error {
+	switch {
+	case section == "" && key == "deny":
+		v, err := listSetting(key, value)
+		if err != nil {
+			return err
+		}
+		c.Deny = append(c.Deny, v...)
+		return nil
+	case section == "" && key == "key_types":
+		v, err := listSetting(key, value)
+		if err != nil {
+			return err
+		}
+		c.KeyTypes = v
+		return nil
+	case section == "" && key == "fallback_providers":
+		v, err := listSetting(key, value)
+		if err != nil {
+			return err
+		}
+		c.FallbackProviders = v
+		return nil
+	case section == "" && key == "recipients":
+		v, err := listSetting(key, value)
+		if err != nil {
+			return err
+		}
+		c.Recipients = v
+		return nil
+	case section == "" && key == "signers":
+		v, err := listSetting(key, value)
+		if err != nil {
+			return err
+		}
+		for i := range v {
+			v[i] = strings.TrimPrefix(v[i], "@")
+		}
+		c.Signers = v
+		return nil
+	case section == "":
+		// top-level settings can also come from environment and command
+		// line, so they're handled as strings
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case bool:
+			s = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("%s: string value expected", key)
+		}
+		switch key {
+		case "cache_ttl", "group_cache_ttl", "timeout", "pin_max_age":
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			switch key {
+			case "timeout":
+				c.Timeout = d
+			case "pin_max_age":
+				c.PinMaxAge = d
+			case "group_cache_ttl":
+				c.GroupCacheTTL = d
+			default:
+				c.CacheTTL = d
+			}
+		case "cache_dir":
+			c.CacheDir = s
+		case "shared_cache_dir":
+			c.SharedCacheDir = s
+		case "keys_dir":
+			c.KeysDir = s
+		case "key":
+			c.KeyPolicy = s
+		case "rsa_keys":
+			c.RSAKeys = s
+		case "require_key_type":
+			c.RequireKeyType = s
+		case "max_keys", "cache_max_entries":
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return fmt.Errorf("%s: non-negative integer expected", key)
+			}
+			if key == "max_keys" {
+				c.MaxKeys = n
+			} else {
+				c.CacheMaxEntries = n
+			}
+		case "jobs":
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("%s: positive integer expected", key)
+			}
+			c.Jobs = n
+		case "max_response_size":
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("%s: positive integer expected", key)
+			}
+			c.MaxResponseSize = n
+		case "cache_max_size":
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("%s: non-negative integer expected", key)
+			}
+			c.CacheMaxSize = n
+		case "proxy":
+			c.Proxy = s
+		case "proxy_command":
+			c.ProxyCommand = s
+		case "token":
+			c.Providers[githubProviderName].Token = s
+		case "token_command":
+			c.TokenCommands[githubProviderName] = s
+		case "backend":
+			c.Backend = s
+		case "default_provider":
+			c.DefaultProvider = s
+		case "org":
+			c.Org = s
+		case "socket":
+			c.Socket = s
+		case "armor", "keychain", "org_policy", "require_2fa", "require_signature", "profile_recipients", "gist_recipients", "sigstore", "progress":
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%s: boolean expected", key)
+			}
+			switch key {
+			case "armor":
+				c.Armor = v
+			case "keychain":
+				c.Keychain = v
+			case "org_policy":
+				c.OrgPolicy = v
+			case "require_signature":
+				c.RequireSignature = v
+			case "profile_recipients":
+				c.ProfileRecipients = v
+			case "gist_recipients":
+				c.GistRecipients = v
+			case "sigstore":
+				c.Sigstore = v
+			case "progress":
+				c.Progress = v
+			default:
+				c.Require2FA = v
+			}
+		case "self":
+			c.Self = s
+		case "keys_url":
+			c.Providers[githubProviderName].KeysURL = s
+		case "credential_helper":
+			c.CredentialHelper = s
+		case "audit_log":
+			c.AuditLog = s
+		case "require_org":
+			c.RequireOrg = strings.TrimPrefix(s, "@")
+		case "minisign_key":
+			c.MinisignKey = s
+		case "transparency_log":
+			c.TransparencyLog = s
+		case "keyserver":
+			c.Keyserver = strings.TrimSuffix(s, "/")
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		return nil
+	case section == "aliases":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("aliases.%s: string value expected", key)
+		}
+		c.Aliases[strings.TrimPrefix(key, "@")] = strings.TrimPrefix(s, "@")
+		return nil
+	case section == "keyoxide":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("keyoxide.%s: string value expected", key)
+		}
+		fpr := strings.ToUpper(strings.TrimPrefix(strings.Join(strings.Fields(s), ""), "openpgp4fpr:"))
+		if len(fpr) != 40 && len(fpr) != 64 {
+			return fmt.Errorf("keyoxide.%s: OpenPGP key fingerprint expected", key)
+		}
+		c.Keyoxide[strings.ToLower(strings.TrimPrefix(key, "@"))] = fpr
+		return nil
+	case strings.HasPrefix(section, "providers."):
+		name := strings.TrimPrefix(section, "providers.")
+		if !isProviderSetting(key) {
+			return fmt.Errorf("%s: unknown setting %q", section, key)
+		}
+		p, ok := c.Providers[name]
+		if !ok {
+			p = &resolve.Provider{Name: name, Type: resolve.ProviderGithub}
+			c.Providers[name] = p
+		}
+		if key == "mirrors" {
+			v, err := listSetting(section+"."+key, value)
+			if err != nil {
+				return err
+			}
+			p.Mirrors = v
+			return nil
+		}
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s.%s: string value expected", section, key)
+		}
+		switch key {
+		case "type":
+			p.Type = s
+		case "host":
+			p.Host = s
+		case "token":
+			p.Token = s
+		case "token_command":
+			c.TokenCommands[name] = s
+		case "keys_url":
+			p.KeysURL = s
+		case "org":
+			p.Org = s
+		case "api":
+			p.API = strings.TrimSuffix(s, "/")
+		case "path":
+			p.Path = s
+		case "base_dn":
+			p.BaseDN = s
+		case "bind_dn":
+			p.BindDN = s
+		case "tls":
+			p.TLS = s
+		case "cache_ttl":
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", section, key, err)
+			}
+			if d == 0 {
+				d = -1 // unlike unset setting, disables caching
+			}
+			p.CacheTTL = d
+		default:
+			return fmt.Errorf("%s: unknown setting %q", section, key)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown section %q", section)
+}
+
+// isProviderSetting reports whether key is one of providerSettings.
+func isProviderSetting(key string) bool {
+	for _, k := range providerSettings {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// listSetting returns value of a list setting, which is an array of strings in
+// config file, or a comma or space separated string in environment and on
+// command line.
+func listSetting(name string, value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []string:
+		return v, nil
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }), nil
+	}
+	return nil, fmt.Errorf("%s: array of strings expected", name)
+}
+
+func (c *config) validate() error {
+	switch c.KeyPolicy {
+	case resolve.KeyPolicyFirst, resolve.KeyPolicyAll, resolve.KeyPolicyEd25519:
+	default:
+		return fmt.Errorf("unsupported key policy %q", c.KeyPolicy)
+	}
+	switch c.RSAKeys {
+	case resolve.RSAKeysIgnore, resolve.RSAKeysWarn, resolve.RSAKeysError:
+	default:
+		return fmt.Errorf("rsa_keys must be %q, %q, or %q", resolve.RSAKeysIgnore, resolve.RSAKeysWarn, resolve.RSAKeysError)
+	}
+	if t := c.RequireKeyType; t != "" {
+		if !strings.HasPrefix(t, "ssh-") {
+			t = "ssh-" + t
+		}
+		if t != "ssh-ed25519" && t != "ssh-rsa" {
+			return fmt.Errorf("require_key_type must be %q or %q", "ed25519", "rsa")
+		}
+		allowed := len(c.KeyTypes) == 0
+		for _, kt := range c.KeyTypes {
+			allowed = allowed || kt == t
+		}
+		if !allowed {
+			return fmt.Errorf("require_key_type %q is not one of key_types", c.RequireKeyType)
+		}
+		c.KeyTypes = []string{t}
+	}
+	if _, ok := c.Providers[c.DefaultProvider]; !ok {
+		// like in handles, provider may be given by its host
+		for name, p := range c.Providers {
+			if p.Host == c.DefaultProvider {
+				c.DefaultProvider = name
+				break
+			}
+		}
+	}
+	if _, ok := c.Providers[c.DefaultProvider]; !ok {
+		return fmt.Errorf("default provider %q is not configured", c.DefaultProvider)
+	}
+	if c.Backend == "" {
+		return errors.New("empty backend")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+	if c.Proxy != "" {
+		if _, err := url.Parse(c.Proxy); err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+	}
+	for _, name := range c.FallbackProviders {
+		if _, ok := c.Providers[name]; !ok {
+			return fmt.Errorf("fallback provider %q is not configured", name)
+		}
+	}
+	for _, s := range c.Recipients {
+		if !isBatchRecipient(s) {
+			return fmt.Errorf("recipients: %q is neither a @handle nor a key", s)
+		}
+	}
+	for name, p := range c.Providers {
+		switch p.Type {
+		case resolve.ProviderGithub, resolve.ProviderGitlab, resolve.ProviderVault:
+		case resolve.ProviderAWSIAM:
+			if p.Host == "" {
+				p.Host = "iam.amazonaws.com"
+			}
+		case resolve.ProviderOSLogin:
+			if p.Host == "" {
+				p.Host = "oslogin.googleapis.com"
+			}
+		case resolve.ProviderLDAP:
+			if p.BaseDN == "" {
+				return fmt.Errorf("provider %q: ldap providers need base_dn", name)
+			}
+			switch p.TLS {
+			case "", resolve.LDAPS, resolve.LDAPStartTLS, resolve.LDAPPlain:
+			default:
+				return fmt.Errorf("provider %q: tls must be %q, %q, or %q", name, resolve.LDAPS, resolve.LDAPStartTLS, resolve.LDAPPlain)
+			}
+		default:
+			return fmt.Errorf("provider %q: unsupported type %q", name, p.Type)
+		}
+		if p.Host == "" {
+			return fmt.Errorf("provider %q: empty host", name)
+		}
+		for _, m := range p.Mirrors {
+			if !validKeysURL(m) {
+				return fmt.Errorf("provider %q: mirror %q must be an absolute url with %%s in place of user name", name, m)
+			}
+		}
+		if p.Path != "" && (p.Type != resolve.ProviderVault || !strings.Contains(p.Path, "%s")) {
+			return fmt.Errorf("provider %q: path is only supported by vault providers, and must have %%s in place of user name", name)
+		}
+		if (p.BaseDN != "" || p.BindDN != "" || p.TLS != "") && p.Type != resolve.ProviderLDAP {
+			return fmt.Errorf("provider %q: base_dn, bind_dn, and tls are only supported by ldap providers", name)
+		}
+		if p.KeysURL != "" && !validKeysURL(p.KeysURL) {
+			return fmt.Errorf("provider %q: keys url %q must be an absolute url with %%s in place of user name", name, p.KeysURL)
+		}
+	}
+	return nil
+}
+
+// validKeysURL reports whether s is a valid keys url template.
+func validKeysURL(s string) bool {
+	u, err := url.Parse(strings.Replace(s, "%s", "user", -1))
+	return err == nil && u.Host != "" && strings.Contains(s, "%s")
+}
+
+// loadEnv overrides config with values from environment variables:
+// AGE_GITHUB_<SETTING> for top-level settings (see topLevelSettings),
+// AGE_GITHUB_PROVIDER_<NAME>_<SETTING> for provider settings, and
+// AGE_GITHUB_ALIASES holding whitespace- or comma-separated name=handle pairs.
+func (c *config) loadEnv(environ []string) error {
+	const prefix = "AGE_GITHUB_"
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 && strings.HasPrefix(kv, prefix) && kv[i+1:] != "" {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	for _, key := range topLevelSettings {
+		name := prefix + strings.ToUpper(key)
+		if v, ok := env[name]; ok {
+			if err := c.set("", key, v); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	for name, v := range env {
+		if !strings.HasPrefix(name, prefix+"PROVIDER_") {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix+"PROVIDER_")
+		// both provider and setting names may have underscores, so match
+		// the longest known setting name suffix
+		var key string
+		for _, k := range providerSettings {
+			suffix := "_" + strings.ToUpper(k)
+			if len(k) > len(key) && len(rest) > len(suffix) && strings.HasSuffix(rest, suffix) {
+				key = k
+			}
+		}
+		if key == "" {
+			return fmt.Errorf("%s: want %sPROVIDER_<NAME>_<SETTING> variable name with known setting", name, prefix)
+		}
+		section := "providers." + strings.ToLower(rest[:len(rest)-len(key)-1])
+		if err := c.set(section, key, v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if v, ok := env[prefix+"ALIASES"]; ok {
+		for _, pair := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			i := strings.IndexByte(pair, '=')
+			if i <= 0 {
+				return fmt.Errorf("%sALIASES: want name=handle pairs", prefix)
+			}
+			if err := c.set("aliases", pair[:i], pair[i+1:]); err != nil {
+				return fmt.Errorf("%sALIASES: %w", prefix, err)
+			}
+		}
+	}
+	return nil
+}
+
+// httpClient returns http client respecting configured proxy, proxy command,
+// or dial function.
+func (c *config) httpClient() (*http.Client, error) {
+	if c.Proxy == "" && c.ProxyCommand == "" && c.Dial == nil {
+		return http.DefaultClient, nil
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	switch {
+	case c.Dial != nil:
+		tr.DialContext, tr.Proxy = c.Dial, nil
+	case c.ProxyCommand != "":
+		tr.DialContext, tr.Proxy = commandDialer(c.ProxyCommand), nil
+	default:
+		u, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+// parseConfig parses a subset of TOML format: [section] headers, and
+// key = value pairs, where value is a string (basic or literal), an integer,
+// a boolean, or an array of strings, which may span multiple lines.
+func parseConfig(r io.Reader, fn func(section, key string, value interface{}) error) error {
+	var section string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("%d: malformed section header", n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return fmt.Errorf("%d: want key = value", n)
+		}
+		key, raw := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if k, err := strconv.Unquote(key); err == nil {
+			key = k
+		}
+		start := n
+		for strings.HasPrefix(raw, "[") && !strings.HasSuffix(raw, "]") && scanner.Scan() {
+			n++
+			raw += " " + strings.TrimSpace(stripComment(scanner.Text()))
+		}
+		value, err := parseConfigValue(raw)
+		if err != nil {
+			return fmt.Errorf("%d: %w", start, err)
+		}
+		if err := fn(section, key, value); err != nil {
+			return fmt.Errorf("%d: %w", start, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func parseConfigValue(s string) (interface{}, error) {
+	switch {
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, `'`):
+		if len(s) < 2 || !strings.HasSuffix(s, `'`) {
+			return nil, errors.New("unterminated string")
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, errors.New("unterminated array")
+		}
+		var out []string
+		for _, elem := range splitArray(s[1 : len(s)-1]) {
+			if elem = strings.TrimSpace(elem); elem == "" {
+				continue
+			}
+			v, err := parseConfigValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			str, ok := v.(string)
+			if !ok {
+				return nil, errors.New("only arrays of strings are supported")
+			}
+			out = append(out, str)
+		}
+		return out, nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported value %q", s)
+}
+
+// splitArray splits array elements on commas, taking quoted strings into
+// account, like stripComment does.
+func splitArray(s string) []string {
+	var out []string
+	var quote rune
+	var escaped bool
+	start := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:])
+}
+
+// stripComment removes trailing # comment from a line, taking quoted strings
+// into account.
+func stripComment(s string) string {
+	var quote rune
+	var escaped bool
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return s[:i]
+		}
+	}
+	return s
+}
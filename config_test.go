@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	type setting struct {
+		section, key string
+		value        interface{}
+	}
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    []setting
+		wantErr string // prefix of error, which starts with line number
+	}{
+		{
+			name: "values",
+			input: `# comment
+cache_ttl = "1h" # trailing comment
+max_keys = 5
+armor = true
+keychain = false
+path = 'C:\keys\%s' # literal string keeps backslashes
+hash = "a#b" # not a comment inside string
+quote = "say \"hi\" # still string"
+"quoted key" = "v"
+
+[providers.ghe]
+host = "ghe.corp"
+[ aliases ]
+boss = "alice"
+`,
+			want: []setting{
+				{"", "cache_ttl", "1h"},
+				{"", "max_keys", int64(5)},
+				{"", "armor", true},
+				{"", "keychain", false},
+				{"", "path", `C:\keys\%s`},
+				{"", "hash", "a#b"},
+				{"", "quote", `say "hi" # still string`},
+				{"", "quoted key", "v"},
+				{"providers.ghe", "host", "ghe.corp"},
+				{"aliases", "boss", "alice"},
+			},
+		},
+		{
+			name: "arrays",
+			input: `deny = ["mallory", 'eve',]
+empty = []
+mirrors = [
+	"https://a/%s", # first
+	"https://b/%s",
+]
+commas = ["https://a/%s?x=1,2", 'b,c', "d\",e"]
+after = 1
+`,
+			want: []setting{
+				{"", "deny", []string{"mallory", "eve"}},
+				{"", "empty", []string(nil)},
+				{"", "mirrors", []string{"https://a/%s", "https://b/%s"}},
+				{"", "commas", []string{"https://a/%s?x=1,2", "b,c", `d",e`}},
+				{"", "after", int64(1)},
+			},
+		},
+		{name: "malformed section", input: "a = 1\n[providers.x\n", wantErr: "2: malformed section header"},
+		{name: "missing value", input: "\n\nkey\n", wantErr: "3: want key = value"},
+		{name: "missing key", input: "= 1\n", wantErr: "1: want key = value"},
+		{name: "unterminated string", input: "key = \"abc\n", wantErr: "1: "},
+		{name: "unterminated literal", input: "key = 'abc\n", wantErr: "1: unterminated string"},
+		{name: "unterminated array", input: "key = [\"a\",\n\"b\"\n", wantErr: "1: unterminated array"},
+		{name: "array of integers", input: "key = [1, 2]\n", wantErr: "1: only arrays of strings are supported"},
+		{name: "bare word", input: "x = 1\nkey = value\n", wantErr: "2: unsupported value"},
+		{name: "float", input: "key = 1.5\n", wantErr: "1: unsupported value"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []setting
+			err := parseConfig(strings.NewReader(tc.input), func(section, key string, value interface{}) error {
+				got = append(got, setting{section, key, value})
+				return nil
+			})
+			if tc.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got  %#v\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigCallbackError(t *testing.T) {
+	cfg := defaultConfig()
+	err := parseConfig(strings.NewReader("cache_ttl = \"1h\"\n[providers.ghe]\ncolor = \"red\"\n"), func(section, key string, value interface{}) error {
+		return cfg.set(section, key, value)
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "3: ") {
+		t.Fatalf("got error %v, want one for line 3", err)
+	}
+	if _, ok := cfg.Providers["ghe"]; ok {
+		t.Error("provider created for unknown setting")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	cfg := defaultConfig()
+	err := cfg.loadEnv([]string{
+		"AGE_GITHUB_CACHE_TTL=2h",
+		"AGE_GITHUB_PROVIDER_GHE_HOST=ghe.corp",
+		"AGE_GITHUB_PROVIDER_GHE_CACHE_TTL=5m",
+		"AGE_GITHUB_PROVIDER_GHE_TOKEN_COMMAND=pass ghe",
+		"AGE_GITHUB_PROVIDER_CORP_LDAP_BASE_DN=dc=corp",
+		"AGE_GITHUB_PROVIDER_CORP_LDAP_BIND_DN=cn=reader",
+		"AGE_GITHUB_PROVIDER_MY_KEYS_KEYS_URL=https://keys.corp/%s.keys",
+		"AGE_GITHUB_PROVIDER_GHE_MIRRORS=https://a/%s https://b/%s",
+		"AGE_GITHUB_ALIASES=boss=alice, ops=@team:corp/ops",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CacheTTL != 2*time.Hour {
+		t.Errorf("CacheTTL: got %v, want 2h", cfg.CacheTTL)
+	}
+	ghe := cfg.Providers["ghe"]
+	if ghe == nil {
+		t.Fatal("ghe provider not created")
+	}
+	if ghe.Host != "ghe.corp" || ghe.CacheTTL != 5*time.Minute {
+		t.Errorf("ghe provider: got host %q, cache_ttl %v", ghe.Host, ghe.CacheTTL)
+	}
+	if want := []string{"https://a/%s", "https://b/%s"}; !reflect.DeepEqual(ghe.Mirrors, want) {
+		t.Errorf("ghe mirrors: got %q, want %q", ghe.Mirrors, want)
+	}
+	if got := cfg.TokenCommands["ghe"]; got != "pass ghe" {
+		t.Errorf("ghe token_command: got %q", got)
+	}
+	if p := cfg.Providers["corp_ldap"]; p == nil || p.BaseDN != "dc=corp" || p.BindDN != "cn=reader" {
+		t.Errorf("corp_ldap provider: got %+v", p)
+	}
+	if p := cfg.Providers["my_keys"]; p == nil || p.KeysURL != "https://keys.corp/%s.keys" {
+		t.Errorf("my_keys provider: got %+v", p)
+	}
+	if len(cfg.Aliases) != 2 {
+		t.Errorf("aliases: got %v", cfg.Aliases)
+	}
+
+	for _, kv := range []string{
+		"AGE_GITHUB_PROVIDER_GHE_COLOR=red",
+		"AGE_GITHUB_PROVIDER_HOST=ghe.corp",
+		"AGE_GITHUB_PROVIDER_GHE_CACHE_TTL=soon",
+	} {
+		cfg := defaultConfig()
+		if err := cfg.loadEnv([]string{kv}); err == nil {
+			t.Errorf("%s: no error", kv)
+		}
+		if _, ok := cfg.Providers["ghe"]; ok && kv == "AGE_GITHUB_PROVIDER_GHE_COLOR=red" {
+			t.Errorf("%s: provider created for unknown setting", kv)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// credentialHelperToken asks credential helper for host token. Helper is
+// a shell command, called with "get" argument, that speaks the same protocol
+// as git credential helpers: it reads key=value lines describing credential
+// (protocol and host), and prints key=value lines, of which the "password"
+// one is used as token. Helper printing nothing means there's no token.
+func credentialHelperToken(helper, host string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", helper+" get")
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", helper+" get")
+	}
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=" + host + "\n\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if kv := strings.SplitN(scanner.Text(), "=", 2); len(kv) == 2 && kv[0] == "password" {
+			return strings.TrimSpace(kv[1]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("reading helper output: %w", err)
+	}
+	return "", nil
+}
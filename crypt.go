@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runEncrypt encrypts a file to recipients given as @handles, keys, or
+// recipients files, picking output name and format itself, so that its users
+// don't have to learn age flags: output is input name with .age suffix, or
+// stdout for stdin, which is ASCII-armored if it's a terminal. Without
+// recipients, file is encrypted to "recipients" setting, or to "self" one.
+func runEncrypt(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	var recipients, recipientsFiles stringList
+	fs.Var(&recipients, "r", "`recipient`, @handle or key, may be repeated")
+	fs.Var(&recipientsFiles, "R", "recipients `file`, may be repeated")
+	armor := fs.Bool("a", false, "encrypt to ASCII-armored format")
+	output := fs.String("o", "", "output `file`, input name with .age suffix by default")
+	force := fs.Bool("f", false, "overwrite existing output file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github encrypt [-a] [-f] [-o output] [-r recipient]... [-R file]... [file]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	input, err := cryptInput(fs)
+	if err != nil {
+		return err
+	}
+	out := *output
+	if out == "" && input != "" {
+		out = input + ".age"
+	}
+	if err := checkOutput(out, *force); err != nil {
+		return err
+	}
+	ageArgs := []string{"-e"}
+	if *armor || ((out == "" || out == "-") && isTerminal(os.Stdout)) {
+		ageArgs = append(ageArgs, "-a")
+	}
+	for _, s := range recipients {
+		ageArgs = append(ageArgs, "-r", s)
+	}
+	for _, s := range recipientsFiles {
+		ageArgs = append(ageArgs, "-R", s)
+	}
+	if len(recipients)+len(recipientsFiles)+len(r.cfg.Recipients) == 0 {
+		if r.cfg.Self == "" {
+			fs.Usage()
+			return errors.New("no recipients given, and neither self nor recipients setting is set")
+		}
+		keys, err := r.selfKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("self: %w", err)
+		}
+		for _, k := range keys {
+			ageArgs = append(ageArgs, "-r", k)
+		}
+	}
+	return runAge(ctx, r, cryptArgs(ageArgs, out, input), ageOptions{strict: true})
+}
+
+// runDecrypt decrypts a file, writing it under input name with .age suffix
+// removed, or to stdout for stdin. Without identities given, they're found
+// among ~/.ssh private keys, age keys file, and "self" setting, see
+// findIdentities.
+func runDecrypt(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	var identities stringList
+	fs.Var(&identities, "i", "identity `file`, may be repeated")
+	output := fs.String("o", "", "output `file`, input name without .age suffix by default")
+	force := fs.Bool("f", false, "overwrite existing output file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github decrypt [-f] [-o output] [-i identity]... [file.age]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	input, err := cryptInput(fs)
+	if err != nil {
+		return err
+	}
+	out := *output
+	if out == "" && input != "" {
+		if out = strings.TrimSuffix(input, ".age"); out == input {
+			return fmt.Errorf("can't name output after %s without .age suffix, use -o", input)
+		}
+	}
+	if err := checkOutput(out, *force); err != nil {
+		return err
+	}
+	if len(identities) == 0 {
+		if identities, err = findIdentities(ctx, r, input); err != nil {
+			return err
+		}
+	}
+	ageArgs := []string{"-d"}
+	for _, id := range identities {
+		ageArgs = append(ageArgs, "-i", id)
+	}
+	return runAge(ctx, r, cryptArgs(ageArgs, out, input), ageOptions{})
+}
+
+// cryptInput returns input file name of encrypt or decrypt subcommand, or
+// an empty string for stdin.
+func cryptInput(fs *flag.FlagSet) (string, error) {
+	switch {
+	case fs.NArg() > 1:
+		fs.Usage()
+		return "", errors.New("only one file can be given")
+	case fs.Arg(0) == "-":
+		return "", nil
+	}
+	return fs.Arg(0), nil
+}
+
+// checkOutput refuses to overwrite existing file, unless force is set.
+func checkOutput(name string, force bool) error {
+	if name == "" || name == "-" || force {
+		return nil
+	}
+	if _, err := os.Stat(name); err == nil {
+		return fmt.Errorf("%s already exists, use -f to overwrite it", name)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// cryptArgs adds output and input to age arguments, both are optional.
+func cryptArgs(ageArgs []string, output, input string) []string {
+	if output != "" && output != "-" {
+		ageArgs = append(ageArgs, "-o", output)
+	}
+	if input != "" {
+		ageArgs = append(ageArgs, "--", input)
+	}
+	return ageArgs
+}
+
+// findIdentities returns identity files which may decrypt input: ssh private
+// keys from ~/.ssh whose public keys the file is encrypted to, and, if it has
+// native age recipients, the default age keys file and "self" setting if it
+// names an identity file. Without input file, as with stdin, all such files
+// that exist are returned.
+func findIdentities(ctx context.Context, r *resolver, input string) ([]string, error) {
+	want := make(map[string]bool)
+	if input != "" {
+		stanzas, _, err := readAgeHeader(input)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", input, err)
+		}
+		for _, s := range stanzas {
+			if id := stanzaID(s); id != "" {
+				want[id] = true
+			}
+		}
+	}
+	matches := func(id string) bool { return input == "" || want[id] }
+	var out []string
+	local, err := localKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range local {
+		name := strings.TrimSuffix(k.source, ".pub")
+		if name == k.source || !matches(recipientID(k.key)) {
+			continue // agent keys can't be used by age
+		}
+		if _, err := os.Stat(name); err == nil {
+			out = append(out, name)
+		}
+	}
+	if matches("X25519") {
+		var candidates []string
+		if dir, err := os.UserConfigDir(); err == nil {
+			candidates = append(candidates, filepath.Join(dir, "age", "keys.txt"))
+		}
+		if self := r.cfg.Self; self != "" && !strings.HasPrefix(self, "@") &&
+			!strings.HasPrefix(self, "age1") && !strings.HasPrefix(self, "ssh-") {
+			candidates = append(candidates, self)
+		}
+		for _, name := range candidates {
+			if _, err := os.Stat(name); err == nil {
+				out = append(out, name)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no matching identity found in ~/.ssh, age keys file, or self setting, use -i")
+	}
+	return uniqueStrings(out), nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runDaemon serves keys resolution requests over unix socket, keeping
+// resolved keys in memory, so that repeated invocations of age-github don't
+// have to hit disk cache or network.
+//
+// If started by systemd socket activation, daemon serves sockets it's passed,
+// so that it's only started when needed.
+//
+// Metrics are served at /metrics over the socket, and, with -metrics flag, over
+// TCP, so that Prometheus can scrape them.
+func runDaemon(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	metricsAddr := fs.String("metrics", "", "`address` to serve metrics at over TCP, i.e. localhost:9090")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: age-github daemon [-metrics addr]")
+	}
+	lns, err := activationListeners()
+	if err != nil {
+		return err
+	}
+	if len(lns) == 0 {
+		ln, err := listenSocket(r.cfg.Socket)
+		if err != nil {
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	for _, ln := range lns {
+		defer ln.Close()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/", r.metrics.instrument("keys", r.tracer.handler("GET /v1/keys/", r.handleKeys)))
+	mux.HandleFunc("/metrics", r.handleMetrics)
+	srv := &http.Server{Handler: mux}
+	var metricsSrv *http.Server
+	if *metricsAddr != "" {
+		mln, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			return err
+		}
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc("/metrics", r.handleMetrics)
+		metricsSrv = &http.Server{Handler: metricsMux, ReadHeaderTimeout: 10 * time.Second}
+		go metricsSrv.Serve(mln)
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		if metricsSrv != nil {
+			_ = metricsSrv.Shutdown(ctx)
+		}
+		_ = srv.Shutdown(ctx)
+	}()
+	for _, ln := range lns[1:] {
+		go srv.Serve(ln)
+	}
+	if err := srv.Serve(lns[0]); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// listenSocket listens on unix socket, which only current user can connect
+// to.
+func listenSocket(socket string) (net.Listener, error) {
+	if socket == "" {
+		return nil, errors.New("daemon socket path is not set")
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("daemon is already running on %s", socket)
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(socket) // stale socket of daemon that didn't exit cleanly
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// listenFDsStart is the first file descriptor passed with socket activation.
+const listenFDsStart = 3
+
+// activationListeners returns listeners passed by systemd socket activation,
+// see sd_listen_fds(3), or nil, if process was not socket activated.
+func activationListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("socket activation: invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	// child processes, i.e. gpg, must not take them for their own
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+	var lns []net.Listener
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f) // it uses a duplicate, not inherited by child processes
+		f.Close()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("socket activation: file descriptor %d: %w", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// handleKeys responds to GET /v1/keys/<user@provider> requests with all
+// published keys of the user, one per line.
+func (r *resolver) handleKeys(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	username, p, err := r.LookupProvider(strings.TrimPrefix(req.URL.Path, "/v1/keys/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := r.FetchKeysData(req.Context(), username, p)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatusCode(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// daemonKeys asks daemon for keys of a user, handle must be in
+// "user@provider" form. If daemon cannot be reached, returned error is
+// *resolve.NetworkError.
+func daemonKeys(ctx context.Context, daemon *http.Client, handle string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://daemon/v1/keys/"+url.PathEscape(handle), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := daemon.Do(req)
+	if err != nil {
+		return nil, &resolve.NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &resolve.NetworkError{Err: err}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusBadRequest:
+		return nil, resolve.ErrInvalidHandle
+	case http.StatusNotFound:
+		return nil, resolve.ErrUserNotFound
+	case http.StatusGone:
+		return nil, resolve.ErrUserSuspended
+	case http.StatusTooManyRequests:
+		return nil, resolve.ErrRateLimited
+	}
+	return nil, fmt.Errorf("daemon: %s", bytes.TrimSpace(body))
+}
+
+// daemonClient returns http client talking to daemon over unix socket, or
+// nil if socket does not exist.
+func daemonClient(socket string) *http.Client {
+	if socket == "" {
+		return nil
+	}
+	if fi, err := os.Stat(socket); err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+}
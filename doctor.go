@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runDoctor checks environment age-github runs in: age backend, cache
+// directory, and access to providers, printing findings along with hints on
+// fixing problems.
+func runDoctor(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: age-github doctor")
+	}
+	var problems int
+	check := func(name string, fn func() (string, error)) {
+		msg, err := fn()
+		if err != nil {
+			problems++
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok   %s: %s\n", name, msg)
+	}
+	check("backend", func() (string, error) { return checkBackend(ctx, r.cfg.Backend) })
+	check("cache", func() (string, error) { return checkCacheDir(r.cfg.CacheDir) })
+	names := make([]string, 0, len(r.cfg.Providers))
+	for name := range r.cfg.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := r.cfg.Providers[name]
+		check(name+" provider", func() (string, error) { return r.checkProvider(ctx, p) })
+	}
+	if problems != 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
+
+// backendVersionRe matches version printed by age and rage --version.
+var backendVersionRe = regexp.MustCompile(`v?(\d+)\.(\d+)\.(\d+)`)
+
+// checkBackend checks that age backend is installed, and, if it's age
+// itself, that it's not older than v1.0.0, the first stable release.
+func checkBackend(ctx context.Context, backend string) (string, error) {
+	bin, err := exec.LookPath(backend)
+	if err != nil {
+		return "", fmt.Errorf("%v; install age (https://filippo.io/age) or set \"backend\" setting", err)
+	}
+	out, err := exec.CommandContext(ctx, bin, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s --version: %v", bin, err)
+	}
+	version := strings.TrimSpace(string(out))
+	m := backendVersionRe.FindStringSubmatch(version)
+	if m == nil {
+		return fmt.Sprintf("%s, unrecognized version %q", bin, version), nil
+	}
+	if major, _ := strconv.Atoi(m[1]); major < 1 && strings.TrimSuffix(filepath.Base(bin), ".exe") == "age" {
+		return "", fmt.Errorf("%s version %s is too old, upgrade to v1.0.0 or later", bin, version)
+	}
+	return fmt.Sprintf("%s, version %s", bin, version), nil
+}
+
+// checkCacheDir checks that cache directory is writable, and only by its
+// owner, as anyone who can write there can substitute keys.
+func checkCacheDir(dir string) (string, error) {
+	if dir == "" {
+		return "disabled", nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("%v; set \"cache_dir\" setting to a writable directory", err)
+	}
+	f, err := ioutil.TempFile(dir, ".doctor-*")
+	if err != nil {
+		return "", fmt.Errorf("%s is not writable: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode().Perm()&0022 != 0 {
+			return "", fmt.Errorf("%s is writable by other users, who can substitute cached keys; run chmod go-w %s", dir, dir)
+		}
+	}
+	return dir, nil
+}
+
+// checkProvider checks that provider can be reached, and that its token, if
+// configured, is accepted.
+func (r *resolver) checkProvider(ctx context.Context, p *resolve.Provider) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+p.Host+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	via := r.proxyDescription(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s is not reachable%s: %v; check network, and proxy or proxy_command settings", p.Host, via, err)
+	}
+	resp.Body.Close()
+	if p.LoadToken() == "" {
+		return fmt.Sprintf("%s is reachable%s, no token", p.Host, via), nil
+	}
+	login, err := r.tokenUser(ctx, p)
+	if err != nil {
+		var httpErr *resolve.HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized {
+			return "", fmt.Errorf("%s token is rejected, check that it's valid and not expired", p.Host)
+		}
+		return "", fmt.Errorf("checking %s token: %v", p.Host, err)
+	}
+	return fmt.Sprintf("%s is reachable%s, token of user %q is valid", p.Host, via, login), nil
+}
+
+// tokenUser returns name of user whose token is configured for provider.
+func (r *resolver) tokenUser(ctx context.Context, p *resolve.Provider) (string, error) {
+	if p.LoadToken() == "" {
+		return "", fmt.Errorf("%s provider has no token configured", p.Name)
+	}
+	var user struct {
+		Login    string `json:"login"`    // GitHub
+		Username string `json:"username"` // GitLab
+	}
+	if _, err := r.APIGet(ctx, p, p.APIURL("/user"), &user); err != nil {
+		return "", err
+	}
+	if user.Login == "" {
+		return user.Username, nil
+	}
+	return user.Login, nil
+}
+
+// proxyDescription returns description of how req is proxied, if it is.
+func (r *resolver) proxyDescription(req *http.Request) string {
+	switch {
+	case r.cfg.Dial != nil:
+		return ""
+	case r.cfg.ProxyCommand != "":
+		return " through proxy_command"
+	case r.cfg.Proxy != "":
+		return " through proxy from settings"
+	}
+	if u, err := http.ProxyFromEnvironment(req); err == nil && u != nil {
+		return " through proxy " + u.Host + " from environment"
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runFanout encrypts a file separately for each user, so that every user can
+// only decrypt their own copy. Group handles expand to their members, each
+// getting a copy. Copies are named after the input file and user:
+// file.alice.age, file.bob.age, and so on, or with -output-template, see
+// outputFields.
+func runFanout(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("fanout", flag.ContinueOnError)
+	armor := fs.Bool("a", r.cfg.Armor, "encrypt to ASCII-armored format")
+	outDir := fs.String("o", "", "`directory` to write copies to, defaults to input file directory")
+	outTemplate := fs.String("output-template", "", "`template` of copies names, i.e. '{{.Input}}.{{.Handle}}.age'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github fanout [-a] [-o dir | -output-template template] file @handle...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errors.New("file and handles are required")
+	}
+	input := fs.Arg(0)
+	if fi, err := os.Stat(input); err != nil {
+		return err
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", input)
+	}
+	if *outDir != "" && *outTemplate != "" {
+		return errors.New("-o and -output-template are mutually exclusive")
+	}
+	if *outDir == "" {
+		*outDir = filepath.Dir(input)
+	}
+	var tmpl *template.Template
+	if *outTemplate != "" {
+		var err error
+		if tmpl, err = parseOutputTemplate(*outTemplate); err != nil {
+			return err
+		}
+	}
+	ageBin, err := exec.LookPath(r.cfg.Backend)
+	if err != nil {
+		return err
+	}
+	var users []string
+	for _, arg := range fs.Args()[1:] {
+		handle := r.cfg.Aliases.expand(strings.TrimPrefix(arg, "@"))
+		if !resolve.IsGroupHandle(handle) {
+			users = append(users, handle)
+			continue
+		}
+		members, err := r.ExpandGroup(ctx, handle)
+		if err != nil {
+			return fmt.Errorf("expanding group %q: %w", handle, err)
+		}
+		users = append(users, members...)
+	}
+	users = uniqueStrings(users)
+	names := make([]string, len(users))
+	seen := make(map[string]string, len(users))
+	for i, user := range users {
+		handle := fanoutName(user, r.cfg.DefaultProvider)
+		if tmpl == nil {
+			names[i] = filepath.Join(*outDir, fmt.Sprintf("%s.%s.age", filepath.Base(input), handle))
+		} else if names[i], err = outputName(tmpl, input, handle); err != nil {
+			return err
+		}
+		if other, ok := seen[names[i]]; ok {
+			return fmt.Errorf("copies of %s and %s would both be written to %s", other, user, names[i])
+		}
+		seen[names[i]] = user
+	}
+	r.Prefetch(ctx, users)
+	done := make([]bool, len(users))
+	err = runJobs(len(users), r.cfg.Jobs, func(i int) error {
+		list, err := r.Resolve(ctx, users[i])
+		if err != nil {
+			return err
+		}
+		name := names[i]
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return err
+		}
+		ageArgs := []string{"-e", "-o", name}
+		if *armor {
+			ageArgs = append(ageArgs, "-a")
+		}
+		for _, rc := range list {
+			ageArgs = append(ageArgs, "-r", rc.String())
+		}
+		if err := runBackend(ctx, ageBin, append(ageArgs, input)); err != nil {
+			return fmt.Errorf("encrypting %s: %w", name, err)
+		}
+		done[i] = true
+		return nil
+	})
+	for i, name := range names {
+		if done[i] {
+			fmt.Println(name)
+		}
+	}
+	return err
+}
+
+// runBackend runs age with args, capturing its error output, so that output
+// of backends running at once isn't mixed up, see runJobs.
+func runBackend(ctx context.Context, ageBin string, args []string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ageBin, args...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) != 0 {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	os.Stderr.Write(stderr.Bytes())
+	return nil
+}
+
+// fanoutName returns user handle suitable to be used as a part of file name,
+// with default provider suffix stripped.
+func fanoutName(handle, defaultProvider string) string {
+	handle = strings.TrimSuffix(handle, "@"+defaultProvider)
+	return strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(handle)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// wrapperFlag is a flag handled by age-github itself and not passed to age.
+type wrapperFlag struct {
+	isBool bool               // flag takes no value
+	set    func(string) error // called with flag value, or "true" for bool flags
+}
+
+// extractFlags removes known wrapper flags from args, calling their set
+// functions, and returns the rest of arguments in their original order. Flags
+// can be given in "--name value", "--name=value", or "--name" (for bool flags)
+// forms, single dash prefix is also accepted. Processing stops at "--"
+// argument, and at the first argument other than wrapper flags if isCommand
+// reports it as a subcommand name, so that subcommands get the rest of
+// arguments intact, even if their flags share names with wrapper flags.
+func extractFlags(args []string, flags map[string]wrapperFlag, isCommand func(string) bool) ([]string, error) {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		v := args[i]
+		if v == "--" {
+			out = append(out, args[i:]...)
+			break
+		}
+		if len(out) == 0 && isCommand(v) {
+			out = append(out, args[i:]...)
+			break
+		}
+		if !strings.HasPrefix(v, "-") {
+			out = append(out, v)
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(v, "-"), "-")
+		var value string
+		var hasValue bool
+		if j := strings.IndexByte(name, '='); j > 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		fl, ok := flags[name]
+		if !ok {
+			out = append(out, v)
+			continue
+		}
+		switch {
+		case fl.isBool && !hasValue:
+			value = "true"
+		case !hasValue:
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("flag needs an argument: %s", v)
+			}
+			i++
+			value = args[i]
+		}
+		if err := fl.set(value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag %s: %w", value, v, err)
+		}
+	}
+	return out, nil
+}
+
+// stringFlag returns wrapperFlag storing its value to p.
+func stringFlag(p *string) wrapperFlag {
+	return wrapperFlag{set: func(s string) error { *p = s; return nil }}
+}
+
+// boolFlag returns wrapperFlag storing its value to p.
+func boolFlag(p *bool) wrapperFlag {
+	return wrapperFlag{isBool: true, set: func(s string) (err error) {
+		*p, err = strconv.ParseBool(s)
+		return err
+	}}
+}
+
+// stringList is a flag.Value collecting values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractFlags(t *testing.T) {
+	isCommand := func(s string) bool { return s == "who" || s == "inspect" }
+	for _, tc := range []struct {
+		args    []string
+		want    []string
+		org     string
+		armor   bool
+		wantErr bool
+	}{
+		{
+			args: []string{"--org", "corp", "-r", "@alice", "file"},
+			want: []string{"-r", "@alice", "file"},
+			org:  "corp",
+		},
+		{
+			args: []string{"-r", "@alice", "-org=corp", "--armor", "file"},
+			want: []string{"-r", "@alice", "file"},
+			org:  "corp", armor: true,
+		},
+		{
+			args: []string{"-r", "@alice", "--", "--org", "x"},
+			want: []string{"-r", "@alice", "--", "--org", "x"},
+		},
+		{
+			args: []string{"--org", "corp", "who", "-org", "other", "SHA256:x"},
+			want: []string{"who", "-org", "other", "SHA256:x"},
+			org:  "corp",
+		},
+		{
+			args: []string{"inspect", "-org", "other", "file.age"},
+			want: []string{"inspect", "-org", "other", "file.age"},
+		},
+		{
+			// not a subcommand unless it comes first
+			args: []string{"-e", "who", "--org", "corp"},
+			want: []string{"-e", "who"},
+			org:  "corp",
+		},
+		{
+			args:    []string{"-r", "@alice", "--org"},
+			wantErr: true,
+		},
+		{
+			args:    []string{"--armor=maybe"},
+			wantErr: true,
+		},
+	} {
+		var org string
+		var armor bool
+		flags := map[string]wrapperFlag{"org": stringFlag(&org), "armor": boolFlag(&armor)}
+		got, err := extractFlags(tc.args, flags, isCommand)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: got %q, want error", tc.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) || org != tc.org || armor != tc.armor {
+			t.Errorf("%q: got %q, org=%q, armor=%v; want %q, org=%q, armor=%v",
+				tc.args, got, org, armor, tc.want, tc.org, tc.armor)
+		}
+	}
+}
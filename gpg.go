@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runGPG encrypts with gpg instead of age, to PGP keys users publish, for
+// users who only publish those. It understands age-style arguments: -r
+// @handle recipients, -o, -a, and an input file, and imports keys into a
+// temporary keyring, so that user keyring is never touched.
+func runGPG(ctx context.Context, r *resolver, args []string, opts ageOptions) error {
+	if opts.archive != "" {
+		return errors.New("--archive is not supported with --gpg")
+	}
+	var handles []string
+	var output string
+	armor := r.cfg.Armor
+	parsed, err := parseAgeArgs(args)
+	if err != nil {
+		return err
+	}
+	for _, f := range parsed.flags {
+		switch f.name {
+		case "r":
+			if !strings.HasPrefix(f.value, "@") {
+				return fmt.Errorf("only @handle recipients are supported with --gpg, got %q", f.value)
+			}
+			handles = append(handles, f.value[1:])
+		case "o":
+			output = f.value
+		case "a":
+			armor = true
+		case "e":
+		default:
+			return fmt.Errorf("flag %s is not supported with --gpg, only encryption to @handle recipients is", f.raw[0])
+		}
+	}
+	if len(parsed.args) > 1 {
+		return errors.New("only one input file is supported")
+	}
+	input := parsed.input()
+	for _, name := range opts.rosters {
+		if _, err := r.verifySignature(ctx, name); err != nil {
+			return err
+		}
+		list, err := readRoster(name)
+		if err != nil {
+			return err
+		}
+		handles = append(handles, list...)
+	}
+	var users []string
+	for _, h := range handles {
+		h = r.ExpandAlias(h)
+		if !resolve.IsGroupHandle(h) {
+			users = append(users, h)
+			continue
+		}
+		members, err := r.ExpandGroup(ctx, h)
+		if err != nil {
+			return fmt.Errorf("expanding group %q: %w", h, err)
+		}
+		users = append(users, members...)
+	}
+	users = uniqueStrings(users)
+	if len(users) == 0 {
+		return errors.New("no recipients given")
+	}
+	if len(users) < opts.minRecipients {
+		return fmt.Errorf("only %d recipient(s) given, --min-recipients requires %d", len(users), opts.minRecipients)
+	}
+	home, err := ioutil.TempDir("", "age-github-gpg-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(home)
+	gpgArgs := []string{"--homedir", home, "--batch", "--no-tty", "--trust-model", "always", "--encrypt"}
+	if armor {
+		gpgArgs = append(gpgArgs, "--armor")
+	}
+	if output != "" && output != "-" {
+		gpgArgs = append(gpgArgs, "--output", output)
+	}
+	for _, u := range users {
+		data, err := r.FetchGPGKeys(ctx, u)
+		if err != nil {
+			return err
+		}
+		fprs, err := gpgImport(ctx, home, data)
+		if err != nil {
+			return fmt.Errorf("importing gpg keys of @%s: %w", displayHandle(u, r.cfg.DefaultProvider), err)
+		}
+		for _, fpr := range fprs {
+			gpgArgs = append(gpgArgs, "--recipient", fpr)
+		}
+	}
+	if input != "" {
+		gpgArgs = append(gpgArgs, "--", input)
+	}
+	cmd := exec.CommandContext(ctx, "gpg", gpgArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// gpgImport imports ASCII-armored keys into keyring in home directory,
+// returning fingerprints of imported primary keys.
+func gpgImport(ctx context.Context, home string, data []byte) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--no-tty",
+		"--with-colons", "--import-options", "show-only", "--import")
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing keys: %w", err)
+	}
+	var fprs []string
+	var primary bool // last record was a primary key
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		switch {
+		case fields[0] == "pub":
+			primary = true
+		case fields[0] == "fpr" && primary && len(fields) > 9:
+			fprs = append(fprs, fields[9])
+			primary = false
+		case fields[0] != "fpr":
+			primary = false
+		}
+	}
+	if len(fprs) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	cmd = exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--no-tty", "--quiet", "--import")
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return fprs, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// Fingerprint indexes hold keys of all members of an organization, so that
+// inspect and who subcommands can find key owners without API requests. They
+// are stored in "fingerprints" subdirectory of cache directory, a file per
+// organization named as "org@provider", each line holding member handle and
+// key.
+const indexDir = "fingerprints"
+
+// runIndex manages fingerprint indexes.
+func runIndex(ctx context.Context, r *resolver, args []string) error {
+	const usage = "usage: age-github index build [org[@provider]...]"
+	if len(args) == 0 || args[0] != "build" {
+		return errors.New(usage)
+	}
+	if r.cfg.CacheDir == "" {
+		return errors.New("fingerprint index requires cache directory, see cache_dir setting")
+	}
+	dir := filepath.Join(r.cfg.CacheDir, indexDir)
+	orgs := args[1:]
+	if len(orgs) == 0 {
+		// rebuild all existing indexes
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, fi := range fis {
+			if fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), ".") {
+				orgs = append(orgs, fi.Name())
+			}
+		}
+		if len(orgs) == 0 {
+			return errors.New(usage)
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		org = strings.TrimPrefix(strings.TrimPrefix(org, "@"), "org:")
+		n, err := r.buildIndex(ctx, org)
+		if err != nil {
+			return fmt.Errorf("%s: %w", org, err)
+		}
+		fmt.Printf("%s: %d key(s)\n", org, n)
+	}
+	return nil
+}
+
+// buildIndex writes fingerprint index of organization, returning number of
+// keys indexed.
+func (r *resolver) buildIndex(ctx context.Context, org string) (int, error) {
+	name, p, err := r.LookupProvider(org)
+	if err != nil {
+		return 0, err
+	}
+	members, err := r.ExpandGroup(ctx, "org:"+name+"@"+p.Name)
+	if err != nil {
+		return 0, err
+	}
+	r.Prefetch(ctx, members)
+	var buf bytes.Buffer
+	var n int
+	for _, m := range members {
+		list, err := r.FetchRecipients(ctx, m)
+		if err != nil {
+			if !isMissing(err) {
+				return 0, err
+			}
+			continue
+		}
+		for _, rc := range list {
+			fmt.Fprintf(&buf, "%s %s\n", m, rc.PublicKey)
+			n++
+		}
+	}
+	return n, ioutil.WriteFile(filepath.Join(r.cfg.CacheDir, indexDir, name+"@"+p.Name), buf.Bytes(), 0666)
+}
+
+// indexedRecipients returns recipients from all fingerprint indexes.
+func (r *resolver) indexedRecipients() ([]resolve.Recipient, error) {
+	if r.cfg.CacheDir == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(r.cfg.CacheDir, indexDir)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []resolve.Recipient
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				continue
+			}
+			out = append(out, recipientOf(fields[0], fields[1]+" "+fields[2], fi.ModTime()))
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// recipientOf returns recipient for key of user with "user@provider" handle.
+func recipientOf(handle, key string, at time.Time) resolve.Recipient {
+	rc := resolve.Recipient{
+		Handle:      handle,
+		UserID:      handle,
+		PublicKey:   key,
+		Fingerprint: resolve.Fingerprint(key),
+		FetchedAt:   at,
+	}
+	if i := strings.LastIndexByte(handle, '@'); i >= 0 {
+		rc.UserID, rc.Provider = handle[:i], handle[i+1:]
+	}
+	if i := strings.IndexByte(key, ' '); i > 0 {
+		rc.KeyType = key[:i]
+	}
+	return rc
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runInspect reports which users can likely decrypt age encrypted files,
+// matching ssh recipient stanzas of file headers against cached keys, and
+// keys of organization members.
+func runInspect(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	var orgs stringList
+	fs.Var(&orgs, "org", "also match keys of organization `members`, may be repeated, i.e. -org corp@ghe.corp")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github inspect [-org name]... file.age...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no files given")
+	}
+	known, err := r.knownKeys(ctx, orgs)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, name := range fs.Args() {
+		stanzas, _, err := readAgeHeader(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Fprintf(tw, "%s:\n", name)
+		for _, s := range stanzas {
+			fmt.Fprintf(tw, "  %s\t%s\n", strings.Join(append([]string{s.Type}, firstArg(s)...), " "), r.stanzaOwners(s, known))
+		}
+	}
+	return tw.Flush()
+}
+
+// knownKeys returns recipients of cached keys and keys of organizations
+// members, keyed by recipientID.
+func (r *resolver) knownKeys(ctx context.Context, orgs []string) (map[string][]resolve.Recipient, error) {
+	list, err := r.knownRecipients(ctx, orgs)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]resolve.Recipient)
+	for _, rc := range list {
+		if id := recipientID(rc.PublicKey); id != "" {
+			out[id] = append(out[id], rc)
+		}
+	}
+	return out, nil
+}
+
+// knownRecipients returns recipients of cached keys, fingerprint indexes, and
+// keys of organizations members, each handle and key pair once.
+func (r *resolver) knownRecipients(ctx context.Context, orgs []string) ([]resolve.Recipient, error) {
+	list, err := r.CachedRecipients()
+	if err != nil {
+		return nil, err
+	}
+	indexed, err := r.indexedRecipients()
+	if err != nil {
+		return nil, err
+	}
+	list = append(list, indexed...)
+	for _, org := range orgs {
+		members, err := r.ExpandGroup(ctx, "org:"+strings.TrimPrefix(org, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("listing %s members: %w", org, err)
+		}
+		r.Prefetch(ctx, members)
+		for _, m := range members {
+			keys, err := r.FetchRecipients(ctx, m)
+			if err != nil {
+				warnf("%v", err)
+				continue
+			}
+			list = append(list, keys...)
+		}
+	}
+	seen := make(map[string]struct{})
+	out := list[:0]
+	for _, rc := range list {
+		id := rc.Handle + " " + rc.PublicKey
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, rc)
+	}
+	return out, nil
+}
+
+// stanzaOwners describes who can decrypt recipient stanza.
+func (r *resolver) stanzaOwners(s stanza, known map[string][]resolve.Recipient) string {
+	switch s.Type {
+	case "X25519":
+		return "native age recipient, owner can't be identified"
+	case "scrypt":
+		return "passphrase"
+	}
+	id := stanzaID(s)
+	if id == "" {
+		return "unsupported recipient type"
+	}
+	owners := known[id]
+	if len(owners) == 0 {
+		return "unknown ssh key"
+	}
+	var out []string
+	for _, rc := range owners {
+		out = append(out, fmt.Sprintf("@%s (%s)", displayHandle(rc.Handle, r.cfg.DefaultProvider), rc.Fingerprint))
+	}
+	sort.Strings(out)
+	return strings.Join(out, ", ")
+}
+
+// firstArg returns first argument of stanza, which identifies ssh keys, as a
+// slice of zero or one elements.
+func firstArg(s stanza) []string {
+	if len(s.Args) == 0 || s.Type == "X25519" || s.Type == "scrypt" {
+		return nil
+	}
+	return s.Args[:1]
+}
+
+// displayHandle returns handle in "user@provider" form with default provider
+// suffix stripped.
+func displayHandle(handle, defaultProvider string) string {
+	return strings.TrimSuffix(handle, "@"+defaultProvider)
+}
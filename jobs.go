@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// runJobs calls fn for each of n independent jobs, i.e. encryptions, running
+// up to workers of them at once (see jobs setting). All jobs are run, even if
+// some fail; if any did, returned error is jobErrors.
+func runJobs(n, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	var failed jobErrors
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
+
+// jobErrors holds errors of failed jobs, in order of jobs.
+type jobErrors []error
+
+func (e jobErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d jobs failed:\n\t%s", len(e), strings.Join(msgs, "\n\t"))
+}
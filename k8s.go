@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runK8s prints Kubernetes Secret, or ConfigMap, manifest holding age
+// recipients file of the given handles, or a file encrypted to them, ready to
+// be applied with kubectl. With -apply, manifest is passed to "kubectl apply"
+// instead, so that the current kubectl context and its credentials are used.
+func runK8s(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("k8s", flag.ContinueOnError)
+	name := fs.String("name", "", "object `name`, required")
+	namespace := fs.String("namespace", "", "object `namespace`, kubectl context one by default")
+	configMap := fs.Bool("configmap", false, "make a ConfigMap instead of a Secret")
+	key := fs.String("key", "", "data `key`, recipients.txt, or encrypted file name with .age suffix by default")
+	encrypt := fs.String("encrypt", "", "`file` to encrypt to handles and store instead of recipients, - for stdin")
+	armor := fs.Bool("a", false, "encrypt to ASCII-armored format")
+	apply := fs.Bool("apply", false, "apply manifest with \"kubectl apply\" instead of printing it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github k8s -name name [-namespace ns] [-configmap] [-key key] [-encrypt file [-a]] [-apply] @handle...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !k8sNameRe.MatchString(*name) {
+		fs.Usage()
+		return errors.New("-name must be a valid Kubernetes object name")
+	}
+	if *key == "" {
+		switch *encrypt {
+		case "":
+			*key = "recipients.txt"
+		case "-":
+			*key = "payload.age"
+		default:
+			*key = filepath.Base(*encrypt) + ".age"
+		}
+	}
+	if !k8sKeyRe.MatchString(*key) {
+		return fmt.Errorf("-key %q must consist of letters, digits, and \"-._\" characters", *key)
+	}
+	for _, arg := range fs.Args() {
+		if arg == "-" && *encrypt == "-" {
+			return errors.New("handles and file to encrypt can't both be read from stdin")
+		}
+	}
+	handles, err := collectHandles(fs.Args(), os.Stdin)
+	if err != nil {
+		return err
+	}
+	r.Prefetch(ctx, handles)
+	var recipients bytes.Buffer // age recipients file
+	var ageArgs []string
+	for _, h := range handles {
+		keys, err := r.recipients(ctx, h)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&recipients, "# @%s\n", h)
+		for _, k := range keys {
+			fmt.Fprintln(&recipients, k)
+			ageArgs = append(ageArgs, "-r", k)
+		}
+	}
+	value := recipients.Bytes()
+	if *encrypt != "" {
+		if value, err = k8sEncrypt(ctx, r.cfg.Backend, *encrypt, *armor, ageArgs); err != nil {
+			return err
+		}
+	}
+	manifest, err := k8sManifest(*configMap, *name, *namespace, *key, value, handles)
+	if err != nil {
+		return err
+	}
+	if !*apply {
+		_, err := os.Stdout.Write(manifest)
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "kubectl", "apply", "-f", "-")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(manifest), os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("kubectl apply: %w", err)
+	}
+	return nil
+}
+
+var (
+	k8sNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?$`)
+	k8sKeyRe  = regexp.MustCompile(`^[-._a-zA-Z0-9]{1,253}$`)
+)
+
+// k8sEncrypt encrypts file, or stdin if it's "-", with age, returning
+// ciphertext.
+func k8sEncrypt(ctx context.Context, backend, file string, armor bool, recipientArgs []string) ([]byte, error) {
+	ageBin, err := exec.LookPath(backend)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-e"}
+	if armor {
+		args = append(args, "-a")
+	}
+	args = append(args, recipientArgs...)
+	cmd := exec.CommandContext(ctx, ageBin, args...)
+	if file == "-" {
+		cmd.Stdin = os.Stdin
+	} else {
+		cmd.Args = append(cmd.Args, "--", file)
+	}
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("encrypting %s: %w", file, err)
+	}
+	return out.Bytes(), nil
+}
+
+// k8sObject is a Secret or ConfigMap manifest.
+type k8sObject struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace,omitempty"`
+	} `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+}
+
+// k8sManifest returns YAML manifest of Secret, or ConfigMap, holding value
+// under key. ConfigMaps keep text values as is, and binary ones, like
+// ciphertext that is not armored, base64-encoded.
+func k8sManifest(configMap bool, name, namespace, key string, value []byte, handles []string) ([]byte, error) {
+	obj := k8sObject{APIVersion: "v1", Kind: "Secret", Type: "Opaque"}
+	obj.Metadata.Name, obj.Metadata.Namespace = name, namespace
+	switch {
+	case !configMap:
+		obj.Data = map[string]string{key: base64.StdEncoding.EncodeToString(value)}
+	case bytes.HasPrefix(value, []byte("age-encryption.org/")):
+		obj.Kind, obj.Type = "ConfigMap", ""
+		obj.BinaryData = map[string]string{key: base64.StdEncoding.EncodeToString(value)}
+	default:
+		obj.Kind, obj.Type = "ConfigMap", ""
+		obj.Data = map[string]string{key: string(value)}
+	}
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# generated by age-github for @%s\n", strings.Join(handles, " @"))
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
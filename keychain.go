@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Tokens can be stored in OS keychain instead of config file or environment:
+// macOS Keychain, Windows Credential Manager, or Secret Service (via
+// secret-tool) elsewhere. They're stored under keychainService service name,
+// with provider host as account name.
+const keychainService = "age-github"
+
+// runToken stores or deletes provider tokens in OS keychain.
+func runToken(ctx context.Context, r *resolver, args []string) error {
+	const usage = "usage: age-github token set|delete host"
+	if len(args) != 2 {
+		return errors.New(usage)
+	}
+	host := args[1]
+	switch args[0] {
+	case "set":
+		fmt.Fprintf(os.Stderr, "%s token: ", host)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		token := strings.TrimSpace(line)
+		if token == "" {
+			if err != nil {
+				return err
+			}
+			return errors.New("empty token")
+		}
+		return keychainSet(host, token)
+	case "delete":
+		return keychainDelete(host)
+	}
+	return errors.New(usage)
+}
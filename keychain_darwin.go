@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// keychainGet returns token for host stored in macOS Keychain, or an empty
+// string if there's none.
+func keychainGet(host string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", host, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 { // errSecItemNotFound
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func keychainSet(host, token string) error {
+	// token is passed over stdin, so it does not show up in process list
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		keychainService, strconv.Quote(host), strconv.Quote(token)))
+	if out, err := cmd.CombinedOutput(); err != nil || len(bytes.TrimSpace(out)) != 0 {
+		return fmt.Errorf("security: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func keychainDelete(host string) error {
+	if out, err := exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", host).CombinedOutput(); err != nil {
+		return fmt.Errorf("security: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychainGet returns token for host stored with Secret Service API, or an
+// empty string if there's none. It uses secret-tool from libsecret.
+func keychainGet(host string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService, "host", host).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(out) == 0 && len(exitErr.Stderr) == 0 {
+		return "", nil // not found
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func keychainSet(host, token string) error {
+	cmd := exec.Command("secret-tool", "store", "--label=age-github token for "+host, "service", keychainService, "host", host)
+	cmd.Stdin = strings.NewReader(token)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func keychainDelete(host string) error {
+	if out, err := exec.Command("secret-tool", "clear", "service", keychainService, "host", host).CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
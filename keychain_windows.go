@@ -0,0 +1,98 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Windows Credential Manager API, see
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+// credential is CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func credTarget(host string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(keychainService + ":" + host)
+}
+
+// keychainGet returns token for host stored in Windows Credential Manager, or
+// an empty string if there's none.
+func keychainGet(host string) (string, error) {
+	target, err := credTarget(host)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	ok, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		if err == errorNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	n := int(cred.CredentialBlobSize)
+	blob := (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))[:n:n]
+	return string(blob), nil
+}
+
+func keychainSet(host, token string) error {
+	target, err := credTarget(host)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(keychainService)
+	if err != nil {
+		return err
+	}
+	blob := []byte(token)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if ok, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func keychainDelete(host string) error {
+	target, err := credTarget(host)
+	if err != nil {
+		return err
+	}
+	if ok, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ok == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// Keyoxide identity proofs are notations on self-signatures of OpenPGP key,
+// each holding a url of a claim on some service. A GitHub claim is a public
+// gist mentioning the key fingerprint, see verifyGistProof. Together, they
+// link account to the key, and through key's other proofs, to identities
+// elsewhere.
+var keyoxideNotations = []string{"proof@ariadne.id=", "proof@metacode.biz="}
+
+// verifyKeyoxide checks that OpenPGP key configured for user in [keyoxide]
+// config section holds identity proof of user account, and the account links
+// back to the key, so keys user publishes are only trusted when the account
+// belongs to whoever holds the key. Users not listed there are not checked.
+func (r *resolver) verifyKeyoxide(ctx context.Context, username string, p *resolve.Provider) error {
+	fpr, ok := r.cfg.Keyoxide[strings.ToLower(username+"@"+p.Name)]
+	if !ok && p.Name == r.cfg.DefaultProvider {
+		fpr, ok = r.cfg.Keyoxide[strings.ToLower(username)]
+	}
+	if !ok {
+		return nil
+	}
+	key := username + "@" + p.Name + " " + fpr
+	r.keyoxideMu.Lock()
+	defer r.keyoxideMu.Unlock()
+	if err, ok := r.keyoxide[key]; ok {
+		return err
+	}
+	err := r.checkKeyoxide(ctx, username, p, fpr)
+	if err != nil {
+		err = fmt.Errorf("keyoxide proof of %s key: %w", fpr, err)
+	}
+	if r.keyoxide == nil {
+		r.keyoxide = make(map[string]error)
+	}
+	r.keyoxide[key] = err
+	return err
+}
+
+func (r *resolver) checkKeyoxide(ctx context.Context, username string, p *resolve.Provider, fpr string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	proofs, err := r.keyoxideProofs(ctx, fpr)
+	if err != nil {
+		return err
+	}
+	var checked int
+	for _, proof := range proofs {
+		id, ok := gistProofID(proof, username, p)
+		if !ok {
+			continue
+		}
+		checked++
+		switch err := r.verifyGistProof(ctx, username, p, id, fpr); {
+		case err == nil:
+			return nil
+		case errors.Is(err, errNoProof):
+		default:
+			return err
+		}
+	}
+	if checked == 0 {
+		return fmt.Errorf("key has no proof of %s account", p.Host)
+	}
+	return fmt.Errorf("%s account does not link back to key", p.Host)
+}
+
+// errNoProof is returned by verifyGistProof for gists that don't prove
+// anything.
+var errNoProof = errors.New("gist is not a proof")
+
+// keyoxideProofs fetches OpenPGP key by fingerprint from keyserver and
+// returns proof urls found in notations of its valid self-signatures.
+func (r *resolver) keyoxideProofs(ctx context.Context, fpr string) ([]string, error) {
+	u := r.cfg.Keyserver + "/vks/v1/by-fingerprint/" + fpr
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("key not found on %s", r.cfg.Keyserver)
+	default:
+		return nil, fmt.Errorf("fetching key from %s: %s", r.cfg.Keyserver, resp.Status)
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, r.cfg.MaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	home, err := ioutil.TempDir("", "age-github-keyoxide-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(home)
+	fprs, err := gpgImport(ctx, home, data)
+	if err != nil {
+		return nil, fmt.Errorf("importing key: %w", err)
+	}
+	if len(fprs) != 1 || fprs[0] != fpr {
+		return nil, fmt.Errorf("keyserver returned wrong key %s", strings.Join(fprs, ", "))
+	}
+	cmd := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--no-tty",
+		"--list-options", "show-notations", "--check-sigs", fpr)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing key signatures: %w", err)
+	}
+	keyID := fpr[len(fpr)-16:]
+	var proofs []string
+	var self bool // last signature is a valid self-signature
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			self = strings.HasPrefix(line, "sig!") && strings.Contains(line, " "+keyID+" ")
+			continue
+		}
+		notation := strings.TrimPrefix(strings.TrimSpace(line), "Signature notation: ")
+		if !self || len(notation) == len(strings.TrimSpace(line)) {
+			continue
+		}
+		for _, prefix := range keyoxideNotations {
+			if strings.HasPrefix(notation, prefix) {
+				proofs = append(proofs, strings.TrimPrefix(notation, prefix))
+			}
+		}
+	}
+	return uniqueStrings(proofs), nil
+}
+
+// gistProofID returns id of gist from proof url, if it's a gist of user:
+// https://gist.github.com/alice/ID on github.com, or
+// https://HOST/gist/alice/ID on GitHub Enterprise.
+func gistProofID(proof, username string, p *resolve.Provider) (string, bool) {
+	if p.Type != resolve.ProviderGithub {
+		return "", false
+	}
+	u, err := url.Parse(proof)
+	if err != nil || u.Scheme != "https" {
+		return "", false
+	}
+	path := u.Path
+	switch {
+	case p.Host == "github.com" && u.Host == "gist.github.com":
+	case p.Host != "github.com" && u.Host == p.Host && strings.HasPrefix(path, "/gist/"):
+		path = strings.TrimPrefix(path, "/gist")
+	default:
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 2 || !strings.EqualFold(parts[0], username) || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// verifyGistProof checks that gist is owned by user and mentions key
+// fingerprint as "openpgp4fpr:FINGERPRINT".
+func (r *resolver) verifyGistProof(ctx context.Context, username string, p *resolve.Provider, id, fpr string) error {
+	var gist struct {
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+		Files map[string]struct {
+			Content string `json:"content"`
+		} `json:"files"`
+	}
+	if _, err := r.APIGet(ctx, p, p.APIURL("/gists/"+url.PathEscape(id)), &gist); err != nil {
+		var httpErr *resolve.HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return errNoProof
+		}
+		return err
+	}
+	if !strings.EqualFold(gist.Owner.Login, username) {
+		return errNoProof
+	}
+	claim := "openpgp4fpr:" + strings.ToLower(fpr)
+	for _, f := range gist.Files {
+		if strings.Contains(strings.ToLower(f.Content), claim) {
+			return nil
+		}
+	}
+	return errNoProof
+}
@@ -1,188 +1,388 @@
 // age-github command is a wrapper to filippo.io/age tool which expands
-// recipients in -r @username format to first ssh key of github user
-// "username", fetching keys from https://github.com/username.keys endpoint.
+// recipients in -r @username format to ssh keys, or native age recipients,
+// users publish on GitHub, or on other configured providers, fetching them
+// from endpoints such as https://github.com/username.keys.
 //
 // It caches keys for 1 hour in "age-github" subdirectory under os.UserCacheDir
 // directory.
 //
-// Github user handles should have @ prefix, i.e. to encrypt file for
+// User handles should have @ prefix, i.e. to encrypt file for
 // https://github.com/artyom user, you call it as
 //
 //	age-github -r @artyom ...
 //
-// All other flags/arguments are passed unmodified.
+// Handles may name users of other providers as @username@provider, and whole
+// organizations, teams and groups. All other flags/arguments are passed to
+// age unmodified. Settings, subcommands, and the rest are described in the
+// README file.
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"crypto/sha1"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
-	"time"
+
+	"github.com/artyom/age-github/resolve"
 )
 
 func main() {
 	if err := run(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
 		os.Stderr.WriteString(err.Error() + "\n")
+		if errors.Is(err, errSkipped) {
+			os.Exit(3)
+		}
 		os.Exit(1)
 	}
 }
 
+// errSkipped is returned when age succeeded, but some recipients were skipped
+// because of --skip-missing flag. Program exits with status 3 then.
+var errSkipped = errors.New("some recipients were skipped")
+
 func run(args []string) error {
 	ctx := context.Background()
 	if len(args) == 0 {
 		return errors.New(usage)
 	}
-	ageBin, err := exec.LookPath("age")
+	configFile := os.Getenv("AGE_GITHUB_CONFIG")
+	var overrides [][2]string // config settings from command line flags
+	var opts ageOptions
+	var timing bool
+	flags := map[string]wrapperFlag{
+		"config": stringFlag(&configFile),
+		"recipients-from": {set: func(s string) error {
+			opts.rosters = append(opts.rosters, s)
+			return nil
+		}},
+		"archive": stringFlag(&opts.archive),
+		"zstd":    boolFlag(&opts.zstd),
+		"gpg":     boolFlag(&opts.gpg),
+		"timing":  boolFlag(&timing),
+		"strict":  boolFlag(&opts.strict),
+		"provider": {set: func(s string) error { // short for --default-provider
+			overrides = append(overrides, [2]string{"default_provider", s})
+			return nil
+		}},
+
+		"skip-missing": boolFlag(&opts.skipMissing),
+		"min-recipients": {set: func(s string) (err error) {
+			if opts.minRecipients, err = strconv.Atoi(s); err == nil && opts.minRecipients < 0 {
+				err = errors.New("must not be negative")
+			}
+			return err
+		}},
+	}
+	for _, key := range topLevelSettings {
+		key := key
+		flags[strings.ReplaceAll(key, "_", "-")] = wrapperFlag{isBool: boolSettings[key], set: func(s string) error {
+			overrides = append(overrides, [2]string{key, s})
+			return nil
+		}}
+	}
+	args, err := extractFlags(args, flags, func(s string) bool {
+		_, ok := subcommands[s]
+		return ok || s == "daemon"
+	})
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if err := cfg.loadEnv(os.Environ()); err != nil {
+		return err
+	}
+	for _, kv := range overrides {
+		if err := cfg.set("", kv[0], kv[1]); err != nil {
+			return fmt.Errorf("--%s: %w", strings.ReplaceAll(kv[0], "_", "-"), err)
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	cfg.lazyTokens()
+	if len(args) != 0 && args[0] == "daemon" {
+		r, err := newResolver(cfg, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer r.tracer.finish(nil)
+		defer r.tlog.flush()
+		return runDaemon(ctx, r, args[1:])
+	}
+	var timings *resolve.Timings
+	if timing {
+		timings = resolve.NewTimings()
+	}
+	r, err := newResolver(cfg, daemonClient(cfg.Socket), timings)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		if cmd, ok := subcommands[args[0]]; ok {
+			if args[0] != "serve" { // its requests are traced separately
+				ctx = r.tracer.begin(ctx, "age-github "+args[0])
+			}
+			err := cmd(ctx, r, args[1:])
+			r.tlog.flush()
+			r.tracer.finish(err)
+			r.timings.Print(os.Stderr)
+			return err
+		}
+	}
+	ctx = r.tracer.begin(ctx, "age-github")
+	if opts.gpg {
+		err = runGPG(ctx, r, args, opts)
+	} else {
+		err = runAge(ctx, r, args, opts)
+	}
+	r.tlog.flush()
+	r.tracer.finish(err)
+	return err
+}
+
+// ageOptions are wrapper flags affecting how age is called.
+type ageOptions struct {
+	rosters []string // roster files to read recipients from
+	archive string   // directory to archive before encryption or to extract to after decryption
+	zstd    bool     // compress archive with zstd
+	gpg     bool     // encrypt with gpg to PGP keys users publish, see runGPG
+	strict  bool     // fail on @handle arguments not used as recipients
+
+	skipMissing   bool // skip users that don't exist or have no keys, instead of failing
+	minRecipients int  // min number of recipients to encrypt to, not counting self
+}
+
+// runAge replaces current process with age, passing it args with handles
+// expanded to keys, and with recipients from roster files added.
+func runAge(ctx context.Context, r *resolver, args []string, opts ageOptions) error {
+	ageBin, err := exec.LookPath(r.cfg.Backend)
 	if err != nil {
 		return err
 	}
-	var cache cacheDir
-	if dir, err := os.UserCacheDir(); err == nil && dir != "" {
-		cache = cacheDir(filepath.Join(dir, "age-github"))
+	cmd, err := parseAgeArgs(args)
+	if err != nil {
+		return err
 	}
 	ageArgs := make([]string, 0, len(args)+1)
 	ageArgs = append(ageArgs, ageBin) // exec needs this
-	for i, v := range args {
-		if strings.HasPrefix(v, "@") && i > 0 && isRecipientFlag(args[i-1]) {
-			userName := v[1:]
-			keys, err := fetchGithubKeys(ctx, userName, cache)
-			if err != nil {
-				return fmt.Errorf("fetching keys for github user %q: %w", userName, err)
-			}
-			if len(keys) == 0 {
-				return fmt.Errorf("no keys found for github user %q", userName)
-			}
-			ageArgs = append(ageArgs, keys[0])
+	if r.cfg.Armor && !cmd.decrypt() && !cmd.has("a") {
+		ageArgs = append(ageArgs, "-a")
+	}
+	rosterHandles := make([][]string, len(opts.rosters))
+	var handles []string // all handles, to prefetch them in batch
+	for i, name := range opts.rosters {
+		if _, err := r.verifySignature(ctx, name); err != nil {
+			return err
+		}
+		list, err := readRoster(name)
+		if err != nil {
+			return err
+		}
+		rosterHandles[i] = list
+		handles = append(handles, list...)
+	}
+	for _, name := range cmd.values("R") {
+		if name == "-" {
 			continue
 		}
-		if j := strings.IndexRune(v, '='); j > 0 && isRecipientFlag(v[:j]) {
-			flagArg := v[j+1:]
-			if !strings.HasPrefix(flagArg, "@") {
-				ageArgs = append(ageArgs, v)
+		if _, err := r.verifySignature(ctx, name); err != nil {
+			return err
+		}
+	}
+	for _, v := range cmd.values("r") {
+		if strings.HasPrefix(v, "@") {
+			handles = append(handles, v[1:])
+		}
+	}
+	if cmd.encrypt() {
+		for _, s := range r.cfg.Recipients {
+			if strings.HasPrefix(s, "@") {
+				handles = append(handles, s[1:])
+			}
+		}
+	}
+	r.Prefetch(ctx, handles)
+	// the same key may come from different handles or groups, each unique
+	// key is passed to age only once
+	seen := make(map[string]struct{})
+	addKeys := func(keys []string) {
+		for _, k := range keys {
+			id := keyID(k)
+			if _, ok := seen[id]; ok {
 				continue
 			}
-			userName := flagArg[1:]
-			keys, err := fetchGithubKeys(ctx, userName, cache)
+			seen[id] = struct{}{}
+			ageArgs = append(ageArgs, "-r", k)
+		}
+	}
+	var skipped int // users skipped because of opts.skipMissing
+	recipients := func(handle string) ([]string, error) {
+		if !opts.skipMissing {
+			return r.recipients(ctx, handle)
+		}
+		keys, n, err := r.presentRecipients(ctx, handle)
+		skipped += n
+		return keys, err
+	}
+	for i, name := range opts.rosters {
+		for _, h := range rosterHandles[i] {
+			keys, err := recipients(h)
 			if err != nil {
-				return fmt.Errorf("fetching keys for github user %q: %w", userName, err)
-			}
-			if len(keys) == 0 {
-				return fmt.Errorf("no keys found for github user %q", userName)
+				return fmt.Errorf("%s: %w", name, err)
 			}
-			ageArgs = append(ageArgs, "-r", keys[0])
-			continue
+			addKeys(keys)
 		}
-		ageArgs = append(ageArgs, v)
 	}
-	return syscall.Exec(ageBin, ageArgs, os.Environ())
-}
-
-func fetchGithubKeys(ctx context.Context, username string, cache cacheDir) ([]string, error) {
-	if !validGithubHandle(username) {
-		return nil, errors.New("not a valid github user name")
+	var selfCount int // number of keys added by self setting
+	if r.cfg.Self != "" && !cmd.decrypt() && (len(opts.rosters) != 0 || cmd.has("r") || cmd.has("R") ||
+		(len(r.cfg.Recipients) != 0 && cmd.encrypt())) {
+		keys, err := r.selfKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("self: %w", err)
+		}
+		n := len(seen)
+		addKeys(keys)
+		selfCount = len(seen) - n
 	}
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	if data, err := cache.get(username); err == nil {
-		return parseReaderToKeys(bytes.NewReader(data))
+	if len(r.cfg.Recipients) != 0 && cmd.encrypt() {
+		for _, s := range r.cfg.Recipients {
+			if !strings.HasPrefix(s, "@") {
+				addKeys([]string{s})
+				continue
+			}
+			keys, err := recipients(s[1:])
+			if err != nil {
+				return fmt.Errorf("recipients setting: %w", err)
+			}
+			addKeys(keys)
+		}
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://github.com/"+username+".keys", nil)
-	if err != nil {
-		return nil, err
+	for _, f := range cmd.flags {
+		if f.name != "r" {
+			ageArgs = append(ageArgs, f.raw...)
+			continue
+		}
+		if !strings.HasPrefix(f.value, "@") {
+			addKeys([]string{f.value})
+			continue
+		}
+		keys, err := recipients(f.value[1:])
+		if err != nil {
+			return err
+		}
+		addKeys(keys)
 	}
-	req.Header.Set("User-Agent", "github.com/artyom/age-github")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+	for _, v := range cmd.args {
+		if strings.HasPrefix(v, "@") {
+			// most likely a mistake, i.e. "@handle" instead of
+			// "-r @handle", unless such file exists
+			if _, err := os.Stat(v); err != nil {
+				if opts.strict {
+					return fmt.Errorf("%s is not a recipient, did you mean -r %s?", v, v)
+				}
+				warnf("%s is passed to age as is, did you mean -r %s?", v, v)
+			}
+		}
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected response code %q", resp.Status)
+	if len(cmd.args) > 1 {
+		return fmt.Errorf("too many arguments %q, age takes a single input file", cmd.args)
 	}
-	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
-		return nil, fmt.Errorf("unexpected content type %q", ct)
+	// flags may follow input file on the command line, but age wants it last
+	nflags := len(ageArgs)
+	ageArgs = append(ageArgs, cmd.inputArgs()...)
+	r.timings.Print(os.Stderr)
+	if n := len(seen) - selfCount; n < opts.minRecipients && !cmd.decrypt() {
+		return fmt.Errorf("only %d recipient(s) resolved, --min-recipients requires %d", n, opts.minRecipients)
 	}
-	buf := new(bytes.Buffer) // copy of resp.Body consumed by parseReaderToKeys
-	keys, err := parseReaderToKeys(io.TeeReader(io.LimitReader(resp.Body, 1<<18), buf))
-	if err != nil {
-		return nil, err
+	switch {
+	case opts.archive != "" && cmd.decrypt() && cmd.has("o"):
+		return errors.New("--archive extracts decrypted archive into directory, it can't be used with -o")
+	case opts.archive != "" && !cmd.decrypt() && cmd.input() != "":
+		return fmt.Errorf("--archive encrypts directory, it can't be used with input file %q", cmd.input())
+	case opts.archive != "":
+		err = runArchive(ctx, ageArgs, cmd.decrypt(), cmd.input() == "", opts)
+	case r.cfg.Progress && isTerminal(os.Stderr):
+		err = runWithProgress(ctx, ageBin, ageArgs[1:nflags], cmd.input())
+	case skipped == 0:
+		r.tlog.flush()
+		r.tracer.finish(nil) // spans would be lost otherwise
+		return syscall.Exec(ageBin, ageArgs, os.Environ())
+	default:
+		// exit status must tell that some users were skipped, so age
+		// can't replace this process
+		age := exec.CommandContext(ctx, ageBin, ageArgs[1:]...)
+		age.Stdin, age.Stdout, age.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err = age.Run()
 	}
-	_ = cache.put(username, buf.Bytes())
-	return keys, nil
+	if err == nil && skipped != 0 {
+		return fmt.Errorf("%w: %d user(s) not found or without usable keys", errSkipped, skipped)
+	}
+	return err
 }
 
-// parseReaderToKeys parses reader, returning at most 10 lines starting with
-// "ssh-" prefix
-func parseReaderToKeys(r io.Reader) ([]string, error) {
-	var out []string
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		if len(out) == 10 {
-			return out, nil
-		}
-		line := scanner.Text()
-		if strings.HasPrefix(line, "ssh-") {
-			out = append(out, line)
-		}
+// selfKeys returns keys of the "self" setting, which is either an @handle, a
+// literal recipient, or a name of age identity file.
+func (r *resolver) selfKeys(ctx context.Context) ([]string, error) {
+	self := r.cfg.Self
+	switch {
+	case strings.HasPrefix(self, "@"):
+		return r.recipients(ctx, self[1:])
+	case strings.HasPrefix(self, "age1"), strings.HasPrefix(self, "ssh-"):
+		return []string{self}, nil
 	}
-	if err := scanner.Err(); err != nil {
+	// age-keygen -y prints recipients of X25519 identities
+	out, err := exec.CommandContext(ctx, "age-keygen", "-y", self).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("age-keygen -y %s: %s", self, bytes.TrimSpace(ee.Stderr))
+		}
 		return nil, err
 	}
-	return out, nil
+	keys := strings.Fields(string(out))
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no identities found in %s", self)
+	}
+	return keys, nil
 }
 
-func validGithubHandle(s string) bool {
-	return userNameRe.MatchString(s)
+// warnf prints a warning message to stderr.
+func warnf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
 }
 
-func isRecipientFlag(s string) bool {
-	switch s {
-	case "-r", "--r", "-recipient", "--recipient":
-		return true
+// keyID returns key with its comment stripped, so that the same keys with
+// different comments are considered equal.
+func keyID(key string) string {
+	if fields := strings.Fields(key); len(fields) >= 2 {
+		return fields[0] + " " + fields[1]
 	}
-	return false
+	return key
 }
 
-var userNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]+$`)
-
-type cacheDir string
-
-func (c cacheDir) get(key string) ([]byte, error) {
-	if c == "" {
-		return nil, os.ErrNotExist
-	}
-	filename := filepath.Join(string(c), fmt.Sprintf("%x", sha1.Sum([]byte(key))))
-	st, err := os.Stat(filename)
-	if err != nil {
-		return nil, err
-	}
-	if st.ModTime().Add(time.Hour).Before(time.Now()) { // stale entry
-		return nil, os.ErrNotExist
-	}
-	return ioutil.ReadFile(filename)
-}
-
-func (c cacheDir) put(key string, data []byte) error {
-	if c == "" {
-		return nil
-	}
-	filename := fmt.Sprintf("%x", sha1.Sum([]byte(key)))
-	if err := os.MkdirAll(string(c), 0777); err != nil {
-		return err
+// uniqueStrings returns s with duplicates removed, preserving order.
+func uniqueStrings(s []string) []string {
+	seen := make(map[string]struct{}, len(s))
+	out := s[:0:0]
+	for _, v := range s {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
 	}
-	return ioutil.WriteFile(filepath.Join(string(c), filename), data, 0666)
+	return out
 }
 
 const usage = `age-github is the age tool [1] wrapper which allows using github
@@ -194,4 +394,14 @@ https://github.com/artyom user, you call it as
 
 	age-github -r @artyom ...
 
+To print resolved keys instead of calling age, use subcommands:
+
+	age-github resolve @artyom ...
+	age-github export @artyom ... > recipients.txt
+
+To encrypt or decrypt a file without learning age flags:
+
+	age-github encrypt -r @artyom file
+	age-github decrypt file.age
+
 [1]: https://filippo.io/age`
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// fetchBuckets are upper bounds of fetch duration histogram buckets, in
+// seconds.
+var fetchBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics collects counters served by daemon and serve subcommands at
+// /metrics in Prometheus text format.
+type metrics struct {
+	mu          sync.Mutex
+	resolutions map[[2]string]uint64     // by result and source
+	fetches     map[[3]string]uint64     // by provider, source, and result
+	durations   map[[2]string]*histogram // by provider and source
+	requests    map[[2]string]uint64     // by handler and status code
+}
+
+type histogram struct {
+	counts []uint64 // per bucket of fetchBuckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		resolutions: make(map[[2]string]uint64),
+		fetches:     make(map[[3]string]uint64),
+		durations:   make(map[[2]string]*histogram),
+		requests:    make(map[[2]string]uint64),
+	}
+}
+
+// resolved records resolution of a user, see resolve.Config.Audit.
+func (m *metrics) resolved(ev resolve.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resolutions[[2]string{result(ev.Err), ev.Source}]++
+}
+
+// fetched records keys fetch, see resolve.Config.Fetched.
+func (m *metrics) fetched(provider, source string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetches[[3]string{provider, source, result(err)}]++
+	key := [2]string{provider, source}
+	h, ok := m.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(fetchBuckets))}
+		m.durations[key] = h
+	}
+	sec := d.Seconds()
+	for i, le := range fetchBuckets {
+		if sec <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += sec
+	h.count++
+}
+
+// instrument wraps handler, counting its requests by response status code.
+func (m *metrics) instrument(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h(sw, req)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests[[2]string{name, strconv.Itoa(sw.code)}]++
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// handleMetrics serves metrics, along with API rate limits of providers.
+func (r *resolver) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	m := r.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(bw, "# HELP age_github_resolutions_total Resolutions of users, by result and where keys came from.")
+	fmt.Fprintln(bw, "# TYPE age_github_resolutions_total counter")
+	for _, k := range sortedKeys2(m.resolutions) {
+		fmt.Fprintf(bw, "age_github_resolutions_total{result=%q,source=%q} %d\n", k[0], k[1], m.resolutions[k])
+	}
+	fmt.Fprintln(bw, "# HELP age_github_fetches_total Fetches of user keys, by provider, source (cache hits have \"cache\" source), and result.")
+	fmt.Fprintln(bw, "# TYPE age_github_fetches_total counter")
+	keys := make([][3]string, 0, len(m.fetches))
+	for k := range m.fetches {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return strings.Join(keys[i][:], "\x00") < strings.Join(keys[j][:], "\x00") })
+	for _, k := range keys {
+		fmt.Fprintf(bw, "age_github_fetches_total{provider=%q,source=%q,result=%q} %d\n", k[0], k[1], k[2], m.fetches[k])
+	}
+	fmt.Fprintln(bw, "# HELP age_github_fetch_duration_seconds Time to fetch user keys, by provider and source.")
+	fmt.Fprintln(bw, "# TYPE age_github_fetch_duration_seconds histogram")
+	for _, k := range sortedKeys2(m.durations) {
+		h := m.durations[k]
+		var cum uint64
+		for i, le := range fetchBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(bw, "age_github_fetch_duration_seconds_bucket{provider=%q,source=%q,le=\"%g\"} %d\n", k[0], k[1], le, cum)
+		}
+		fmt.Fprintf(bw, "age_github_fetch_duration_seconds_bucket{provider=%q,source=%q,le=\"+Inf\"} %d\n", k[0], k[1], h.count)
+		fmt.Fprintf(bw, "age_github_fetch_duration_seconds_sum{provider=%q,source=%q} %g\n", k[0], k[1], h.sum)
+		fmt.Fprintf(bw, "age_github_fetch_duration_seconds_count{provider=%q,source=%q} %d\n", k[0], k[1], h.count)
+	}
+	fmt.Fprintln(bw, "# HELP age_github_requests_total HTTP requests served, by handler and status code.")
+	fmt.Fprintln(bw, "# TYPE age_github_requests_total counter")
+	for _, k := range sortedKeys2(m.requests) {
+		fmt.Fprintf(bw, "age_github_requests_total{handler=%q,code=%q} %d\n", k[0], k[1], m.requests[k])
+	}
+	limits := r.RateLimits()
+	fmt.Fprintln(bw, "# HELP age_github_rate_limit_remaining API requests remaining until rate limit resets, as reported by provider.")
+	fmt.Fprintln(bw, "# TYPE age_github_rate_limit_remaining gauge")
+	for _, rl := range limits {
+		fmt.Fprintf(bw, "age_github_rate_limit_remaining{provider=%q,resource=%q} %d\n", rl.Provider, rl.Resource, rl.Remaining)
+	}
+	fmt.Fprintln(bw, "# HELP age_github_rate_limit_reset_timestamp_seconds When API rate limit resets.")
+	fmt.Fprintln(bw, "# TYPE age_github_rate_limit_reset_timestamp_seconds gauge")
+	for _, rl := range limits {
+		fmt.Fprintf(bw, "age_github_rate_limit_reset_timestamp_seconds{provider=%q,resource=%q} %d\n", rl.Provider, rl.Resource, rl.Reset.Unix())
+	}
+}
+
+// sortedKeys2 returns keys of map keyed by string pairs, sorted.
+func sortedKeys2(m interface{}) [][2]string {
+	var keys [][2]string
+	switch m := m.(type) {
+	case map[[2]string]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[[2]string]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// tracer records OpenTelemetry spans of resolutions, cache operations, and
+// upstream HTTP requests, see resolve.Config.StartSpan, and exports them to
+// collector using OTLP/HTTP protocol with JSON encoding. It's configured with
+// standard environment variables, see newTracer. Methods of nil tracer do
+// nothing, which is what is used if tracing is not configured.
+//
+// Trace context is propagated with W3C traceparent header to daemon and
+// upstream servers, and taken from requests served by daemon and serve
+// subcommands, and from TRACEPARENT environment variable, i.e. set by CI
+// pipeline calling age-github, so that traces are end-to-end.
+type tracer struct {
+	endpoint string
+	headers  http.Header
+	resource []otlpAttribute
+	client   *http.Client
+	timeout  time.Duration
+	parent   spanContext // from TRACEPARENT, if set
+
+	mu      sync.Mutex
+	spans   []*span // ended, not yet exported
+	dropped int     // spans dropped since last export, as queue was full
+	root    *span   // span of the whole command, see begin
+}
+
+// maxQueuedSpans is how many ended spans are kept until they're exported.
+const maxQueuedSpans = 2048
+
+// traceExportInterval is how often ended spans are exported.
+const traceExportInterval = 5 * time.Second
+
+// Span kinds, see OTLP specification.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+func (sc spanContext) valid() bool { return sc.traceID != [16]byte{} && sc.spanID != [8]byte{} }
+
+// traceparent returns W3C traceparent header value for sc.
+func (sc spanContext) traceparent() string {
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-01"
+}
+
+// parseTraceparent parses W3C traceparent header value.
+func parseTraceparent(s string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if n, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || n != len(sc.traceID) || len(parts[1]) != 2*n {
+		return sc, false
+	}
+	if n, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || n != len(sc.spanID) || len(parts[2]) != 2*n {
+		return sc, false
+	}
+	return sc, sc.valid()
+}
+
+// span implements resolve.Span.
+type span struct {
+	t      *tracer
+	sc     spanContext
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs []otlpAttribute
+	end   time.Time
+	err   error
+}
+
+type spanKey struct{}
+
+// newTracer returns tracer configured with OpenTelemetry environment
+// variables, or nil, if OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT is not set, or if OTEL_SDK_DISABLED is true, or
+// OTEL_TRACES_EXPORTER is "none". Only http/json protocol is supported. Spans
+// are exported every traceExportInterval, and on finish.
+func newTracer(cfg *config, client *http.Client) (*tracer, error) {
+	if disabled, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED")); disabled || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil, nil
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP traces endpoint %q", endpoint)
+	}
+	if proto := otelEnv("PROTOCOL"); proto != "" && proto != "http/json" {
+		warnf("OTLP protocol %q is not supported, using http/json", proto)
+	}
+	t := &tracer{
+		endpoint: endpoint,
+		headers:  make(http.Header),
+		client:   client,
+		timeout:  cfg.Timeout,
+	}
+	headers, err := otelPairs(otelEnv("HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	for _, kv := range headers {
+		t.headers.Set(kv[0], kv[1])
+	}
+	attrs, err := otelPairs(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	for _, kv := range attrs {
+		if kv[0] == "service.name" {
+			if service == "" {
+				service = kv[1]
+			}
+			continue
+		}
+		t.resource = append(t.resource, stringAttribute(kv[0], kv[1]))
+	}
+	if service == "" {
+		service = "age-github"
+	}
+	t.resource = append(t.resource, stringAttribute("service.name", service))
+	if v := os.Getenv("TRACEPARENT"); v != "" {
+		if sc, ok := parseTraceparent(v); ok {
+			t.parent = sc
+		} else {
+			warnf("ignoring invalid TRACEPARENT %q", v)
+		}
+	}
+	go func() {
+		for range time.Tick(traceExportInterval) {
+			t.export()
+		}
+	}()
+	return t, nil
+}
+
+// otelEnv returns OTLP exporter setting, preferring its traces-specific
+// variant.
+func otelEnv(name string) string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); v != "" {
+		return v
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// otelPairs parses comma-separated list of key=value pairs, with
+// URL-encoded values, as used by OpenTelemetry environment variables.
+func otelPairs(s string) ([][2]string, error) {
+	var out [][2]string
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.IndexByte(pair, '=')
+		if i <= 0 {
+			return nil, errors.New("want key=value pairs")
+		}
+		v, err := url.QueryUnescape(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, [2]string{strings.TrimSpace(pair[:i]), v})
+	}
+	return out, nil
+}
+
+// startSpan starts span as a child of span ctx holds, or of one from
+// TRACEPARENT, if there's none, see resolve.Config.StartSpan.
+func (t *tracer) startSpan(ctx context.Context, name string) (context.Context, resolve.Span) {
+	return t.start(ctx, name, spanKindInternal, spanContext{})
+}
+
+// start starts span of kind. Span parent is remote, if valid, or, unless it's
+// a server span, one ctx holds, or one from TRACEPARENT.
+func (t *tracer) start(ctx context.Context, name string, kind int, remote spanContext) (context.Context, *span) {
+	s := &span{t: t, name: name, kind: kind, start: time.Now()}
+	parent := remote
+	if !parent.valid() && kind != spanKindServer {
+		if p, ok := ctx.Value(spanKey{}).(*span); ok {
+			parent = p.sc
+		} else {
+			parent = t.parent
+		}
+	}
+	if parent.valid() {
+		s.sc.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		_, _ = rand.Read(s.sc.traceID[:])
+	}
+	_, _ = rand.Read(s.sc.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, stringAttribute(key, value))
+}
+
+// End ends span, queueing it for export. Calls after the first one do
+// nothing.
+func (s *span) End(err error) {
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end, s.err = time.Now(), err
+	s.mu.Unlock()
+	t := s.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) >= maxQueuedSpans {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+}
+
+// begin starts span of the whole command, which spans started later are
+// children of.
+func (t *tracer) begin(ctx context.Context, name string) context.Context {
+	if t == nil {
+		return ctx
+	}
+	ctx, s := t.start(ctx, name, spanKindInternal, spanContext{})
+	t.mu.Lock()
+	t.root = s
+	t.mu.Unlock()
+	return ctx
+}
+
+// finish ends span started by begin, if any, and exports spans, i.e. before
+// exit, or before process is replaced with age.
+func (t *tracer) finish(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	root := t.root
+	t.mu.Unlock()
+	if root != nil {
+		root.End(err)
+	}
+	t.export()
+}
+
+// export sends ended spans to collector, reporting failures as warnings.
+func (t *tracer) export() {
+	t.mu.Lock()
+	spans, dropped := t.spans, t.dropped
+	t.spans, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped != 0 {
+		warnf("tracing: %d span(s) dropped, as export queue was full", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.send(spans); err != nil {
+		warnf("tracing: exporting %d span(s): %v", len(spans), err)
+	}
+}
+
+// OTLP JSON encoding of spans, see
+// https://github.com/open-telemetry/opentelemetry-proto.
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.StringValue = value
+	return a
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"` // 2 means error
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func (t *tracer) send(spans []*span) error {
+	list := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:    hex.EncodeToString(s.sc.traceID[:]),
+			SpanID:     hex.EncodeToString(s.sc.spanID[:]),
+			Name:       s.name,
+			Kind:       s.kind,
+			Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes: s.attrs,
+		}
+		if s.parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			o.Status.Code, o.Status.Message = 2, s.err.Error()
+		}
+		s.mu.Unlock()
+		list[i] = o
+	}
+	type scopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	type resourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	var rs resourceSpans
+	rs.Resource.Attributes = t.resource
+	rs.ScopeSpans = []scopeSpans{{Spans: list}}
+	rs.ScopeSpans[0].Scope.Name = "github.com/artyom/age-github"
+	body, err := json.Marshal(struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}{[]resourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range t.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "github.com/artyom/age-github")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return &resolve.NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &resolve.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// tracedClient returns copy of client with transport wrapped by t.transport.
+func tracedClient(client *http.Client, t *tracer) *http.Client {
+	c := *client
+	c.Transport = t.transport(client.Transport)
+	return &c
+}
+
+// transport returns http transport wrapping base, which records spans of
+// requests, and propagates trace context with traceparent header. If t is
+// nil, it returns base.
+func (t *tracer) transport(base http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{t: t, base: base}
+}
+
+type tracingTransport struct {
+	t    *tracer
+	base http.RoundTripper
+}
+
+func (tr *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, s := tr.t.start(req.Context(), "HTTP "+req.Method, spanKindClient, spanContext{})
+	u := *req.URL
+	u.User, u.RawQuery = nil, "" // neither is for traces
+	s.SetAttribute("http.request.method", req.Method)
+	s.SetAttribute("url.full", u.String())
+	s.SetAttribute("server.address", req.URL.Hostname())
+	req = req.Clone(req.Context())
+	req.Header.Set("traceparent", s.sc.traceparent())
+	resp, err := tr.base.RoundTrip(req)
+	if err != nil {
+		s.End(err)
+		return nil, err
+	}
+	s.SetAttribute("http.response.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		s.End(errors.New(resp.Status))
+	} else {
+		s.End(nil)
+	}
+	return resp, nil
+}
+
+// handler returns handler recording spans of requests h serves, continuing
+// trace of traceparent request header, if set. If t is nil, it returns h.
+func (t *tracer) handler(name string, h http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		remote, _ := parseTraceparent(req.Header.Get("traceparent"))
+		ctx, s := t.start(req.Context(), name, spanKindServer, remote)
+		s.SetAttribute("http.request.method", req.Method)
+		s.SetAttribute("url.path", req.URL.Path)
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h(sw, req.WithContext(ctx))
+		s.SetAttribute("http.response.status_code", strconv.Itoa(sw.code))
+		if sw.code >= 500 {
+			s.End(errors.New(http.StatusText(sw.code)))
+		} else {
+			s.End(nil)
+		}
+	}
+}
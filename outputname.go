@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"text/template"
+)
+
+// outputFields are fields available to output file name templates of
+// fanout and batch subcommands, i.e. "{{.Input}}.{{.Handle}}.age".
+type outputFields struct {
+	Input  string // input file name, as given
+	Dir    string // input file directory
+	Base   string // input file name without directory
+	Ext    string // input file name extension, i.e. ".gz"
+	Handle string // user handle, as in fanout copies names; empty in batch
+}
+
+// parseOutputTemplate parses output file name template, see outputFields.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	t, err := template.New("output").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("output template: %w", err)
+	}
+	return t, nil
+}
+
+// outputName returns output file name for input file, and user handle, if
+// any, made with template t.
+func outputName(t *template.Template, input, handle string) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, outputFields{
+		Input:  input,
+		Dir:    filepath.Dir(input),
+		Base:   filepath.Base(input),
+		Ext:    filepath.Ext(input),
+		Handle: handle,
+	})
+	if err != nil {
+		return "", fmt.Errorf("output template: %w", err)
+	}
+	if buf.Len() == 0 {
+		return "", errors.New("output template gives empty name")
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runPassage expands @handle lines in .age-recipients files of passage store
+// into concrete keys, optionally re-encrypting affected entries.
+//
+// A line holding @handle is replaced with a block:
+//
+//	#@handle
+//	ssh-ed25519 AAAA...
+//	#/@handle
+//
+// which age ignores as comments, and which is refreshed on subsequent runs.
+func runPassage(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("passage", flag.ContinueOnError)
+	store := fs.String("store", passageStoreDir(), "passage store `directory`")
+	reencrypt := fs.Bool("reencrypt", false, "run \"passage reencrypt\" on directories with changed recipients")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: age-github passage [-store dir] [-reencrypt]")
+	}
+	var changed []string // directories with updated recipients, relative to store
+	err := filepath.Walk(*store, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || info.Name() != ".age-recipients" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, err := expandRecipientsFile(ctx, r, data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if bytes.Equal(data, out) {
+			return nil
+		}
+		if err := ioutil.WriteFile(path, out, info.Mode().Perm()); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(*store, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "updated %s\n", path)
+		changed = append(changed, rel)
+		return nil
+	})
+	if err != nil || !*reencrypt {
+		return err
+	}
+	for _, dir := range changed {
+		cmdArgs := []string{"reencrypt"}
+		if dir != "." {
+			cmdArgs = append(cmdArgs, dir)
+		}
+		cmd := exec.CommandContext(ctx, "passage", cmdArgs...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(), "PASSAGE_DIR="+*store)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("passage reencrypt %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// expandRecipientsFile returns content of age recipients file with @handle
+// lines and previously expanded blocks replaced with freshly resolved keys.
+func expandRecipientsFile(ctx context.Context, r *resolver, data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var block string // handle of the block being skipped
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if block != "" {
+			if trimmed == "#/@"+block {
+				block = ""
+			}
+			continue
+		}
+		var handle string
+		switch {
+		case strings.HasPrefix(trimmed, "#@"):
+			handle = trimmed[2:]
+			block = handle
+		case strings.HasPrefix(trimmed, "@"):
+			handle = trimmed[1:]
+		default:
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+			continue
+		}
+		keys, err := r.recipients(ctx, handle)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "#@%s\n", handle)
+		for _, k := range keys {
+			fmt.Fprintln(buf, k)
+		}
+		fmt.Fprintf(buf, "#/@%s\n", handle)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if block != "" {
+		return nil, fmt.Errorf("block of @%s has no closing #/@%[1]s line", block)
+	}
+	return buf.Bytes(), nil
+}
+
+// passageStoreDir returns passage store location as passage itself does.
+func passageStoreDir() string {
+	if dir := os.Getenv("PASSAGE_DIR"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".passage", "store")
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runPin pins keys users currently publish into keys_dir files, or
+// re-confirms existing pins, resetting their age, see pin_max_age setting.
+func runPin(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("pin", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm without asking, only if pinned keys match published ones")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github pin [-yes] @handle...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no handles given")
+	}
+	if r.cfg.KeysDir == "" {
+		return errors.New("pinning requires keys directory, see keys_dir setting")
+	}
+	var users []string
+	for _, arg := range fs.Args() {
+		h := r.ExpandAlias(strings.TrimPrefix(arg, "@"))
+		if !resolve.IsGroupHandle(h) {
+			users = append(users, h)
+			continue
+		}
+		members, err := r.ExpandGroup(ctx, h)
+		if err != nil {
+			return fmt.Errorf("expanding group %q: %w", h, err)
+		}
+		users = append(users, members...)
+	}
+	stdin := bufio.NewReader(os.Stdin)
+	confirm := func(question string) bool {
+		if *yes {
+			return true
+		}
+		fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+		line, _ := stdin.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+	for _, u := range uniqueStrings(users) {
+		if err := r.pin(ctx, u, *yes, confirm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pin pins keys of a single user, or re-confirms existing pin. New and
+// changed keys are only pinned interactively.
+func (r *resolver) pin(ctx context.Context, handle string, yes bool, confirm func(string) bool) error {
+	name, p, err := r.LookupProvider(handle)
+	if err != nil {
+		return err
+	}
+	display := "@" + displayHandle(handle, r.cfg.DefaultProvider)
+	published, err := r.PublishedKeys(ctx, name, p)
+	if err != nil {
+		return err
+	}
+	file, _, err := r.LocalKeysFile(name, p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if file == "" {
+		if len(published) == 0 {
+			return fmt.Errorf("%s: %w", display, resolve.ErrNoKeys)
+		}
+		if yes {
+			return fmt.Errorf("%s: keys are not pinned yet, pin them interactively", display)
+		}
+		fmt.Fprintf(os.Stderr, "%s publishes:\n", display)
+		printFingerprints("  ", published)
+		if !confirm("Pin these keys?") {
+			return fmt.Errorf("%s: not pinned", display)
+		}
+		return writePin(filepath.Join(r.cfg.KeysDir, name+"@"+p.Host), p.Host, published)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var pinned []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "ssh-") || resolve.IsAgeRecipient(line) {
+			pinned = append(pinned, line)
+		}
+	}
+	added, removed := keysDiff(pinned, published)
+	if len(added) == 0 && len(removed) == 0 {
+		if !confirm(fmt.Sprintf("Pinned keys of %s match published ones, keep trusting them?", display)) {
+			return fmt.Errorf("%s: pin not confirmed", display)
+		}
+		now := time.Now()
+		if err := os.Chtimes(file, now, now); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s: pin confirmed\n", display)
+		return nil
+	}
+	if yes {
+		return fmt.Errorf("%s: published keys differ from pinned ones in %s, re-pin interactively", display, file)
+	}
+	fmt.Fprintf(os.Stderr, "%s keys changed since they were pinned in %s\n", display, file)
+	if len(removed) != 0 {
+		fmt.Fprintln(os.Stderr, "no longer published:")
+		printFingerprints("  - ", removed)
+	}
+	if len(added) != 0 {
+		fmt.Fprintln(os.Stderr, "newly published:")
+		printFingerprints("  + ", added)
+	}
+	if !confirm("Replace pinned keys with published ones?") {
+		return fmt.Errorf("%s: pin not updated", display)
+	}
+	return writePin(file, p.Host, published)
+}
+
+// keysDiff returns keys present only in published, and only in pinned lists,
+// ignoring key comments.
+func keysDiff(pinned, published []string) (added, removed []string) {
+	in := func(k string, list []string) bool {
+		for _, x := range list {
+			if keyID(x) == keyID(k) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, k := range published {
+		if !in(k, pinned) {
+			added = append(added, k)
+		}
+	}
+	for _, k := range pinned {
+		if !in(k, published) {
+			removed = append(removed, k)
+		}
+	}
+	return added, removed
+}
+
+func printFingerprints(prefix string, keys []string) {
+	for _, k := range keys {
+		if resolve.IsAgeRecipient(k) {
+			fmt.Fprintf(os.Stderr, "%s%s\n", prefix, k)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s%s %s\n", prefix, strings.SplitN(k, " ", 2)[0], resolve.Fingerprint(k))
+	}
+}
+
+// writePin writes keys into keys_dir file.
+func writePin(name, host string, keys []string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# pinned from %s at %s\n", host, time.Now().UTC().Format(time.RFC3339))
+	for _, k := range keys {
+		buf.WriteString(k + "\n")
+	}
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d key(s) pinned in %s\n", len(keys), name)
+	return nil
+}
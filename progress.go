@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// progressDelay is how long age runs before progress is shown, so that small
+// inputs don't flash it.
+const progressDelay = time.Second
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// runWithProgress runs age as a child process, with ageArgs flags, feeding
+// its input, which is input file or stdin if it's empty, through a counter,
+// and reporting how much of it is processed on stderr, see progress setting.
+func runWithProgress(ctx context.Context, ageBin string, ageArgs []string, input string) error {
+	in := os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	total := int64(-1)
+	if fi, err := in.Stat(); err == nil && fi.Mode().IsRegular() {
+		total = fi.Size()
+	}
+	cr := &countingReader{r: in}
+	age := exec.CommandContext(ctx, ageBin, ageArgs...)
+	age.Stdin, age.Stdout, age.Stderr = cr, os.Stdout, os.Stderr
+	if err := age.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	shown := make(chan bool, 1)
+	go func() {
+		var ok bool
+		defer func() { shown <- ok }()
+		start := time.Now()
+		delay := time.NewTimer(progressDelay)
+		defer delay.Stop()
+		select {
+		case <-done:
+			return
+		case <-delay.C:
+		}
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			n := atomic.LoadInt64(&cr.n)
+			rate := float64(n) / time.Since(start).Seconds()
+			if total >= 0 {
+				pct := 100.0
+				if total > 0 {
+					pct = float64(n) * 100 / float64(total)
+				}
+				fmt.Fprintf(os.Stderr, "\r%s / %s (%.0f%%), %s/s\033[K", byteSize(n), byteSize(total), pct, byteSize(int64(rate)))
+			} else {
+				fmt.Fprintf(os.Stderr, "\r%s, %s/s\033[K", byteSize(n), byteSize(int64(rate)))
+			}
+			ok = true
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	err := age.Wait()
+	close(done)
+	if <-shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64 // updated atomically
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// byteSize formats n bytes with binary unit prefix.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// commandDialer returns dial function connecting through proxy command,
+// similar to ssh ProxyCommand: command is run with %h and %p replaced by
+// target host and port, and its stdin and stdout are used as connection to
+// the target. This allows using tools that handle proxy authentication
+// schemes not supported natively, like NTLM or Negotiate (i.e. ncat
+// --proxy-auth, or corkscrew).
+func commandDialer(command string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		line := strings.NewReplacer("%h", host, "%p", port, "%%", "%").Replace(command)
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", line)
+		} else {
+			cmd = exec.Command("/bin/sh", "-c", line)
+		}
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &cmdConn{cmd: cmd, w: stdin, r: stdout, addr: cmdAddr(addr)}, nil
+	}
+}
+
+// cmdConn is net.Conn over proxy command stdin and stdout.
+type cmdConn struct {
+	cmd  *exec.Cmd
+	w    io.WriteCloser
+	r    io.ReadCloser
+	addr cmdAddr
+}
+
+func (c *cmdConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *cmdConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+func (c *cmdConn) Close() error {
+	c.w.Close()
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait() // reports the kill above
+	return nil
+}
+
+func (c *cmdConn) LocalAddr() net.Addr  { return cmdAddr("proxy-command") }
+func (c *cmdConn) RemoteAddr() net.Addr { return c.addr }
+
+// Deadlines are not supported, connection is closed on context cancellation
+// instead.
+func (c *cmdConn) SetDeadline(time.Time) error      { return nil }
+func (c *cmdConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *cmdConn) SetWriteDeadline(time.Time) error { return nil }
+
+type cmdAddr string
+
+func (a cmdAddr) Network() string { return "proxy-command" }
+func (a cmdAddr) String() string  { return string(a) }
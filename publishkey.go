@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runPublishKey uploads ssh public key to account of the default provider
+// token owner. Token must have a permission to manage user keys
+// (write:public_key scope on GitHub, api scope on GitLab).
+func runPublishKey(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("publish-key", flag.ContinueOnError)
+	title := fs.String("title", "", "key `title`, defaults to key comment, or host name")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github publish-key [-title title] key.pub")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("public key file is required")
+	}
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	key := strings.TrimSpace(string(data))
+	fields := strings.Fields(key)
+	if len(fields) < 2 || resolve.Fingerprint(key) == "" {
+		return fmt.Errorf("%s is not an ssh public key", fs.Arg(0))
+	}
+	if strings.HasSuffix(fields[0], "-cert-v01@openssh.com") || !strings.HasPrefix(key, "ssh-") {
+		return fmt.Errorf("%s: %s keys can't be used as age recipients", fs.Arg(0), fields[0])
+	}
+	if *title == "" {
+		if len(fields) > 2 {
+			*title = strings.Join(fields[2:], " ")
+		} else if *title, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	p := r.cfg.Providers[r.cfg.DefaultProvider]
+	login, err := r.tokenUser(ctx, p)
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Title string `json:"title"`
+		Key   string `json:"key"`
+	}{Title: *title, Key: fields[0] + " " + fields[1]}
+	if err := r.APIPost(ctx, p, p.APIURL("/user/keys"), body, nil); err != nil {
+		var httpErr *resolve.HTTPError
+		if errors.As(err, &httpErr) {
+			switch httpErr.StatusCode {
+			case http.StatusUnprocessableEntity, http.StatusBadRequest:
+				return fmt.Errorf("%s rejected the key, it may already be published: %w", p.Host, err)
+			case http.StatusNotFound, http.StatusForbidden:
+				return fmt.Errorf("token is not allowed to manage keys, it needs write:public_key (GitHub) or api (GitLab) scope: %w", err)
+			}
+		}
+		return err
+	}
+	fmt.Printf("published %s as %q on %s account of %s\n", resolve.Fingerprint(key), *title, p.Host, login)
+	return nil
+}
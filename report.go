@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// reportRow describes a single key of a recipient, or a recipient without
+// usable keys.
+type reportRow struct {
+	Handle      string     `json:"handle"`
+	KeyType     string     `json:"key_type,omitempty"`
+	Bits        int        `json:"bits,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`   // only GitLab publishes creation dates
+	Pinned      bool       `json:"pinned"`              // key comes from keys_dir file
+	Used        bool       `json:"used"`                // key is used for encryption
+	Violation   string     `json:"violation,omitempty"` // why user can't be resolved
+}
+
+// runReport prints report on keys of recipients listed in roster files and
+// arguments, for periodic reviews of who secrets are encrypted to.
+func runReport(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	var rosters stringList
+	fs.Var(&rosters, "f", "roster `file` with handles to report on, may be repeated")
+	format := fs.String("format", "text", "output `format`: text, json, or csv")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github report [-f roster]... [-format text|json|csv] [@handle...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch *format {
+	case "text", "json", "csv":
+	default:
+		return fmt.Errorf("unsupported format %q", *format)
+	}
+	var handles []string
+	for _, name := range rosters {
+		list, err := readRoster(name)
+		if err != nil {
+			return err
+		}
+		handles = append(handles, list...)
+	}
+	for _, arg := range fs.Args() {
+		handles = append(handles, strings.TrimPrefix(arg, "@"))
+	}
+	if len(handles) == 0 {
+		fs.Usage()
+		return errors.New("no handles given")
+	}
+	var users []string
+	for _, h := range handles {
+		h = r.cfg.Aliases.expand(h)
+		if !resolve.IsGroupHandle(h) {
+			users = append(users, h)
+			continue
+		}
+		members, err := r.ExpandGroup(ctx, h)
+		if err != nil {
+			return fmt.Errorf("expanding group %q: %w", h, err)
+		}
+		users = append(users, members...)
+	}
+	users = uniqueStrings(users)
+	r.Prefetch(ctx, users)
+	var rows []reportRow
+	for _, u := range users {
+		list, err := r.reportRows(ctx, u)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, list...)
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"handle", "key_type", "bits", "fingerprint", "created", "pinned", "used", "violation"})
+		for _, row := range rows {
+			w.Write(row.fields())
+		}
+		w.Flush()
+		return w.Error()
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "HANDLE\tTYPE\tBITS\tFINGERPRINT\tCREATED\tPINNED\tUSED\tVIOLATION")
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row.fields(), "\t"))
+	}
+	return tw.Flush()
+}
+
+// fields returns row values as strings, in csv column order.
+func (row reportRow) fields() []string {
+	var bits, created string
+	if row.Bits != 0 {
+		bits = strconv.Itoa(row.Bits)
+	}
+	if row.Created != nil {
+		created = row.Created.Format("2006-01-02")
+	}
+	return []string{row.Handle, row.KeyType, bits, row.Fingerprint, created,
+		strconv.FormatBool(row.Pinned), strconv.FormatBool(row.Used), row.Violation}
+}
+
+// reportRows returns report rows of all keys of a single user. Failures to
+// resolve user are reported as violations, except for network failures.
+func (r *resolver) reportRows(ctx context.Context, handle string) ([]reportRow, error) {
+	display := "@" + displayHandle(handle, r.cfg.DefaultProvider)
+	used := make(map[string]bool)
+	var violation string
+	resolved, err := r.Resolve(ctx, handle)
+	var netErr *resolve.NetworkError
+	switch {
+	case errors.As(err, &netErr), errors.Is(err, resolve.ErrRateLimited):
+		return nil, err
+	case err != nil:
+		violation = err.Error()
+	}
+	for _, rc := range resolved {
+		used[rc.PublicKey] = true
+	}
+	all, err := r.FetchRecipients(ctx, handle)
+	if err != nil || len(all) == 0 {
+		return []reportRow{{Handle: display, Violation: violation}}, nil
+	}
+	created := r.keyCreationDates(ctx, handle)
+	var rows []reportRow
+	for _, rc := range all {
+		row := reportRow{
+			Handle:      display,
+			KeyType:     rc.KeyType,
+			Bits:        resolve.KeyBits(rc.PublicKey),
+			Fingerprint: rc.Fingerprint,
+			Pinned:      rc.Source == "local",
+			Used:        used[rc.PublicKey],
+			Violation:   violation,
+		}
+		if t, ok := created[rc.PublicKey]; ok {
+			row.Created = &t
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// keyCreationDates returns creation times of user keys, keyed by key without
+// comment. Only GitLab publishes them, for other providers, or on failure,
+// it returns nil map.
+func (r *resolver) keyCreationDates(ctx context.Context, handle string) map[string]time.Time {
+	name, p, err := r.LookupProvider(handle)
+	if err != nil || p.Type != resolve.ProviderGitlab {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	var users []struct {
+		ID int64 `json:"id"`
+	}
+	if _, err := r.APIGet(ctx, p, p.APIURL("/users")+"?username="+url.QueryEscape(name), &users); err != nil || len(users) == 0 {
+		return nil
+	}
+	var keys []struct {
+		Key       string    `json:"key"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	if _, err := r.APIGet(ctx, p, p.APIURL("/users/"+strconv.FormatInt(users[0].ID, 10)+"/keys"), &keys); err != nil {
+		return nil
+	}
+	out := make(map[string]time.Time, len(keys))
+	for _, k := range keys {
+		if fields := strings.Fields(k.Key); len(fields) >= 2 {
+			out[fields[0]+" "+fields[1]] = k.CreatedAt
+		}
+	}
+	return out
+}
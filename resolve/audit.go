@@ -0,0 +1,37 @@
+package resolve
+
+import "time"
+
+// Event describes resolution of a single user, it's passed to Config.Audit.
+type Event struct {
+	Time      time.Time
+	Handle    string   // handle as requested
+	Provider  string   // provider name, empty if handle has unknown provider
+	User      string   // user name at provider
+	Keys      []string // fingerprints of keys resolution returned
+	Source    string   // where keys came from: local, cache, daemon, or http
+	FetchedAt time.Time
+	Err       error // resolution error, if any
+}
+
+// audit reports resolution of handle with Config.Audit, if set.
+func (r *Resolver) audit(handle string, p *Provider, username string, res fetchResult, out []Recipient, err error) {
+	if r.cfg.Audit == nil {
+		return
+	}
+	ev := Event{
+		Time:      time.Now(),
+		Handle:    handle,
+		User:      username,
+		Source:    res.source,
+		FetchedAt: res.at,
+		Err:       err,
+	}
+	if p != nil {
+		ev.Provider = p.Name
+	}
+	for _, rc := range out {
+		ev.Keys = append(ev.Keys, rc.Fingerprint)
+	}
+	r.cfg.Audit(ev)
+}
@@ -0,0 +1,323 @@
+package resolve
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// IAM user names: up to 64 letters, digits, and "+=,.@_-" characters.
+var iamUserNameRe = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+// fetchIAMKeys fetches active ssh public keys IAM user uploaded, i.e. for
+// CodeCommit, with ListSSHPublicKeys and GetSSHPublicKey IAM API calls, made
+// with ambient AWS credentials, see awsCredentials.
+func (r *Resolver) fetchIAMKeys(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	var list struct {
+		Keys []struct {
+			ID     string `xml:"SSHPublicKeyId"`
+			Status string `xml:"Status"`
+		} `xml:"ListSSHPublicKeysResult>SSHPublicKeys>member"`
+	}
+	err := r.iamCall(ctx, p, url.Values{"Action": {"ListSSHPublicKeys"}, "UserName": {username}}, &list)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for _, k := range list.Keys {
+		if k.Status != "Active" {
+			continue
+		}
+		var key struct {
+			Body string `xml:"GetSSHPublicKeyResult>SSHPublicKey>SSHPublicKeyBody"`
+		}
+		err := r.iamCall(ctx, p, url.Values{"Action": {"GetSSHPublicKey"}, "UserName": {username},
+			"SSHPublicKeyId": {k.ID}, "Encoding": {"SSH"}}, &key)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, strings.TrimSpace(key.Body)+"\n"...)
+	}
+	return buf, nil
+}
+
+// iamCall makes IAM API call signed with AWS Signature Version 4, decoding
+// XML response into v. Calls about users that don't exist fail with
+// ErrUserNotFound.
+func (r *Resolver) iamCall(ctx context.Context, p *Provider, params url.Values, v interface{}) error {
+	creds, err := r.awsCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("AWS credentials: %w", err)
+	}
+	params.Set("Version", "2010-05-08")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.APIURL("/")+"?"+awsQuery(params), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "github.com/artyom/age-github")
+	signAWS(req, creds, iamRegion(p.Host), "iam", time.Now())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	data, truncated, err := readLimited(resp.Body, r.cfg.MaxResponseSize)
+	if err != nil {
+		return err
+	}
+	if truncated {
+		return fmt.Errorf("response is larger than max_response_size of %d bytes", r.cfg.MaxResponseSize)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			if e.Code == "NoSuchEntity" {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("%s: %s", e.Code, e.Message)
+		}
+		return statusError(resp)
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// iamRegion returns region IAM requests to host are signed for: IAM is
+// a global service, with its endpoints in us-east-1, except for those of
+// GovCloud and China partitions.
+func iamRegion(host string) string {
+	switch {
+	case strings.HasSuffix(host, ".amazonaws.com.cn"):
+		return "cn-north-1"
+	case strings.Contains(host, "us-gov"):
+		return "us-gov-west-1"
+	}
+	return "us-east-1"
+}
+
+// awsQuery encodes query parameters the way Signature Version 4 canonical
+// requests need them: sorted by name, with spaces encoded as %20.
+func awsQuery(params url.Values) string {
+	return strings.Replace(params.Encode(), "+", "%20", -1)
+}
+
+// awsCreds are AWS credentials, temporary ones expire.
+type awsCreds struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// signAWS signs GET request with AWS Signature Version 4.
+func signAWS(req *http.Request, creds awsCreds, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := "host:" + req.URL.Host + "\nx-amz-date:" + amzDate + "\n"
+	signed := "host;x-amz-date"
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		headers += "x-amz-security-token:" + creds.SessionToken + "\n"
+		signed += ";x-amz-security-token"
+	}
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	emptyHash := sha256.Sum256(nil)
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, headers, signed, hex.EncodeToString(emptyHash[:])}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, s := range []string{now.Format("20060102"), region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// awsCredentials returns AWS credentials, looked up once, or again when
+// temporary ones are about to expire, the way AWS SDKs do by default: from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, then from
+// shared credentials file profile, then from ECS container credentials
+// endpoint, and finally from EC2 instance metadata service.
+func (r *Resolver) awsCredentials(ctx context.Context) (awsCreds, error) {
+	r.awsMu.Lock()
+	defer r.awsMu.Unlock()
+	if c := r.awsCreds; c.AccessKeyID != "" && (c.Expiration.IsZero() || time.Until(c.Expiration) > 5*time.Minute) {
+		return c, nil
+	}
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		r.awsCreds = awsCreds{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+		return r.awsCreds, nil
+	}
+	c, err := sharedAWSCredentials()
+	if os.IsNotExist(err) {
+		c, err = remoteAWSCredentials(ctx)
+	}
+	if err != nil {
+		return awsCreds{}, err
+	}
+	r.awsCreds = c
+	return c, nil
+}
+
+// sharedAWSCredentials reads credentials of AWS_PROFILE profile, or "default"
+// one, from shared credentials file. If there's no such file or profile,
+// returned error satisfies os.IsNotExist.
+func sharedAWSCredentials() (awsCreds, error) {
+	name := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if name == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCreds{}, os.ErrNotExist
+		}
+		name = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return awsCreds{}, err
+	}
+	defer f.Close()
+	var c awsCreds
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if section != profile || len(kv) != 2 {
+			continue
+		}
+		switch v := strings.TrimSpace(kv[1]); strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			c.AccessKeyID = v
+		case "aws_secret_access_key":
+			c.SecretAccessKey = v
+		case "aws_session_token":
+			c.SessionToken = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCreds{}, err
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return awsCreds{}, os.ErrNotExist
+	}
+	return c, nil
+}
+
+// remoteAWSCredentials gets temporary credentials of ECS task role, or of
+// EC2 instance profile.
+func remoteAWSCredentials(ctx context.Context) (awsCreds, error) {
+	// credentials endpoints are link-local, and must not be proxied
+	client := &http.Client{Transport: &http.Transport{}, Timeout: 2 * time.Second}
+	get := func(u string, header http.Header) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, statusError(resp)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+	var c awsCreds
+	var data []byte
+	var err error
+	switch rel, full := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); {
+	case rel != "":
+		data, err = get("http://169.254.170.2"+rel, nil)
+	case full != "":
+		header := make(http.Header)
+		if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+			header.Set("Authorization", token)
+		}
+		data, err = get(full, header)
+	case strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true"):
+		return c, errors.New("none found in environment, shared credentials file, or container")
+	default:
+		data, err = imdsCredentials(ctx, client, get)
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, errors.New("credentials endpoint returned no credentials")
+	}
+	return c, nil
+}
+
+// imdsCredentials returns JSON of EC2 instance profile credentials from
+// instance metadata service, with get function making GET requests.
+func imdsCredentials(ctx context.Context, client *http.Client, get func(u string, header http.Header) ([]byte, error)) ([]byte, error) {
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New("none found in environment, shared credentials file, container, or instance metadata")
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata token: %w", statusError(resp))
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	role, err := get(imds+"/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return nil, fmt.Errorf("instance profile: %w", err)
+	}
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	return get(imds+"/meta-data/iam/security-credentials/"+url.PathEscape(name), header)
+}
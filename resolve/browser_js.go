@@ -0,0 +1,10 @@
+//go:build js
+// +build js
+
+package resolve
+
+// browser tells that code runs in a web browser, where http client makes
+// requests with Fetch API, so only endpoints allowing cross-origin requests
+// can be used: keys are fetched over API even without a token, as plain .keys
+// endpoints don't allow them. CacheDir can't be used there, see Config.Cache.
+const browser = true
@@ -0,0 +1,7 @@
+//go:build !js
+// +build !js
+
+package resolve
+
+// browser tells that code runs in a web browser, see browser_js.go.
+const browser = false
@@ -0,0 +1,529 @@
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheDir is an on-disk cache of fetched keys. Zero value is a no-op cache.
+//
+// Cached responses are stored in "objects" subdirectory under names derived
+// from their SHA-256, so identical responses are stored once, and are
+// verified when read. The "index" file maps cache keys to objects, each line
+// holding time of fetch, object hash, and cache key; the last line for a key
+// wins. Objects are written to temporary files and renamed, and index lines
+// are appended with a single write. Index is rewritten by renaming too, when
+// it's compacted or entries are evicted, so readers always see a complete
+// index. Writers hold a lock of "index.lock" file while they append to or
+// rewrite index, so that lines appended concurrently with a rewrite are not
+// lost; where file locks are not supported (i.e. on Windows), such lines may
+// be lost, which only makes their keys fetched again. Outcomes of lookups
+// are appended to "stats" file, see recordLookup.
+//
+// Objects modification time is updated when they're read, so that entries
+// least recently used can be evicted when cache grows over its limits, see
+// evict.
+//
+// Entries missing in dir, or stale there, are looked up in shared directory of
+// the same layout, if it's set. That directory is never written to, so it can
+// be shared by many users, or baked into container images.
+type cacheDir struct {
+	dir        string
+	shared     string // read-only cache directory, see Config.SharedCacheDir
+	maxEntries int    // 0 means no limit
+	maxSize    int64  // total size of objects, 0 means no limit
+
+	// ttl returns how long entry of key is fresh, see Resolver.keyTTL.
+	ttl func(key string) time.Duration
+}
+
+// Cache stores fetched keys, see Config.Cache. It must be safe for
+// concurrent use.
+type Cache interface {
+	// Get returns data stored under key, and when it was stored. If
+	// there's no such entry, returned error must wrap os.ErrNotExist.
+	// Resolver ignores entries older than cache TTL of their provider
+	// itself, see Provider.CacheTTL.
+	Get(key string) ([]byte, time.Time, error)
+	// Put stores data under key.
+	Put(key string, data []byte) error
+}
+
+// cacheIndexMaxSize is the size after which index is compacted, leaving only
+// the latest line for each key.
+const cacheIndexMaxSize = 1 << 20
+
+// cacheEntry is an index entry.
+type cacheEntry struct {
+	hash string // hex-encoded SHA-256 of object
+	at   time.Time
+}
+
+// Get returns cached data for a key and time it was fetched.
+func (c cacheDir) Get(key string) ([]byte, time.Time, error) {
+	if (c.dir == "" && c.shared == "") || c.ttl(key) <= 0 {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	data, at, err := c.get(key, true)
+	if os.IsNotExist(err) && c.shared != "" {
+		data, at, err = cacheDir{dir: c.shared, ttl: c.ttl}.get(key, false)
+	}
+	if c.dir != "" && (err == nil || os.IsNotExist(err)) {
+		c.recordLookup(key, err == nil)
+	}
+	return data, at, err
+}
+
+// get looks key up in cache directory, updating modification time of object
+// found if touch is set.
+func (c cacheDir) get(key string, touch bool) ([]byte, time.Time, error) {
+	if c.dir == "" {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	index, err := c.readIndex()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	e, ok := index[key]
+	if !ok || e.at.Add(c.ttl(key)).Before(time.Now()) { // missing or stale entry
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	data, err := c.readObject(e.hash)
+	if err == nil && touch {
+		now := time.Now()
+		_ = os.Chtimes(c.objectName(e.hash), now, now) // for LRU eviction
+	}
+	return data, e.at, err
+}
+
+// Put caches data under a key.
+func (c cacheDir) Put(key string, data []byte) error {
+	if c.dir == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if err := c.writeObject(hash, data); err != nil {
+		return err
+	}
+	if err := c.appendIndex(key, hash); err != nil {
+		return err
+	}
+	if c.maxEntries > 0 || c.maxSize > 0 {
+		_, err := c.evict(false)
+		return err
+	}
+	return nil
+}
+
+// appendIndex appends index line for key, compacting index if it grows over
+// cacheIndexMaxSize.
+func (c cacheDir) appendIndex(key, hash string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	name := filepath.Join(c.dir, "index")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%d %s %s\n", time.Now().Unix(), hash, key); err != nil {
+		return err
+	}
+	if st, err := f.Stat(); err == nil && st.Size() > cacheIndexMaxSize {
+		return c.compact()
+	}
+	return f.Close()
+}
+
+// lock takes exclusive lock of index, and returns function releasing it.
+// Index itself can't be locked, as it is replaced when rewritten, so separate
+// "index.lock" file is.
+func (c cacheDir) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(c.dir, "index.lock"), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// cacheStatsMaxSize is the size after which stats file is compacted, leaving
+// only totals.
+const cacheStatsMaxSize = 64 << 10
+
+// recordLookup appends outcome of lookup to "stats" file: "hit HOST" or "miss
+// HOST" line, where HOST is provider host from cache key. Compacted file holds
+// totals, with counts following the host. Failures are ignored, as cache
+// directory may be read-only.
+func (c cacheDir) recordLookup(key string, hit bool) {
+	event := "miss"
+	if hit {
+		event = "hit"
+	}
+	name := filepath.Join(c.dir, "stats")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if os.IsNotExist(err) && os.MkdirAll(c.dir, 0777) == nil {
+		f, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", event, cacheKeyHost(key)); err != nil {
+		return
+	}
+	if st, err := f.Stat(); err == nil && st.Size() > cacheStatsMaxSize {
+		if stats, err := c.readStats(); err == nil {
+			var buf bytes.Buffer
+			for host, n := range stats {
+				fmt.Fprintf(&buf, "hit %s %d\nmiss %s %d\n", host, n[0], host, n[1])
+			}
+			_ = writeFileAtomic(name, buf.Bytes())
+		}
+	}
+}
+
+// readStats returns numbers of cache hits and misses by provider host.
+func (c cacheDir) readStats() (map[string][2]int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, "stats"))
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string][2]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || len(fields) > 3 {
+			continue
+		}
+		n := int64(1)
+		if len(fields) == 3 {
+			if n, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				continue // partially written line
+			}
+		}
+		v := stats[fields[1]]
+		switch fields[0] {
+		case "hit":
+			v[0] += n
+		case "miss":
+			v[1] += n
+		default:
+			continue
+		}
+		stats[fields[1]] = v
+	}
+	return stats, scanner.Err()
+}
+
+// cacheKeyHost returns provider host of cache key, see Provider.cacheKey.
+func cacheKeyHost(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[:i]
+	}
+	return "github.com"
+}
+
+func (c cacheDir) objectName(hash string) string {
+	return filepath.Join(c.dir, "objects", hash[:2], hash)
+}
+
+// readObject returns object content, verifying it matches its hash. Corrupted
+// objects are removed.
+func (c cacheDir) readObject(hash string) ([]byte, error) {
+	name := c.objectName(hash)
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		_ = os.Remove(name)
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (c cacheDir) writeObject(hash string, data []byte) error {
+	name := c.objectName(hash)
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	return writeFileAtomic(name, data)
+}
+
+// readIndex returns the latest index entry of each key.
+func (c cacheDir) readIndex() (map[string]cacheEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, "index"))
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]cacheEntry)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 || len(fields[1]) != sha256.Size*2 {
+			continue // partially written line
+		}
+		sec, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		index[fields[2]] = cacheEntry{hash: fields[1], at: time.Unix(sec, 0)}
+	}
+	return index, scanner.Err()
+}
+
+// compact rewrites index, leaving only the latest entry of each key. It must
+// be called with index locked.
+func (c cacheDir) compact() error {
+	index, err := c.readIndex()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for key, e := range index {
+		fmt.Fprintf(&buf, "%d %s %s\n", e.at.Unix(), e.hash, key)
+	}
+	return writeFileAtomic(filepath.Join(c.dir, "index"), buf.Bytes())
+}
+
+// CacheStats describes on-disk cache, see Resolver.CacheStats.
+type CacheStats struct {
+	CacheUsage        // of all entries, responses shared by entries are counted once
+	Expired    int    // entries older than cache TTL
+	Oldest     string // handle of entry fetched first, in "user@provider" form
+	OldestAt   time.Time
+	Newest     string // handle of entry fetched last
+	NewestAt   time.Time
+
+	// Providers holds usage by provider name. Providers no longer
+	// configured are named after their hosts.
+	Providers map[string]*CacheUsage
+}
+
+// CacheUsage describes entries of on-disk cache, and lookups of them. Hits
+// and misses are counted across runs, since cache was created.
+type CacheUsage struct {
+	Entries int
+	Size    int64 // total size of cached responses, in bytes
+	Hits    int64
+	Misses  int64 // lookups of entries missing or expired
+}
+
+// stats returns cache stats, with cache keys mapped to provider names and
+// handles with handle function.
+func (c cacheDir) stats(handle func(key string) (provider, user string)) (CacheStats, error) {
+	st := CacheStats{Providers: make(map[string]*CacheUsage)}
+	usage := func(provider string) *CacheUsage {
+		u, ok := st.Providers[provider]
+		if !ok {
+			u = new(CacheUsage)
+			st.Providers[provider] = u
+		}
+		return u
+	}
+	index, err := c.readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		return st, err
+	}
+	sizes := make(map[string]int64)  // by object hash
+	counted := make(map[string]bool) // objects counted, by provider and hash
+	for key, e := range index {
+		size, ok := sizes[e.hash]
+		if !ok {
+			fi, err := os.Stat(c.objectName(e.hash))
+			if err != nil {
+				continue // removed, entry is unusable
+			}
+			size = fi.Size()
+			sizes[e.hash] = size
+			st.Size += size
+		}
+		provider, user := handle(key)
+		u := usage(provider)
+		u.Entries++
+		if !counted[provider+" "+e.hash] {
+			counted[provider+" "+e.hash] = true
+			u.Size += size
+		}
+		st.Entries++
+		if e.at.Add(c.ttl(key)).Before(time.Now()) {
+			st.Expired++
+		}
+		// ties are broken by handle, for output to be stable
+		h := user + "@" + provider
+		if st.Oldest == "" || e.at.Before(st.OldestAt) || (e.at.Equal(st.OldestAt) && h < st.Oldest) {
+			st.Oldest, st.OldestAt = h, e.at
+		}
+		if st.Newest == "" || e.at.After(st.NewestAt) || (e.at.Equal(st.NewestAt) && h < st.Newest) {
+			st.Newest, st.NewestAt = h, e.at
+		}
+	}
+	lookups, err := c.readStats()
+	if err != nil && !os.IsNotExist(err) {
+		return st, err
+	}
+	for host, n := range lookups {
+		provider, _ := handle(host + "/")
+		u := usage(provider)
+		u.Hits += n[0]
+		u.Misses += n[1]
+		st.Hits += n[0]
+		st.Misses += n[1]
+	}
+	return st, nil
+}
+
+// CachePruneStats describes results of cache pruning, see Resolver.PruneCache.
+type CachePruneStats struct {
+	Entries     int   // entries removed
+	Objects     int   // objects removed
+	Bytes       int64 // total size of objects removed
+	EntriesLeft int   // entries kept
+	BytesLeft   int64 // total size of objects kept
+}
+
+// evict removes entries least recently used, until cache fits its limits,
+// and objects no entry refers to. If stale is set, entries older than cache
+// TTL are removed too. Index is only rewritten if any entry is removed, and
+// is locked meanwhile.
+func (c cacheDir) evict(stale bool) (CachePruneStats, error) {
+	var stats CachePruneStats
+	if _, err := os.Stat(filepath.Join(c.dir, "index")); os.IsNotExist(err) {
+		return stats, nil
+	}
+	unlock, err := c.lock()
+	if err != nil {
+		return stats, err
+	}
+	defer unlock()
+	index, err := c.readIndex()
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return stats, err
+	}
+	type object struct {
+		size    int64
+		used    time.Time
+		refs    int
+		indexed bool // referenced by index before eviction
+	}
+	objects := make(map[string]*object)
+	err = filepath.Walk(filepath.Join(c.dir, "objects"), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode().IsRegular() && len(fi.Name()) == sha256.Size*2 {
+			objects[fi.Name()] = &object{size: fi.Size(), used: fi.ModTime()}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	var size int64
+	keys := make([]string, 0, len(index))
+	for key, e := range index {
+		o, ok := objects[e.hash]
+		if ok {
+			o.indexed = true
+		}
+		if !ok || (stale && e.at.Add(c.ttl(key)).Before(time.Now())) {
+			delete(index, key)
+			stats.Entries++
+			continue
+		}
+		if o.refs++; o.refs == 1 {
+			size += o.size
+		}
+		keys = append(keys, key)
+	}
+	// last use of entry is the later of fetch, and object read
+	lastUsed := func(key string) time.Time {
+		e := index[key]
+		if u := objects[e.hash].used; u.After(e.at) {
+			return u
+		}
+		return e.at
+	}
+	sort.Slice(keys, func(i, j int) bool { return lastUsed(keys[i]).Before(lastUsed(keys[j])) })
+	for _, key := range keys {
+		if (c.maxEntries <= 0 || len(index) <= c.maxEntries) && (c.maxSize <= 0 || size <= c.maxSize) {
+			break
+		}
+		o := objects[index[key].hash]
+		if o.refs--; o.refs == 0 {
+			size -= o.size
+		}
+		delete(index, key)
+		stats.Entries++
+	}
+	stats.EntriesLeft, stats.BytesLeft = len(index), size
+	if stats.Entries != 0 {
+		var buf bytes.Buffer
+		for key, e := range index {
+			fmt.Fprintf(&buf, "%d %s %s\n", e.at.Unix(), e.hash, key)
+		}
+		if err := writeFileAtomic(filepath.Join(c.dir, "index"), buf.Bytes()); err != nil {
+			return stats, err
+		}
+	}
+	for hash, o := range objects {
+		// recent objects not in index may belong to entries being
+		// written concurrently
+		if o.refs != 0 || (!o.indexed && time.Since(o.used) < time.Minute) {
+			continue
+		}
+		if err := os.Remove(c.objectName(hash)); err != nil && !os.IsNotExist(err) {
+			return stats, err
+		}
+		stats.Objects++
+		stats.Bytes += o.size
+	}
+	return stats, nil
+}
+
+// writeFileAtomic writes file by renaming a temporary file over it.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
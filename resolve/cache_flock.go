@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package resolve
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes exclusive advisory lock of f, waiting for it if it's held.
+// Lock is released when f is closed.
+func lockFile(f *os.File) error {
+	for {
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != syscall.EINTR {
+			return err
+		}
+	}
+}
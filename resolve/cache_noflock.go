@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package resolve
+
+import "os"
+
+// lockFile does nothing, as file locks are not supported on this platform,
+// see cacheDir.
+func lockFile(f *os.File) error { return nil }
@@ -0,0 +1,103 @@
+package resolve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCacheConcurrentRewrite checks that index lines appended while index is
+// rewritten by eviction are not lost.
+func TestCacheConcurrentRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "age-github-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := cacheDir{dir: dir, ttl: func(key string) time.Duration {
+		if strings.HasPrefix(key, "stale/") {
+			return 0
+		}
+		return time.Hour
+	}}
+	const writers, keys = 4, 50
+	done := make(chan struct{})
+	var evictions sync.WaitGroup
+	evictions.Add(1)
+	go func() { // keeps adding stale entries, and evicting them
+		defer evictions.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := c.Put(fmt.Sprintf("stale/%d", i), []byte("stale")); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := c.evict(true); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				if err := c.Put(fmt.Sprintf("w%d/%d", w, i), []byte(fmt.Sprint(w, i))); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	evictions.Wait()
+	index, err := c.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keys; i++ {
+			if _, ok := index[fmt.Sprintf("w%d/%d", w, i)]; !ok {
+				t.Errorf("entry w%d/%d is lost", w, i)
+			}
+		}
+	}
+}
+
+func TestKeyTTL(t *testing.T) {
+	r, err := New(Config{
+		CacheTTL:        time.Hour,
+		DefaultProvider: "github",
+		Providers: map[string]*Provider{
+			"github": {Name: "github", Type: ProviderGithub, Host: "github.com"},
+			"a":      {Name: "a", Type: ProviderGithub, Host: "ghe.corp", CacheTTL: 10 * time.Minute},
+			"b":      {Name: "b", Type: ProviderGithub, Host: "ghe.corp", CacheTTL: 5 * time.Minute},
+			"ldap":   {Name: "ldap", Type: ProviderGithub, Host: "ldap.corp", CacheTTL: -1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]time.Duration{
+		"alice":            time.Hour,
+		"ghe.corp/alice":   5 * time.Minute,
+		"ldap.corp/alice":  -1,
+		"gone.corp/alice":  time.Hour,
+		"github.com/alice": time.Hour,
+	} {
+		if got := r.keyTTL(key); got != want {
+			t.Errorf("%s: got %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors of resolving a single user, they are wrapped into *ResolveError, so
+// they should be checked with errors.Is.
+var (
+	ErrUserNotFound       = errors.New("user does not exist")
+	ErrUserSuspended      = errors.New("user is suspended")
+	ErrNoKeys             = errors.New("user has no ssh keys")
+	ErrUnsupportedKeyType = errors.New("user has no ssh keys of types supported by age")
+	ErrInvalidHandle      = errors.New("not a valid user name")
+	ErrUnknownProvider    = errors.New("unknown provider")
+	ErrRateLimited        = errors.New("API rate limit exceeded")
+	ErrUserDenied         = errors.New("user is on the deny list")
+	ErrKeysDenied         = errors.New("all user keys are on the deny list")
+	ErrKeyTypeNotAllowed  = errors.New("user has no keys of allowed types")
+	ErrPolicyViolation    = errors.New("user has no keys allowed by organization policy")
+	ErrNotOrgMember       = errors.New("user is not a member of organization")
+	ErrNo2FA              = errors.New("user has two-factor authentication disabled")
+	ErrPinExpired         = errors.New("pinned keys need re-confirmation")
+	ErrNoGPGKeys          = errors.New("user has no gpg keys")
+	ErrRSAKey             = errors.New("user resolves to ssh-rsa key")
+)
+
+// HTTPError is returned when provider responds with unexpected status code.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+
+	// RateLimited is set if response tells that rate limit is exceeded,
+	// such errors match ErrRateLimited.
+	RateLimited bool
+}
+
+func (e *HTTPError) Error() string        { return fmt.Sprintf("unexpected response code %q", e.Status) }
+func (e *HTTPError) Is(target error) bool { return target == ErrRateLimited && e.RateLimited }
+
+// statusError returns *HTTPError describing unexpected response status.
+// GitHub reports exceeded rate limits with 403 status and no requests
+// remaining, GitLab with 429 status.
+func statusError(resp *http.Response) error {
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RateLimited: resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0",
+	}
+}
+
+// KeyTypeError is returned when user has no keys of types allowed by
+// Config.KeyTypes. It matches ErrKeyTypeNotAllowed.
+type KeyTypeError struct {
+	Allowed []string // allowed key types
+	Keys    []string // keys user has, all of other types
+}
+
+func (e *KeyTypeError) Error() string {
+	var keys []string
+	for _, k := range e.Keys {
+		keys = append(keys, strings.SplitN(k, " ", 2)[0]+" "+Fingerprint(k))
+	}
+	return fmt.Sprintf("no keys of allowed types (%s), only %s", strings.Join(e.Allowed, ", "), strings.Join(keys, ", "))
+}
+
+func (e *KeyTypeError) Is(target error) bool { return target == ErrKeyTypeNotAllowed }
+
+// NetworkError wraps errors of reaching provider over network.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string { return "network failure: " + e.Err.Error() }
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// ResolveError describes failure to resolve keys of a single user.
+type ResolveError struct {
+	Provider *Provider
+	User     string
+	Err      error
+}
+
+func (e *ResolveError) Unwrap() error { return e.Err }
+
+func (e *ResolveError) Error() string {
+	who := fmt.Sprintf("%s user %q", e.Provider.Name, e.User)
+	switch {
+	case errors.Is(e.Err, ErrInvalidHandle):
+		return fmt.Sprintf("%q is not a valid %s user name", e.User, e.Provider.Name)
+	case errors.Is(e.Err, ErrUserNotFound):
+		if e.Provider.LoadToken() == "" && (e.Provider.Type == ProviderGithub || e.Provider.Type == ProviderGitlab) {
+			return who + " does not exist (or is suspended), check the handle spelling"
+		}
+		return who + " does not exist, check the handle spelling"
+	case errors.Is(e.Err, ErrUserSuspended):
+		return who + " is suspended"
+	case errors.Is(e.Err, ErrNoKeys):
+		return who + " has no ssh keys published"
+	case errors.Is(e.Err, ErrNoGPGKeys):
+		return who + " has no gpg keys published"
+	case errors.Is(e.Err, ErrUserDenied):
+		return who + " is on the deny list, see deny setting"
+	case errors.Is(e.Err, ErrKeysDenied):
+		return "all keys of " + who + " are on the deny list, see deny setting"
+	case errors.Is(e.Err, ErrNotOrgMember):
+		return who + strings.TrimPrefix(e.Err.Error(), "user") + ", see require_org setting"
+	case errors.Is(e.Err, ErrNo2FA):
+		return who + " has two-factor authentication disabled, see require_2fa setting"
+	case errors.Is(e.Err, ErrPolicyViolation):
+		return who + " has " + e.Err.Error()
+	case errors.Is(e.Err, ErrKeyTypeNotAllowed):
+		return who + " has " + e.Err.Error() + ", see key_types and require_key_type settings"
+	case errors.Is(e.Err, ErrRSAKey):
+		return who + strings.TrimPrefix(e.Err.Error(), "user") + ", see rsa_keys setting"
+	case errors.Is(e.Err, ErrUnsupportedKeyType):
+		return who + " has no ssh keys of types supported by age (ssh-ed25519, ssh-rsa)"
+	}
+	var netErr *NetworkError
+	if errors.As(e.Err, &netErr) {
+		return fmt.Sprintf("fetching keys for %s from %s: %v", who, e.Provider.Host, e.Err)
+	}
+	return fmt.Sprintf("fetching keys for %s: %v", who, e.Err)
+}
+
+// confirmUser checks user status over provider API, it returns
+// ErrUserNotFound, ErrUserSuspended, or nil if user exists and is active.
+// Provider must have a token configured.
+func (r *Resolver) confirmUser(ctx context.Context, p *Provider, username string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	if p.Type == ProviderGitlab {
+		var users []struct {
+			State string `json:"state"`
+		}
+		if _, err := r.APIGet(ctx, p, p.APIURL("/users?username="+url.QueryEscape(username)), &users); err != nil {
+			return err
+		}
+		switch {
+		case len(users) == 0:
+			return ErrUserNotFound
+		case users[0].State == "blocked", users[0].State == "banned":
+			return ErrUserSuspended
+		}
+		return nil
+	}
+	var user struct {
+		SuspendedAt *string `json:"suspended_at"`
+	}
+	if _, err := r.APIGet(ctx, p, p.APIURL("/users/"+url.PathEscape(username)), &user); err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if user.SuspendedAt != nil {
+		return ErrUserSuspended
+	}
+	return nil
+}
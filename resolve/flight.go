@@ -0,0 +1,45 @@
+package resolve
+
+import "sync"
+
+// flightGroup collapses concurrent fetches of the same key into one, so that
+// many goroutines resolving the same user at once, i.e. daemon serving many
+// processes, make a single request to provider. Zero value is ready to use.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+// flight is a fetch in progress, its results are set before done is closed.
+type flight struct {
+	done chan struct{}
+	res  fetchResult
+	err  error
+}
+
+// do calls fn and returns its results, unless there's already a call with the
+// same key in progress, in which case it waits for that call and returns its
+// results.
+func (g *flightGroup) do(key string, fn func() (fetchResult, error)) (fetchResult, error) {
+	g.mu.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.res, f.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	f.res, f.err = fn()
+	return f.res, f.err
+}
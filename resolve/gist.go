@@ -0,0 +1,72 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// GitHub users may publish native age recipients, one per line, as a public
+// gist with one of gistRecipientsFiles files. If Config.GistRecipients is set,
+// the most recently updated such gist is used like age.txt of profile
+// repository, see profileRecipients, which takes precedence when enabled too.
+var gistRecipientsFiles = []string{"age.pub", "age-recipients.txt"}
+
+// gistRecipients fetches native age recipients user published as a gist,
+// returning them as newline-separated list. It returns no recipients for
+// users of non-GitHub providers, or if user has no such gist.
+func (r *Resolver) gistRecipients(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	if p.Type != ProviderGithub {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	// gists are listed most recently updated first
+	for next := p.APIURL("/users/"+url.PathEscape(username)+"/gists") + "?per_page=100"; next != ""; {
+		var gists []struct {
+			Files map[string]struct {
+				RawURL string `json:"raw_url"`
+			} `json:"files"`
+		}
+		var err error
+		if next, err = r.APIGet(ctx, p, next, &gists); err != nil {
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, g := range gists {
+			for _, name := range gistRecipientsFiles {
+				f, ok := g.Files[name]
+				if !ok {
+					continue
+				}
+				data, err := r.fetchMirrorKeys(ctx, f.RawURL)
+				if err != nil {
+					return nil, err
+				}
+				if r.cfg.VerifyFile != nil {
+					var bundle []byte
+					if sig, ok := g.Files[name+sigstoreBundleSuffix]; ok {
+						if bundle, err = r.fetchMirrorKeys(ctx, sig.RawURL); err != nil {
+							return nil, fmt.Errorf("fetching %s signature: %w", name, err)
+						}
+					}
+					if err := r.verifyFile(ctx, p, username, name, data, bundle); err != nil {
+						return nil, err
+					}
+				}
+				var buf bytes.Buffer
+				for _, line := range ageRecipientLines(string(data)) {
+					buf.WriteString(line + "\n")
+				}
+				return buf.Bytes(), nil
+			}
+		}
+	}
+	return nil, nil
+}
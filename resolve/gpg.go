@@ -0,0 +1,62 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// FetchGPGKeys fetches ASCII-armored PGP public keys published by a single
+// user identified by handle, as served at https://HOST/user.gpg, for users
+// who publish no ssh keys usable with age. Such keys are not cached.
+func (r *Resolver) FetchGPGKeys(ctx context.Context, handle string) ([]byte, error) {
+	username, p, err := r.LookupProvider(handle)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %q: %w", handle, err)
+	}
+	data, err := r.fetchGPGKeys(ctx, username, p)
+	if err != nil {
+		return nil, &ResolveError{Provider: p, User: username, Err: err}
+	}
+	return data, nil
+}
+
+func (r *Resolver) fetchGPGKeys(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	if err := r.throttle(ctx, p, rateLimitCore); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	u := "https://" + p.Host + "/" + url.PathEscape(username) + ".gpg"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrUserNotFound
+	default:
+		return nil, statusError(resp)
+	}
+	data, truncated, err := readLimited(resp.Body, r.cfg.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, fmt.Errorf("response is larger than max_response_size of %d bytes", r.cfg.MaxResponseSize)
+	}
+	// users without keys get a note instead of a key block
+	if !bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		return nil, ErrNoGPGKeys
+	}
+	return data, nil
+}
@@ -0,0 +1,257 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphqlBatchSize is the maximum number of users queried in a single
+// GraphQL request.
+const graphqlBatchSize = 50
+
+// Prefetch fetches keys of multiple users in a few GraphQL API requests
+// instead of one request per user, and stores them in resolver caches, so
+// that subsequent Resolve calls don't hit network. Only users of github-type
+// providers with tokens configured, and without mirrors, are fetched this
+// way. Group handles, and users with keys pinned in KeysDir, are skipped.
+// It's best effort: users not fetched for any reason, including users
+// without keys, are later fetched individually. With context made by
+// WithoutCache it does nothing, as calls with such context don't use caches.
+func (r *Resolver) Prefetch(ctx context.Context, handles []string) {
+	if bypassCache(ctx) {
+		return
+	}
+	ctx, span := r.startSpan(ctx, "prefetch", "age_github.handles", strconv.Itoa(len(handles)))
+	defer span.End(nil)
+	byProvider := make(map[*Provider][]string)
+	seen := make(map[string]struct{}, len(handles))
+	for _, h := range handles {
+		h = r.ExpandAlias(h)
+		if IsGroupHandle(h) {
+			continue
+		}
+		username, p, err := r.LookupProvider(h)
+		if err != nil || p.Type != ProviderGithub || len(p.Mirrors) != 0 || p.LoadToken() == "" || !p.validHandle(username) {
+			continue
+		}
+		if _, _, err := r.LocalKeysFile(username, p); !os.IsNotExist(err) {
+			continue
+		}
+		if r.cacheTTL(p) <= 0 { // nothing to store prefetched keys in
+			continue
+		}
+		key := p.cacheKey(username)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if _, _, err := r.cached(ctx, key, r.cacheTTL(p)); err == nil {
+			continue
+		}
+		byProvider[p] = append(byProvider[p], username)
+	}
+	for p, users := range byProvider {
+		if len(users) < 2 {
+			continue
+		}
+		for len(users) > 0 {
+			n := graphqlBatchSize
+			if n > len(users) {
+				n = len(users)
+			}
+			keys, err := r.graphqlKeys(ctx, p, users[:n])
+			users = users[n:]
+			if err != nil {
+				continue
+			}
+			for username, list := range keys {
+				if len(list) == 0 {
+					continue // left for individual fetch to report
+				}
+				r.store(ctx, p.cacheKey(username), []byte(strings.Join(list, "\n")+"\n"))
+			}
+		}
+	}
+}
+
+// graphqlKeys queries GraphQL API for public keys of users, returning map
+// from user name to keys. Users that don't exist, or have more keys than a
+// single page holds, are omitted from result.
+func (r *Resolver) graphqlKeys(ctx context.Context, p *Provider, users []string) (_ map[string][]string, err error) {
+	ctx, tm := r.cfg.Timings.begin(ctx, fmt.Sprintf("%d users@%s", len(users), p.Name))
+	defer func() { r.cfg.Timings.end(tm, err) }()
+	tm.setSource("graphql")
+	if err := r.throttle(ctx, p, rateLimitGraphQL); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	var params, fields []string
+	var profile string // query of native age recipients in profile repository
+	if r.cfg.ProfileRecipients {
+		profile = ` repository(name: $u%d) {` +
+			` recipients: object(expression: "HEAD:` + profileRecipientsFile + `") { ... on Blob { text } }` +
+			` readme: object(expression: "HEAD:` + profileReadme + `") { ... on Blob { text } }`
+		if r.cfg.VerifyFile != nil {
+			profile += ` recipientsBundle: object(expression: "HEAD:` + profileRecipientsFile + sigstoreBundleSuffix + `") { ... on Blob { text } }` +
+				` readmeBundle: object(expression: "HEAD:` + profileReadme + sigstoreBundleSuffix + `") { ... on Blob { text } }`
+		}
+		profile += ` }`
+	}
+	if r.cfg.GistRecipients {
+		profile += ` gists(first: 100, privacy: PUBLIC, orderBy: {field: UPDATED_AT, direction: DESC}) { nodes { files { name text } } }`
+	}
+	vars := make(map[string]string, len(users))
+	for i, u := range users {
+		params = append(params, fmt.Sprintf("$u%d: String!", i))
+		fields = append(fields, fmt.Sprintf("u%d: user(login: $u%d) { login publicKeys(first: 100) { pageInfo { hasNextPage } nodes { key } }", i, i))
+		if profile != "" {
+			fields = append(fields, strings.Replace(profile, "%d", strconv.Itoa(i), -1))
+		}
+		fields = append(fields, "}")
+		vars[fmt.Sprintf("u%d", i)] = u
+	}
+	body, err := json.Marshal(struct {
+		Query     string            `json:"query"`
+		Variables map[string]string `json:"variables"`
+	}{
+		Query:     "query(" + strings.Join(params, ", ") + ") { " + strings.Join(fields, " ") + " }",
+		Variables: vars,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.graphqlURL(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	p.authorize(req)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	r.noteRateLimit(p, resp.Header)
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	// errors are not checked: if some users don't exist, response holds
+	// errors for them, and data for the rest
+	var result struct {
+		Data map[string]*struct {
+			Login      string `json:"login"`
+			PublicKeys struct {
+				PageInfo struct {
+					HasNextPage bool `json:"hasNextPage"`
+				} `json:"pageInfo"`
+				Nodes []struct {
+					Key string `json:"key"`
+				} `json:"nodes"`
+			} `json:"publicKeys"`
+			Repository *struct {
+				Recipients       *graphqlBlob `json:"recipients"`
+				RecipientsBundle *graphqlBlob `json:"recipientsBundle"`
+				Readme           *graphqlBlob `json:"readme"`
+				ReadmeBundle     *graphqlBlob `json:"readmeBundle"`
+			} `json:"repository"`
+			Gists *struct {
+				Nodes []struct {
+					Files []struct {
+						Name string `json:"name"`
+						Text string `json:"text"`
+					} `json:"files"`
+				} `json:"nodes"`
+			} `json:"gists"`
+		} `json:"data"`
+	}
+	defer tm.parsed(time.Now())
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]string, len(result.Data))
+	for alias, u := range result.Data {
+		if u == nil {
+			continue
+		}
+		var i int
+		if _, err := fmt.Sscanf(alias, "u%d", &i); err != nil || i < 0 || i >= len(users) {
+			continue
+		}
+		var keys []string
+		var verifyErr error
+		switch repo := u.Repository; {
+		case repo == nil:
+		case repo.Recipients != nil:
+			keys = ageRecipientLines(repo.Recipients.Text)
+			verifyErr = r.verifyFile(ctx, p, users[i], profileRecipientsFile, []byte(repo.Recipients.Text), repo.RecipientsBundle.bytes())
+		case repo.Readme != nil:
+			if keys = readmeRecipients(repo.Readme.Text); len(keys) != 0 {
+				verifyErr = r.verifyFile(ctx, p, users[i], profileReadme, []byte(repo.Readme.Text), repo.ReadmeBundle.bytes())
+			}
+		}
+		if len(keys) == 0 && u.Gists != nil {
+		gists:
+			for _, g := range u.Gists.Nodes {
+				for _, name := range gistRecipientsFiles {
+					for _, f := range g.Files {
+						if f.Name != name {
+							continue
+						}
+						keys = ageRecipientLines(f.Text)
+						var bundle []byte
+						for _, sig := range g.Files {
+							if sig.Name == name+sigstoreBundleSuffix {
+								bundle = []byte(sig.Text)
+							}
+						}
+						verifyErr = r.verifyFile(ctx, p, users[i], name, []byte(f.Text), bundle)
+						break gists
+					}
+				}
+			}
+		}
+		if verifyErr != nil || u.PublicKeys.PageInfo.HasNextPage {
+			// left for individual fetch, which reports the error, or
+			// warns about keys over the limit
+			continue
+		}
+		for _, n := range u.PublicKeys.Nodes {
+			keys = append(keys, strings.TrimSpace(n.Key))
+		}
+		out[users[i]] = keys
+	}
+	return out, nil
+}
+
+// graphqlBlob is a file queried with object(expression: "HEAD:name").
+type graphqlBlob struct {
+	Text string `json:"text"`
+}
+
+// bytes returns file content, or nil if there's no file.
+func (b *graphqlBlob) bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return []byte(b.Text)
+}
+
+// graphqlURL returns url of the GraphQL API endpoint.
+func (p *Provider) graphqlURL() string {
+	if p.API != "" {
+		// GitHub Enterprise Server API is at /api/v3, GraphQL at /api/graphql
+		return strings.TrimSuffix(p.API, "/v3") + "/graphql"
+	}
+	if p.Host == "github.com" {
+		return "https://api.github.com/graphql"
+	}
+	return "https://" + p.Host + "/api/graphql"
+}
@@ -0,0 +1,218 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Group handles expand to multiple users:
+//
+//	org:NAME        public members of organization, or all members if token
+//	                used has access to them
+//	team:ORG/SLUG   members of the team SLUG in organization ORG; if ORG/ part
+//	                is omitted, organization from provider "org" setting, or
+//	                top-level one is used
+//
+// Group handles may have @provider suffix, only github-type providers
+// support groups.
+const (
+	groupOrg  = "org:"
+	groupTeam = "team:"
+)
+
+// IsGroupHandle reports whether handle is a group handle.
+func IsGroupHandle(handle string) bool {
+	return strings.HasPrefix(handle, groupOrg) || strings.HasPrefix(handle, groupTeam)
+}
+
+// Recipients returns keys for a handle, which may be an alias, a single user
+// handle, or a group handle expanding to multiple users.
+func (r *Resolver) Recipients(ctx context.Context, handle string) ([]Recipient, error) {
+	handle = r.ExpandAlias(handle)
+	handles := []string{handle}
+	if IsGroupHandle(handle) {
+		var err error
+		if handles, err = r.ExpandGroup(ctx, handle); err != nil {
+			return nil, fmt.Errorf("expanding group %q: %w", handle, err)
+		}
+		if len(handles) == 0 {
+			return nil, fmt.Errorf("group %q has no members", handle)
+		}
+		r.Prefetch(ctx, handles)
+	}
+	var out []Recipient
+	for _, h := range handles {
+		keys, err := r.Resolve(ctx, h)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, keys...)
+	}
+	return out, nil
+}
+
+// ExpandGroup returns handles of users belonging to a group, in
+// "user@provider" form. Members are cached for Config.GroupCacheTTL, as they
+// change more often than users keys, and never longer than keys of provider.
+func (r *Resolver) ExpandGroup(ctx context.Context, handle string) ([]string, error) {
+	group, p, err := r.LookupProvider(handle)
+	if err != nil {
+		return nil, err
+	}
+	if p.Type != ProviderGithub {
+		return nil, fmt.Errorf("groups are not supported by %s provider", p.Type)
+	}
+	var path, org, cacheKey string
+	switch {
+	case strings.HasPrefix(group, groupOrg):
+		org = strings.TrimPrefix(group, groupOrg)
+		if !p.validHandle(org) {
+			return nil, errors.New("not a valid organization name")
+		}
+		path = "/orgs/" + url.PathEscape(org) + "/members"
+		cacheKey = groupOrg + org
+	case strings.HasPrefix(group, groupTeam):
+		team := strings.TrimPrefix(group, groupTeam)
+		org = p.Org
+		if org == "" {
+			org = r.cfg.Org
+		}
+		if i := strings.IndexByte(team, '/'); i >= 0 {
+			org, team = team[:i], team[i+1:]
+		}
+		if org == "" {
+			return nil, errors.New("no organization given, and \"org\" setting is empty")
+		}
+		if !p.validHandle(org) || !teamSlugRe.MatchString(team) {
+			return nil, errors.New("not a valid organization name or team slug")
+		}
+		if p.LoadToken() == "" {
+			return nil, errors.New("team members can only be listed with a token")
+		}
+		path = "/orgs/" + url.PathEscape(org) + "/teams/" + url.PathEscape(team) + "/members"
+		cacheKey = groupTeam + org + ":" + team // cache keys are split at the last slash
+	}
+	// group members are cached as a list of logins, so that cache is
+	// valid after provider is renamed
+	cacheKey = strings.ToLower(p.cacheKey(cacheKey))
+	var logins []string
+	if data, _, err := r.cached(ctx, cacheKey, r.groupCacheTTL(p)); err == nil {
+		logins = strings.Fields(string(data))
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+		defer cancel()
+		for next := p.APIURL(path) + "?per_page=100"; next != ""; {
+			var members []struct {
+				Login string `json:"login"`
+			}
+			var err error
+			if next, err = r.APIGet(ctx, p, next, &members); err != nil {
+				return nil, err
+			}
+			for _, m := range members {
+				logins = append(logins, m.Login)
+			}
+		}
+		if r.groupCacheTTL(p) > 0 {
+			r.store(ctx, cacheKey, []byte(strings.Join(logins, "\n")+"\n"))
+		}
+	}
+	out := make([]string, len(logins))
+	for i, login := range logins {
+		out[i] = login + "@" + p.Name
+	}
+	if r.cfg.OrgPolicy {
+		if err := r.expandedPolicy(ctx, p, org, out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// APIGet fetches provider API url, authenticating with provider token, and
+// decodes its JSON response into v. It returns url of the next page, if
+// response is paginated.
+func (r *Resolver) APIGet(ctx context.Context, p *Provider, u string, v interface{}) (next string, err error) {
+	return r.apiGet(ctx, p, u, rateLimitCore, v)
+}
+
+// apiGet is APIGet of endpoint limited as rate limit resource.
+func (r *Resolver) apiGet(ctx context.Context, p *Provider, u, resource string, v interface{}) (next string, err error) {
+	if err := r.throttle(ctx, p, resource); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	p.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	r.noteRateLimit(p, resp.Header)
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return nextPageURL(resp.Header.Get("Link")), nil
+}
+
+// APIPost sends JSON-encoded body to provider API url, authenticating with
+// provider token, and decodes JSON response into v, if it's not nil.
+func (r *Resolver) APIPost(ctx context.Context, p *Provider, u string, body, v interface{}) error {
+	if err := r.throttle(ctx, p, rateLimitCore); err != nil {
+		return err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	p.authorize(req)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	r.noteRateLimit(p, resp.Header)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return statusError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// nextPageURL extracts rel="next" url from the Link header value.
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		fields := strings.Split(part, ";")
+		if len(fields) < 2 {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if strings.TrimSpace(f) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(fields[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+var teamSlugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
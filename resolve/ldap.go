@@ -0,0 +1,345 @@
+package resolve
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var ldapUIDRe = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+
+// LDAP provider connection security modes, see Provider.TLS.
+const (
+	LDAPS        = "ldaps"    // TLS from the start, port 636 by default
+	LDAPStartTLS = "starttls" // plain connection upgraded with StartTLS, port 389 by default
+	LDAPPlain    = "none"     // plain connection, port 389 by default
+)
+
+// fetchLDAPKeys fetches sshPublicKey attribute values of the entry with
+// user uid under p.BaseDN of LDAP directory. If p.BindDN is set, connection
+// is authenticated with simple bind, with p.Token as password, otherwise
+// search is anonymous.
+func (r *Resolver) fetchLDAPKeys(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	password := p.LoadToken()
+	if p.BindDN != "" && password == "" {
+		return nil, errors.New("bind DN is set, but provider has no token to use as password")
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	conn, err := dialLDAP(ctx, p, r.cfg.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if p.BindDN != "" {
+		if err := conn.call(berTLV(berApplication|berConstructed|ldapBindRequest,
+			berInt(berInteger, 3), berString(berOctets, p.BindDN), berString(berContext|0, password)),
+			ldapBindResponse, nil); err != nil {
+			return nil, fmt.Errorf("bind as %q: %w", p.BindDN, err)
+		}
+	}
+	var keys []byte
+	var entries int
+	err = conn.call(berTLV(berApplication|berConstructed|ldapSearchRequest,
+		berString(berOctets, p.BaseDN),
+		berInt(berEnumerated, 2), // wholeSubtree
+		berInt(berEnumerated, 0), // neverDerefAliases
+		berInt(berInteger, 2),    // size limit, to tell ambiguous uids
+		berInt(berInteger, 0),
+		[]byte{berBoolean, 1, 0}, // typesOnly
+		berTLV(berContext|berConstructed|3, // equalityMatch
+			berString(berOctets, "uid"), berString(berOctets, username)),
+		berTLV(berSequence, berString(berOctets, "sshPublicKey")),
+	), ldapSearchResultDone, func(tag byte, entry []byte) error {
+		if tag != berApplication|berConstructed|ldapSearchResultEntry {
+			return nil // search result references are not followed
+		}
+		if entries++; entries > 1 {
+			return fmt.Errorf("more than one entry has uid %q", username)
+		}
+		vals, err := ldapAttributeValues(entry, "sshPublicKey")
+		for _, v := range vals {
+			keys = append(keys, strings.TrimSpace(v)+"\n"...)
+		}
+		return err
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case entries == 0:
+		return nil, ErrUserNotFound
+	}
+	return keys, nil
+}
+
+// dialLDAP connects to LDAP server of the provider, securing connection as
+// set by p.TLS. Connection deadline is set from ctx, and messages larger than
+// limit are refused.
+func dialLDAP(ctx context.Context, p *Provider, limit int64) (*ldapConn, error) {
+	addr := p.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if p.TLS == LDAPS || p.TLS == "" {
+			addr = net.JoinHostPort(addr, "636")
+		} else {
+			addr = net.JoinHostPort(addr, "389")
+		}
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &NetworkError{err}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	if p.TLS == LDAPS || p.TLS == "" {
+		nc = tls.Client(nc, &tls.Config{ServerName: host})
+	}
+	conn := &ldapConn{Conn: nc, rd: bufio.NewReader(nc), limit: limit}
+	if p.TLS == LDAPStartTLS {
+		if err := conn.call(berTLV(berApplication|berConstructed|ldapExtendedRequest,
+			berString(berContext|0, "1.3.6.1.4.1.1466.20037")), ldapExtendedResponse, nil); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("StartTLS: %w", err)
+		}
+		conn.Conn = tls.Client(nc, &tls.Config{ServerName: host})
+		conn.rd = bufio.NewReader(conn.Conn)
+	}
+	return conn, nil
+}
+
+// LDAP protocol operations, application tag numbers.
+const (
+	ldapBindRequest       = 0
+	ldapBindResponse      = 1
+	ldapSearchRequest     = 3
+	ldapSearchResultEntry = 4
+	ldapSearchResultDone  = 5
+	ldapExtendedRequest   = 23
+	ldapExtendedResponse  = 24
+)
+
+type ldapConn struct {
+	net.Conn
+	rd     *bufio.Reader
+	limit  int64
+	lastID int
+}
+
+// call sends LDAP operation, and reads responses to it until the one with
+// done application tag, passing all others to fn. Result of the done response
+// is returned as *ldapResultError, if it's not a success.
+func (c *ldapConn) call(op []byte, done byte, fn func(tag byte, content []byte) error) error {
+	c.lastID++
+	if _, err := c.Write(berTLV(berSequence, berInt(berInteger, c.lastID), op)); err != nil {
+		return &NetworkError{err}
+	}
+	for {
+		tag, msg, err := berRead(c.rd, c.limit)
+		if err != nil {
+			return &NetworkError{err}
+		}
+		if tag != berSequence {
+			return errors.New("malformed LDAP message")
+		}
+		_, _, msg, err = berNext(msg) // message ID, operations are sequential
+		if err != nil {
+			return err
+		}
+		tag, content, _, err := berNext(msg)
+		if err != nil {
+			return err
+		}
+		if tag&^berConstructed != berApplication|done {
+			if fn == nil {
+				continue
+			}
+			if err := fn(tag, content); err != nil {
+				return err
+			}
+			continue
+		}
+		return ldapResult(content)
+	}
+}
+
+// ldapResultError is non-successful LDAP operation result.
+type ldapResultError struct {
+	code    int
+	message string
+}
+
+func (e *ldapResultError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.code)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.code, e.message)
+}
+
+// ldapResult returns error of LDAPResult, or nil if it's a success.
+func ldapResult(b []byte) error {
+	_, code, b, err := berNext(b)
+	if err != nil {
+		return err
+	}
+	_, _, b, err = berNext(b) // matched DN
+	if err != nil {
+		return err
+	}
+	_, msg, _, err := berNext(b)
+	if err != nil {
+		return err
+	}
+	if n := berIntValue(code); n != 0 {
+		return &ldapResultError{code: n, message: string(msg)}
+	}
+	return nil
+}
+
+// ldapAttributeValues returns values of attribute of SearchResultEntry.
+func ldapAttributeValues(entry []byte, name string) ([]string, error) {
+	_, _, rest, err := berNext(entry) // object name
+	if err != nil {
+		return nil, err
+	}
+	_, attrs, _, err := berNext(rest)
+	if err != nil {
+		return nil, err
+	}
+	for len(attrs) > 0 {
+		var attr []byte
+		if _, attr, attrs, err = berNext(attrs); err != nil {
+			return nil, err
+		}
+		_, typ, vals, err := berNext(attr)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(string(typ), name) {
+			continue
+		}
+		if _, vals, _, err = berNext(vals); err != nil {
+			return nil, err
+		}
+		var out []string
+		for len(vals) > 0 {
+			var v []byte
+			if _, v, vals, err = berNext(vals); err != nil {
+				return nil, err
+			}
+			out = append(out, string(v))
+		}
+		return out, nil
+	}
+	return nil, nil
+}
+
+// BER encoding subset LDAP needs.
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctets      = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berConstructed = 0x20
+	berApplication = 0x40
+	berContext     = 0x80
+)
+
+func berTLV(tag byte, elems ...[]byte) []byte {
+	var n int
+	for _, e := range elems {
+		n += len(e)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, e := range elems {
+		b = append(b, e...)
+	}
+	return b
+}
+
+func berString(tag byte, s string) []byte { return berTLV(tag, []byte(s)) }
+
+func berInt(tag byte, n int) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+func berIntValue(b []byte) int {
+	var n int
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+// berNext splits b into the first element tag and contents, and the rest.
+func berNext(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("malformed LDAP message")
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		l := n &^ 0x80
+		if l == 0 || l > 4 || len(b) < l {
+			return 0, nil, nil, errors.New("malformed LDAP message")
+		}
+		n = berIntValue(b[:l])
+		b = b[l:]
+	}
+	if len(b) < n {
+		return 0, nil, nil, errors.New("malformed LDAP message")
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// berRead reads a single element from r, refusing ones larger than limit.
+func berRead(r *bufio.Reader, limit int64) (tag byte, content []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(hdr[1])
+	if n&0x80 != 0 {
+		l := n &^ 0x80
+		if l == 0 || l > 4 {
+			return 0, nil, errors.New("malformed LDAP message")
+		}
+		lb := make([]byte, l)
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return 0, nil, err
+		}
+		n = berIntValue(lb)
+	}
+	if int64(n) > limit {
+		return 0, nil, fmt.Errorf("LDAP message is larger than max_response_size of %d bytes", limit)
+	}
+	content = make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], content, nil
+}
@@ -0,0 +1,114 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkMember returns ErrNotOrgMember if user of provider p is not a member
+// of organization required by Config.RequireOrg.
+func (r *Resolver) checkMember(ctx context.Context, p *Provider, username string) error {
+	org, op, err := r.LookupProvider(r.cfg.RequireOrg)
+	if err != nil {
+		return err
+	}
+	if op != p {
+		return fmt.Errorf("%w %s, which is on %s provider", ErrNotOrgMember, r.cfg.RequireOrg, op.Name)
+	}
+	key := strings.ToLower(org + "/" + p.cacheKey(username))
+	r.mu.Lock()
+	member, ok := r.members[key]
+	r.mu.Unlock()
+	if !ok {
+		if member, err = r.isMember(ctx, p, org, username); err != nil {
+			return fmt.Errorf("checking %s organization membership: %w", org, err)
+		}
+		r.mu.Lock()
+		if r.members == nil {
+			r.members = make(map[string]bool)
+		}
+		r.members[key] = member
+		r.mu.Unlock()
+	}
+	if !member {
+		return fmt.Errorf("%w %s", ErrNotOrgMember, org)
+	}
+	if !r.cfg.Require2FA {
+		return nil
+	}
+	disabled, err := r.twoFactorDisabled(ctx, p, org)
+	if err != nil {
+		return fmt.Errorf("listing %s organization members without two-factor authentication: %w", org, err)
+	}
+	if disabled[strings.ToLower(username)] {
+		return ErrNo2FA
+	}
+	return nil
+}
+
+// twoFactorDisabled returns lower-cased names of organization members who
+// have two-factor authentication disabled, fetching them once. Only
+// organization owners can list them.
+func (r *Resolver) twoFactorDisabled(ctx context.Context, p *Provider, org string) (map[string]bool, error) {
+	r.no2FAOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+		defer cancel()
+		disabled := make(map[string]bool)
+		for next := p.APIURL("/orgs/"+url.PathEscape(org)+"/members") + "?filter=2fa_disabled&per_page=100"; next != ""; {
+			var members []struct {
+				Login string `json:"login"`
+			}
+			var err error
+			if next, err = r.APIGet(ctx, p, next, &members); err != nil {
+				var httpErr *HTTPError
+				if errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusForbidden || httpErr.StatusCode == http.StatusUnprocessableEntity) {
+					err = fmt.Errorf("%w; token of organization owner is required", err)
+				}
+				r.no2FAErr = err
+				return
+			}
+			for _, m := range members {
+				disabled[strings.ToLower(m.Login)] = true
+			}
+		}
+		r.no2FA = disabled
+	})
+	return r.no2FA, r.no2FAErr
+}
+
+// isMember checks over provider API whether user is a member of
+// organization. Without a token, or with a token of non-member, only public
+// members are seen.
+func (r *Resolver) isMember(ctx context.Context, p *Provider, org, username string) (bool, error) {
+	if p.Type != ProviderGithub {
+		return false, fmt.Errorf("organizations are not supported by %s provider", p.Type)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	if err := r.throttle(ctx, p, rateLimitCore); err != nil {
+		return false, err
+	}
+	u := p.APIURL("/orgs/" + url.PathEscape(org) + "/members/" + url.PathEscape(username))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	p.authorize(req)
+	resp, err := r.client.Do(req) // non-members of org are redirected to public members check
+	if err != nil {
+		return false, &NetworkError{err}
+	}
+	resp.Body.Close()
+	r.noteRateLimit(p, resp.Header)
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, statusError(resp)
+}
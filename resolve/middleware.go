@@ -0,0 +1,11 @@
+package resolve
+
+import "context"
+
+// ResolveFunc resolves a single user handle, see Resolver.Resolve.
+type ResolveFunc func(ctx context.Context, handle string) ([]Recipient, error)
+
+// Middleware wraps resolution of single users, see Config.Middleware. It
+// returns function which may fail resolution before calling next, i.e. if it
+// wasn't approved, or alter what next returns, i.e. to filter keys.
+type Middleware func(next ResolveFunc) ResolveFunc
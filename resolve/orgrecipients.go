@@ -0,0 +1,28 @@
+package resolve
+
+import "context"
+
+// Organizations may publish an official, reviewed set of recipients, i.e. of
+// their security team, as "age-recipients.txt" file in their ".github"
+// repository, one ssh key or native age recipient per line. As organizations
+// have no keys of their own, plain handle of organization, like "@corp",
+// resolves to them, while "@org:corp" group handle still expands to members.
+// Such recipients are all used, regardless of key policy, and of max keys
+// setting.
+const orgRecipientsFile = "age-recipients.txt"
+
+// orgRecipientsMarker precedes recipients organization publishes in cache, so
+// that they're told apart from keys of users.
+var orgRecipientsMarker = []byte("# age-github: organization recipients\n")
+
+// orgRecipients fetches recipients organization published in its .github
+// repository. It returns nil data for users of non-GitHub providers, or if
+// there's no such file.
+func (r *Resolver) orgRecipients(ctx context.Context, name string, p *Provider) ([]byte, error) {
+	if p.Type != ProviderGithub {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	return r.verifiedRepoFile(ctx, p, name, policyRepo, orgRecipientsFile)
+}
@@ -0,0 +1,257 @@
+package resolve
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OS Login users are Google accounts and service accounts, named by emails.
+var osLoginUserRe = regexp.MustCompile(`^[^@\s/]+@[^@\s/]+\.[^@\s/]+$`)
+
+// gcpScope is OAuth scope of access tokens for OS Login API.
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// fetchOSLoginKeys fetches ssh public keys of Google account or service
+// account from its OS Login profile, skipping expired ones. Provider token,
+// if set, is used as OAuth access token, otherwise token is obtained with
+// Application Default Credentials, see gcpAccessToken.
+func (r *Resolver) fetchOSLoginKeys(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	token := p.LoadToken()
+	if token == "" {
+		var err error
+		if token, err = r.gcpAccessToken(ctx); err != nil {
+			return nil, fmt.Errorf("Google credentials: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.APIURL("/users/"+url.PathEscape(username)+"/loginProfile"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "github.com/artyom/age-github")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrUserNotFound
+	default:
+		return nil, statusError(resp)
+	}
+	data, truncated, err := readLimited(resp.Body, r.cfg.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, fmt.Errorf("response is larger than max_response_size of %d bytes", r.cfg.MaxResponseSize)
+	}
+	var profile struct {
+		Keys map[string]struct {
+			Key       string `json:"key"`
+			ExpiresUs string `json:"expirationTimeUsec"`
+		} `json:"sshPublicKeys"`
+	}
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, err
+	}
+	// keys are keyed by fingerprints, sort them so that key policy picks
+	// the same key every time
+	fingerprints := make([]string, 0, len(profile.Keys))
+	for fp := range profile.Keys {
+		fingerprints = append(fingerprints, fp)
+	}
+	sort.Strings(fingerprints)
+	var buf []byte
+	for _, fp := range fingerprints {
+		k := profile.Keys[fp]
+		if us, err := strconv.ParseInt(k.ExpiresUs, 10, 64); err == nil && us != 0 && time.Now().After(time.Unix(0, us*1000)) {
+			continue
+		}
+		// OS Login keys often carry user email as comment
+		if fields := strings.Fields(k.Key); len(fields) >= 2 {
+			buf = append(buf, fields[0]+" "+fields[1]+"\n"...)
+		}
+	}
+	return buf, nil
+}
+
+// gcpAccessToken returns OAuth access token from Application Default
+// Credentials, obtained once, or again when it's about to expire: from
+// credentials file named by GOOGLE_APPLICATION_CREDENTIALS variable or the
+// one "gcloud auth application-default login" writes, holding either service
+// account key or user refresh token, and otherwise from GCE metadata server.
+func (r *Resolver) gcpAccessToken(ctx context.Context) (string, error) {
+	r.gcpMu.Lock()
+	defer r.gcpMu.Unlock()
+	if r.gcpToken != "" && time.Until(r.gcpExpiry) > 5*time.Minute {
+		return r.gcpToken, nil
+	}
+	name := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if name == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			name = filepath.Join(dir, "gcloud", "application_default_credentials.json")
+		}
+	}
+	var token gcpToken
+	data, err := ioutil.ReadFile(name)
+	switch {
+	case err == nil:
+		token, err = r.gcpFileToken(ctx, data)
+	case os.IsNotExist(err) && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "":
+		token, err = gcpMetadataToken(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	r.gcpToken, r.gcpExpiry = token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn)*time.Second)
+	return r.gcpToken, nil
+}
+
+// gcpToken is OAuth token endpoint response.
+type gcpToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"` // seconds
+}
+
+// gcpFileToken gets access token with credentials file contents: service
+// account key, or authorized user refresh token.
+func (r *Resolver) gcpFileToken(ctx context.Context, data []byte) (gcpToken, error) {
+	var creds struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return gcpToken{}, fmt.Errorf("credentials file: %w", err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	form := make(url.Values)
+	switch creds.Type {
+	case "service_account":
+		assertion, err := gcpJWT(creds.ClientEmail, creds.TokenURI, creds.PrivateKey, time.Now())
+		if err != nil {
+			return gcpToken{}, err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("refresh_token", creds.RefreshToken)
+	default:
+		return gcpToken{}, fmt.Errorf("unsupported credentials type %q", creds.Type)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return gcpToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return gcpToken{}, &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return gcpToken{}, fmt.Errorf("token endpoint: %w", statusError(resp))
+	}
+	var token gcpToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return gcpToken{}, err
+	}
+	return token, nil
+}
+
+// gcpJWT returns JWT asserting service account identity, signed with its
+// private key, to exchange for access token at token endpoint aud.
+func gcpJWT(email, aud, privateKey string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", errors.New("service account private key is not PEM-encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("service account private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("service account private key is not an RSA key")
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   email,
+		"scope": gcpScope,
+		"aud":   aud,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// gcpMetadataToken gets access token of GCE instance, or GKE workload,
+// service account from metadata server.
+func gcpMetadataToken(ctx context.Context) (gcpToken, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return gcpToken{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	// metadata server is link-local, and must not be proxied
+	client := &http.Client{Transport: &http.Transport{}, Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return gcpToken{}, errors.New("none found in credentials file or metadata server")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return gcpToken{}, fmt.Errorf("metadata server: %w", statusError(resp))
+	}
+	var token gcpToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return gcpToken{}, err
+	}
+	return token, nil
+}
@@ -0,0 +1,233 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Organizations may publish policy on keys of their members as
+// "age-policy.yml" file in their ".github" repository:
+//
+//	key_types: [ssh-ed25519, ssh-rsa]  # allowed key types
+//	min_rsa_bits: 3072                 # minimum size of ssh-rsa keys
+//	deny: ["SHA256:..."]               # fingerprints of banned keys
+//
+// If Config.OrgPolicy is set, policies are fetched for organizations of
+// expanded group handles, and enforced for all resolutions of their members.
+const (
+	policyRepo = ".github"
+	policyFile = "age-policy.yml"
+)
+
+// orgPolicy is a policy organization sets on keys of its members.
+type orgPolicy struct {
+	org string // organization name, "org@provider"
+
+	KeyTypes       []string `yaml:"key_types"`
+	MinRSABits     int      `yaml:"min_rsa_bits"`
+	Deny           []string `yaml:"deny"`
+	RequirePinning bool     `yaml:"require_pinning"`
+}
+
+// PolicyError is returned when none of user keys is allowed by policy of
+// organization user is a member of. It matches ErrPolicyViolation.
+type PolicyError struct {
+	Org      string   // organization, in "org@provider" form
+	Rejected []string // rejected keys, with reasons
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("no keys allowed by %s organization policy, rejected: %s", e.Org, strings.Join(e.Rejected, "; "))
+}
+
+func (e *PolicyError) Is(target error) bool { return target == ErrPolicyViolation }
+
+// filter returns keys allowed by policy, or *PolicyError if there are none.
+func (pol *orgPolicy) filter(keys []string) ([]string, error) {
+	out := keys[:0:0]
+	var rejected []string
+	for _, k := range keys {
+		if reason := pol.check(k); reason != "" {
+			rejected = append(rejected, strings.SplitN(k, " ", 2)[0]+" "+Fingerprint(k)+" ("+reason+")")
+			continue
+		}
+		out = append(out, k)
+	}
+	if len(out) == 0 && len(keys) != 0 {
+		return nil, &PolicyError{Org: pol.org, Rejected: rejected}
+	}
+	return out, nil
+}
+
+// check returns why key is not allowed by policy, or an empty string if it
+// is allowed.
+func (pol *orgPolicy) check(key string) string {
+	if len(pol.KeyTypes) != 0 && len(allowedKeys([]string{key}, pol.KeyTypes)) == 0 {
+		return "type is not allowed"
+	}
+	fp := Fingerprint(key)
+	for _, d := range pol.Deny {
+		if d == fp {
+			return "key is banned"
+		}
+	}
+	if pol.MinRSABits > 0 && strings.HasPrefix(key, "ssh-rsa ") {
+		if bits := rsaBits(key); bits < pol.MinRSABits {
+			return fmt.Sprintf("%d bits, less than %d", bits, pol.MinRSABits)
+		}
+	}
+	return ""
+}
+
+// rsaBits returns size of ssh-rsa key modulus in bits, or 0 if key can't be
+// parsed.
+func rsaBits(key string) int {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return 0
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return 0
+	}
+	// wire format is a sequence of length-prefixed strings: key type,
+	// public exponent, and modulus
+	var parts [][]byte
+	for len(blob) >= 4 && len(parts) < 3 {
+		n := binary.BigEndian.Uint32(blob)
+		if uint64(len(blob)-4) < uint64(n) {
+			return 0
+		}
+		parts = append(parts, blob[4:4+n])
+		blob = blob[4+n:]
+	}
+	if len(parts) != 3 || string(parts[0]) != "ssh-rsa" {
+		return 0
+	}
+	return new(big.Int).SetBytes(parts[2]).BitLen()
+}
+
+// expandedPolicy fetches policy of organization which group of members was
+// expanded from, and records it to be enforced on these members.
+func (r *Resolver) expandedPolicy(ctx context.Context, p *Provider, org string, members []string) error {
+	pol, err := r.orgPolicy(ctx, p, org)
+	if err != nil {
+		return fmt.Errorf("fetching %s organization policy: %w", org, err)
+	}
+	if pol == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.memberPolicies == nil {
+		r.memberPolicies = make(map[string][]*orgPolicy)
+	}
+	for _, m := range members {
+		username, _, err := r.LookupProvider(m)
+		if err != nil {
+			continue
+		}
+		key := strings.ToLower(p.cacheKey(username))
+		if !hasPolicy(r.memberPolicies[key], pol) {
+			r.memberPolicies[key] = append(r.memberPolicies[key], pol)
+		}
+	}
+	return nil
+}
+
+func hasPolicy(list []*orgPolicy, pol *orgPolicy) bool {
+	for _, x := range list {
+		if x == pol {
+			return true
+		}
+	}
+	return false
+}
+
+// policiesOf returns policies of organizations user is known to be a member
+// of.
+func (r *Resolver) policiesOf(username string, p *Provider) []*orgPolicy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.memberPolicies[strings.ToLower(p.cacheKey(username))]
+}
+
+// orgPolicy returns policy of organization, fetching it once. It returns nil
+// policy if organization has none.
+func (r *Resolver) orgPolicy(ctx context.Context, p *Provider, org string) (*orgPolicy, error) {
+	name := org + "@" + p.Name
+	r.mu.Lock()
+	pol, ok := r.policies[name]
+	r.mu.Unlock()
+	if ok {
+		return pol, nil
+	}
+	var file struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	u := p.APIURL("/repos/" + url.PathEscape(org) + "/" + policyRepo + "/contents/" + policyFile)
+	if _, err := r.APIGet(ctx, p, u, &file); err != nil {
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+			return nil, err
+		}
+		pol = nil
+	} else {
+		if file.Encoding != "base64" {
+			return nil, fmt.Errorf("unexpected %s encoding %q", policyFile, file.Encoding)
+		}
+		data, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", policyFile, err)
+		}
+		if pol, err = parsePolicy(data); err != nil {
+			return nil, err
+		}
+		pol.org = name
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.policies == nil {
+		r.policies = make(map[string]*orgPolicy)
+	}
+	r.policies[name] = pol
+	return pol, nil
+}
+
+// parsePolicy parses policy document. Documents with unknown fields, or
+// requirements that can't be met, are rejected, so that policy is never
+// silently weakened.
+func parsePolicy(data []byte) (*orgPolicy, error) {
+	pol := new(orgPolicy)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(pol); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", policyFile, err)
+	}
+	for _, t := range pol.KeyTypes {
+		if t != "ssh-ed25519" && t != "ssh-rsa" {
+			return nil, fmt.Errorf("%s: key type %q is not supported by age", policyFile, t)
+		}
+	}
+	for _, d := range pol.Deny {
+		if !strings.HasPrefix(d, "SHA256:") {
+			return nil, fmt.Errorf("%s: deny entry %q is not a SHA256 fingerprint", policyFile, d)
+		}
+	}
+	if pol.RequirePinning {
+		return nil, fmt.Errorf("%s: policy requires pinned keys, which are not supported", policyFile)
+	}
+	return pol, nil
+}
@@ -0,0 +1,196 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GitHub users may publish native age recipients, one per line, as "age.txt"
+// file in their profile repository, the one named as user:
+//
+//	https://github.com/alice/alice/blob/HEAD/age.txt
+//
+// If Config.ProfileRecipients is set, such recipients are fetched along with
+// ssh keys, and are used instead of them, as they may be dedicated encryption
+// keys, including plugin ones, i.e. of hardware tokens.
+//
+// Users who'd rather not have a separate file may put recipients into profile
+// README.md instead, see readmeRecipients.
+const (
+	profileRecipientsFile = "age.txt"
+	profileReadme         = "README.md"
+)
+
+// IsAgeRecipient reports whether key is a native age recipient, either an
+// X25519 one, or a plugin one, as opposed to ssh key.
+func IsAgeRecipient(key string) bool {
+	return strings.HasPrefix(key, "age1") && !strings.ContainsAny(key, " \t")
+}
+
+// ageRecipientType returns type of native age recipient: "X25519" for
+// "age1..." recipients, or plugin name for "age1name1..." ones.
+func ageRecipientType(key string) string {
+	hrp := key[:strings.LastIndexByte(key, '1')]
+	if hrp == "age" {
+		return "X25519"
+	}
+	return "age-plugin-" + strings.TrimPrefix(hrp, "age1")
+}
+
+// preferAgeRecipients returns only native age recipients of keys, if there
+// are any, otherwise it returns keys unchanged.
+func preferAgeRecipients(keys []string) []string {
+	var out []string
+	for _, k := range keys {
+		if IsAgeRecipient(k) {
+			out = append(out, k)
+		}
+	}
+	if len(out) == 0 {
+		return keys
+	}
+	return out
+}
+
+// profileRecipients fetches native age recipients user published in profile
+// repository, returning them as newline-separated list. Recipients are taken
+// from age.txt file, or, if there's none, from README.md, see
+// readmeRecipients. It returns no recipients for users of non-GitHub
+// providers, or if user has no such files.
+func (r *Resolver) profileRecipients(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	if p.Type != ProviderGithub {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	data, err := r.verifiedRepoFile(ctx, p, username, username, profileRecipientsFile)
+	if err != nil {
+		return nil, err
+	}
+	list := ageRecipientLines(string(data))
+	if data == nil {
+		if data, err = r.repoFile(ctx, p, username, username, profileReadme); err != nil {
+			return nil, err
+		}
+		// README is only verified if it's used for recipients
+		if list = readmeRecipients(string(data)); len(list) != 0 {
+			if err := r.verifyRepoFile(ctx, p, username, username, profileReadme, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var buf bytes.Buffer
+	for _, line := range list {
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// repoFile fetches file from owner/repo repository, over API if provider has
+// a token, or as raw content otherwise. It returns nil data if there's no
+// such file.
+func (r *Resolver) repoFile(ctx context.Context, p *Provider, owner, repo, name string) ([]byte, error) {
+	repo = url.PathEscape(owner) + "/" + url.PathEscape(repo)
+	if p.LoadToken() != "" {
+		var file struct {
+			Content  string `json:"content"`
+			Encoding string `json:"encoding"`
+		}
+		if _, err := r.APIGet(ctx, p, p.APIURL("/repos/"+repo+"/contents/"+name), &file); err != nil {
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if file.Encoding != "base64" {
+			return nil, fmt.Errorf("unexpected %s encoding %q", name, file.Encoding)
+		}
+		data, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", name, err)
+		}
+		return data, nil
+	}
+	u := "https://" + p.Host + "/" + repo + "/raw/HEAD/" + name
+	if p.Host == "github.com" {
+		u = "https://raw.githubusercontent.com/" + repo + "/HEAD/" + name
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, statusError(resp)
+	}
+	data, err := r.readKeys(resp.Body, u)
+	if data == nil && err == nil {
+		data = []byte{} // file exists, but is empty
+	}
+	return data, err
+}
+
+// ageRecipientLines returns native age recipients from text, one per line,
+// skipping comments and anything else.
+func ageRecipientLines(text string) []string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); IsAgeRecipient(line) {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// readmeRecipients returns native age recipients from profile README: lines
+// of fenced code blocks with "age" info string, and recipients following
+// "age-recipient:" marker anywhere, i.e. in HTML comment:
+//
+//	```age
+//	age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+//	```
+//
+//	<!-- age-recipient: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p -->
+func readmeRecipients(text string) []string {
+	const marker = "age-recipient:"
+	var out []string
+	var fence string // opening fence of age block we're in
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case fence != "" && strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "":
+			fence = ""
+		case fence != "":
+			if IsAgeRecipient(line) {
+				out = append(out, line)
+			}
+		case strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~"):
+			if info := strings.TrimLeft(line, line[:1]); strings.TrimSpace(info) == "age" {
+				fence = line[:len(line)-len(info)]
+			}
+		default:
+			if i := strings.Index(line, marker); i >= 0 {
+				if fields := strings.Fields(line[i+len(marker):]); len(fields) != 0 && IsAgeRecipient(fields[0]) {
+					out = append(out, fields[0])
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,308 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider describes a source of user public keys: github.com, a GitHub
+// Enterprise Server instance, a GitLab instance, a HashiCorp Vault server, AWS
+// IAM, Google Cloud OS Login, or an LDAP directory.
+type Provider struct {
+	Name    string
+	Type    string // one of Provider* constants
+	Host    string
+	Token   string // optional; if set, keys are fetched over API, see TokenFunc
+	KeysURL string // template of plain .keys url, see Mirrors; if empty, https://HOST/%s.keys is used
+	Org     string // organization for team: groups without org part, overrides top-level setting
+	API     string // API url, i.e. "https://ghe.corp/api/v3"; if empty, it's derived from Host
+	Path    string // Vault secret path template, see fetchVaultKeys
+
+	// CacheTTL, if not 0, is how long keys are cached instead of
+	// Config.CacheTTL, i.e. less for directories changing often, negative
+	// disables caching. Caching is disabled if Config.CacheTTL is 0.
+	CacheTTL time.Duration
+
+	// LDAP directory settings, see fetchLDAPKeys.
+	BaseDN string // search base, i.e. "ou=people,dc=corp,dc=com"
+	BindDN string // if set, Token is used as its password, otherwise search is anonymous
+	TLS    string // one of LDAPS (default), LDAPStartTLS, or LDAPPlain
+
+	// Mirrors are templates of plain .keys endpoints urls, with %s
+	// replaced by user name, that are tried in order before the provider
+	// itself.
+	Mirrors []string
+
+	// TokenFunc, if set, is called once, when token of provider without
+	// Token is first needed, and its result is used as Token, i.e. to
+	// read token from secret manager only if provider is used.
+	TokenFunc func() string
+
+	tokenOnce sync.Once
+}
+
+// LoadToken returns provider token, calling TokenFunc on the first call if
+// Token is not set.
+func (p *Provider) LoadToken() string {
+	p.tokenOnce.Do(func() {
+		if p.Token == "" && p.TokenFunc != nil {
+			p.Token = p.TokenFunc()
+		}
+	})
+	return p.Token
+}
+
+// Provider types.
+const (
+	ProviderGithub  = "github"
+	ProviderGitlab  = "gitlab"
+	ProviderVault   = "vault"
+	ProviderAWSIAM  = "aws-iam"
+	ProviderOSLogin = "gcp-oslogin"
+	ProviderLDAP    = "ldap"
+)
+
+// validHandle reports whether s is a valid user name for this provider.
+func (p *Provider) validHandle(s string) bool {
+	switch p.Type {
+	case ProviderGitlab:
+		return gitlabUserNameRe.MatchString(s)
+	case ProviderVault:
+		return vaultNameRe.MatchString(s)
+	case ProviderAWSIAM:
+		return iamUserNameRe.MatchString(s)
+	case ProviderOSLogin:
+		return osLoginUserRe.MatchString(s)
+	case ProviderLDAP:
+		return ldapUIDRe.MatchString(s)
+	}
+	return validGithubHandle(s)
+}
+
+// validGithubHandle reports whether s is a valid GitHub user or organization
+// name: 1 to 39 ASCII letters, digits, or hyphens, where hyphen can be
+// neither first nor last character.
+func validGithubHandle(s string) bool {
+	if s == "" || len(s) > 39 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey returns a key under which keys of the user are cached. Keys of
+// github.com users are cached under plain user names.
+func (p *Provider) cacheKey(username string) string {
+	if p.Host == "github.com" {
+		return username
+	}
+	return p.Host + "/" + username
+}
+
+// APIURL returns url of the API endpoint for the given path.
+func (p *Provider) APIURL(path string) string {
+	switch {
+	case p.API != "":
+		return p.API + path
+	case p.Type == ProviderGitlab:
+		return "https://" + p.Host + "/api/v4" + path
+	case p.Type == ProviderVault:
+		return "https://" + p.Host + "/v1" + path
+	case p.Type == ProviderAWSIAM:
+		return "https://" + p.Host + path
+	case p.Type == ProviderOSLogin:
+		return "https://" + p.Host + "/v1" + path
+	case p.Host == "github.com":
+		return "https://api.github.com" + path
+	}
+	return "https://" + p.Host + "/api/v3" + path
+}
+
+// authorize sets request headers authenticating it with provider token, if
+// one is configured.
+func (p *Provider) authorize(req *http.Request) {
+	req.Header.Set("User-Agent", "github.com/artyom/age-github")
+	token := p.LoadToken()
+	if token == "" {
+		return
+	}
+	switch p.Type {
+	case ProviderGitlab:
+		req.Header.Set("Private-Token", token)
+	case ProviderVault:
+		req.Header.Set("X-Vault-Token", token)
+	default:
+		req.Header.Set("Authorization", "token "+token)
+	}
+}
+
+// keysOverAPI reports whether keys are fetched over API: if provider has a
+// token configured, or in browser, unless there's a KeysURL to use instead.
+func (p *Provider) keysOverAPI() bool {
+	return p.LoadToken() != "" || (browser && p.KeysURL == "")
+}
+
+// keysRequest returns request to fetch user public keys. If keysOverAPI,
+// request is made over API, and its response must be decoded with
+// parseAPIKeys.
+func (p *Provider) keysRequest(ctx context.Context, username string) (*http.Request, error) {
+	u := "https://" + p.Host + "/" + url.PathEscape(username) + ".keys"
+	if p.KeysURL != "" {
+		u = mirrorURL(p.KeysURL, username)
+	}
+	if p.keysOverAPI() {
+		u = p.APIURL("/users/" + url.PathEscape(username) + "/keys")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.authorize(req)
+	return req, nil
+}
+
+// parseAPIKeys decodes JSON array of objects with "key" field, as returned by
+// both GitHub and GitLab user keys API, into newline-separated keys list, as
+// served by plain .keys endpoints.
+func parseAPIKeys(r io.Reader) ([]byte, error) {
+	var keys []struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r).Decode(&keys); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	for _, k := range keys {
+		buf.WriteString(strings.TrimSpace(k.Key))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// fetchProviderKeys fetches keys of user from provider, returning them as
+// newline-separated list.
+func (r *Resolver) fetchProviderKeys(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	switch p.Type {
+	case ProviderVault:
+		return r.fetchVaultKeys(ctx, username, p)
+	case ProviderAWSIAM:
+		return r.fetchIAMKeys(ctx, username, p)
+	case ProviderOSLogin:
+		return r.fetchOSLoginKeys(ctx, username, p)
+	case ProviderLDAP:
+		return r.fetchLDAPKeys(ctx, username, p)
+	}
+	if err := r.throttle(ctx, p, rateLimitCore); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	req, err := p.keysRequest(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	r.noteRateLimit(p, resp.Header)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		if p.LoadToken() != "" {
+			if err := r.confirmUser(ctx, p, username); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrUserNotFound
+	default:
+		return nil, statusError(resp)
+	}
+	if !p.keysOverAPI() {
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			return nil, fmt.Errorf("unexpected content type %q", ct)
+		}
+		return r.readKeys(resp.Body, req.URL.String())
+	}
+	data, truncated, err := readLimited(resp.Body, r.cfg.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, fmt.Errorf("response is larger than max_response_size of %d bytes", r.cfg.MaxResponseSize)
+	}
+	return parseAPIKeys(bytes.NewReader(data))
+}
+
+// fetchMirrorKeys fetches keys from plain .keys endpoint url.
+func (r *Resolver) fetchMirrorKeys(ctx context.Context, u string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "github.com/artyom/age-github")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		return nil, fmt.Errorf("unexpected content type %q", ct)
+	}
+	return r.readKeys(resp.Body, u)
+}
+
+// readKeys reads newline-separated keys list from response body of url u.
+// Body larger than max_response_size setting is truncated after the last
+// complete line, with a warning.
+func (r *Resolver) readKeys(body io.Reader, u string) ([]byte, error) {
+	data, truncated, err := readLimited(body, r.cfg.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		r.warnf("%s response is larger than max_response_size of %d bytes, some keys are ignored", u, r.cfg.MaxResponseSize)
+		data = data[:bytes.LastIndexByte(data, '\n')+1]
+	}
+	return data, nil
+}
+
+// readLimited reads up to limit bytes from r, reporting whether there was
+// more.
+func readLimited(r io.Reader, limit int64) (data []byte, truncated bool, err error) {
+	data, err = ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(data)) > limit {
+		return data[:limit], true, err
+	}
+	return data, false, err
+}
+
+// mirrorURL returns keys url of user from template, replacing %s with user
+// name.
+func mirrorURL(template, username string) string {
+	return strings.Replace(template, "%s", url.PathEscape(username), -1)
+}
+
+var gitlabUserNameRe = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
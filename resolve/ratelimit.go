@@ -0,0 +1,133 @@
+package resolve
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API requests are throttled once provider reports less than
+// rateLimitReserve requests remaining: they are spread evenly over the time
+// left until rate limit window resets. If limit is exhausted, requests wait
+// for reset, unless it's more than rateLimitMaxWait away.
+const (
+	rateLimitReserve = 50
+	rateLimitMaxWait = 15 * time.Minute
+)
+
+const (
+	rateLimitCore    = "core"    // REST API requests
+	rateLimitGraphQL = "graphql" // GitHub GraphQL API requests, limited separately
+	rateLimitSearch  = "search"  // GitHub search API requests, limited much lower
+)
+
+// rateLimit is API rate limit state as reported by the latest response.
+type rateLimit struct {
+	remaining int
+	reset     time.Time
+}
+
+// RateLimit is API rate limit state of provider, as reported by its latest
+// response.
+type RateLimit struct {
+	Provider  string // provider name
+	Resource  string // "core" for REST API, "graphql" for GitHub GraphQL API
+	Remaining int
+	Reset     time.Time
+}
+
+// RateLimits returns known API rate limit states of providers.
+func (r *Resolver) RateLimits() []RateLimit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RateLimit, 0, len(r.limits))
+	for key, rl := range r.limits {
+		i := strings.LastIndexByte(key, '/')
+		out = append(out, RateLimit{Provider: key[:i], Resource: key[i+1:], Remaining: rl.remaining, Reset: rl.reset})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Provider != out[j].Provider {
+			return out[i].Provider < out[j].Provider
+		}
+		return out[i].Resource < out[j].Resource
+	})
+	return out
+}
+
+// noteRateLimit records rate limit state from API response headers: GitHub
+// reports it with X-RateLimit-* headers, GitLab with RateLimit-* ones.
+func (r *Resolver) noteRateLimit(p *Provider, h http.Header) {
+	prefix := "X-RateLimit-"
+	if p.Type == ProviderGitlab {
+		prefix = "RateLimit-"
+	}
+	remaining, err := strconv.Atoi(h.Get(prefix + "Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(h.Get(prefix+"Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	resource := h.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = rateLimitCore
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limits == nil {
+		r.limits = make(map[string]rateLimit)
+	}
+	r.limits[p.Name+"/"+resource] = rateLimit{remaining: remaining, reset: time.Unix(reset, 0)}
+}
+
+// Limiter limits rate of provider API requests, see Config.Limiter. It's
+// implemented by *rate.Limiter of golang.org/x/time/rate package.
+type Limiter interface {
+	// Wait blocks until request is allowed, or ctx is done.
+	Wait(ctx context.Context) error
+}
+
+// throttle delays API request to provider if its rate limit is close to
+// exhaustion, see rateLimitReserve, and until Config.Limiter allows it.
+func (r *Resolver) throttle(ctx context.Context, p *Provider, resource string) error {
+	if err := r.reserveLimit(ctx, p, resource); err != nil {
+		return err
+	}
+	if r.cfg.Limiter != nil {
+		return r.cfg.Limiter.Wait(ctx)
+	}
+	return nil
+}
+
+// reserveLimit spreads requests over time left until rate limit of provider
+// resets, once fewer than rateLimitReserve of them remain.
+func (r *Resolver) reserveLimit(ctx context.Context, p *Provider, resource string) error {
+	r.mu.Lock()
+	rl, ok := r.limits[p.Name+"/"+resource]
+	r.mu.Unlock()
+	left := time.Until(rl.reset)
+	if !ok || rl.remaining >= rateLimitReserve || left <= 0 {
+		return nil
+	}
+	delay := left / time.Duration(rl.remaining+1)
+	if rl.remaining == 0 {
+		if left > rateLimitMaxWait {
+			return fmt.Errorf("%w: %s limit is exhausted until %s", ErrRateLimited, p.Name, rl.reset.Format(time.RFC3339))
+		}
+		r.warnf("%s API rate limit exhausted, waiting %v for it to reset", p.Name, left.Round(time.Second))
+		delay = left
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package resolve
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+// Recipient is a public key of a resolved user.
+type Recipient struct {
+	Provider    string    // provider name
+	Handle      string    // handle key was resolved for, group members have "user@provider" handles
+	UserID      string    // user name at provider
+	KeyType     string    // i.e. "ssh-ed25519", or "X25519" for native age recipients
+	PublicKey   string    // key type and base64-encoded key, without comment, or native age recipient
+	Fingerprint string    // SHA256 fingerprint, as printed by ssh-keygen -l, or native age recipient
+	Comment     string    // key comment, if provider publishes one
+	FetchedAt   time.Time // when key was fetched from provider
+	Source      string    // where key came from: local (see Config.KeysDir), cache, daemon, or http
+	Org         bool      // key is a recipient organization publishes for user without keys, not user's own
+}
+
+// String returns key in authorized_keys format, as published by user.
+func (rc Recipient) String() string {
+	if rc.Comment == "" {
+		return rc.PublicKey
+	}
+	return rc.PublicKey + " " + rc.Comment
+}
+
+func newRecipient(p *Provider, handle, username, key string, res fetchResult) Recipient {
+	rc := Recipient{
+		Provider:    p.Name,
+		Handle:      handle,
+		UserID:      username,
+		PublicKey:   key,
+		Fingerprint: Fingerprint(key),
+		FetchedAt:   res.at,
+		Source:      res.source,
+		Org:         res.org,
+	}
+	if IsAgeRecipient(key) {
+		rc.KeyType = ageRecipientType(key)
+		rc.Fingerprint = key
+		return rc
+	}
+	if fields := strings.SplitN(key, " ", 3); len(fields) >= 2 {
+		rc.KeyType = fields[0]
+		rc.PublicKey = fields[0] + " " + fields[1]
+		if len(fields) == 3 {
+			rc.Comment = strings.TrimSpace(fields[2])
+		}
+	}
+	return rc
+}
+
+// KeyBits returns size of ssh key in bits, or 0 if key is not an ssh-ed25519
+// or ssh-rsa key.
+func KeyBits(key string) int {
+	switch {
+	case strings.HasPrefix(key, "ssh-ed25519 "):
+		return 256
+	case strings.HasPrefix(key, "ssh-rsa "):
+		return rsaBits(key)
+	}
+	return 0
+}
+
+// Fingerprint returns OpenSSH-style SHA256 fingerprint of ssh key in
+// authorized_keys format, as printed by ssh-keygen -l, or an empty string if
+// key is not an ssh key.
+func Fingerprint(key string) string {
+	fields := strings.Fields(key)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ssh-") && !strings.HasPrefix(fields[0], "ecdsa-") && !strings.HasPrefix(fields[0], "sk-") {
+		return ""
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
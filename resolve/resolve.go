@@ -0,0 +1,943 @@
+// Package resolve resolves user handles of GitHub and GitLab instances to
+// public ssh keys their users publish. It's the library behind age-github
+// command, see its documentation for handles syntax.
+//
+// Package builds for web browsers too (GOOS=js GOARCH=wasm), where keys are
+// fetched with Fetch API, over provider API, as plain .keys endpoints don't
+// allow cross-origin requests. Config.Cache can keep them in browser storage.
+package resolve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds resolver settings.
+type Config struct {
+	Providers       map[string]*Provider // keyed by provider name
+	DefaultProvider string               // provider used for handles without @provider suffix
+	Aliases         map[string]string    // short names of handles, see Resolver.Recipients
+	Org             string               // organization for team: groups without org part
+	KeyPolicy       string               // one of KeyPolicy* constants, defaults to KeyPolicyFirst
+	MaxKeys         int                  // max number of keys considered per user, 0 means no limit
+	MaxResponseSize int64                // max size of keys response, in bytes, 0 means 256 KiB
+	Timeout         time.Duration        // timeout of fetching keys of a single user, 0 means 10s
+	CacheDir        string               // on-disk cache directory, if empty, only memory is used
+	SharedCacheDir  string               // read-only cache directory, of CacheDir layout, consulted on CacheDir misses
+	CacheTTL        time.Duration        // how long fetched keys are cached, unless Provider.CacheTTL is set, 0 disables caching
+	GroupCacheTTL   time.Duration        // how long group members are cached, at most CacheTTL, 0 disables caching them
+	CacheMaxEntries int                  // max number of CacheDir entries, least recently used are evicted, 0 means no limit
+	CacheMaxSize    int64                // max total size of CacheDir entries, in bytes, 0 means no limit
+	KeysDir         string               // directory of locally maintained keys, see localKeys
+	Client          *http.Client         // if nil, http.DefaultClient is used
+
+	// FallbackProviders are names of providers tried in order, after
+	// DefaultProvider, for handles without @provider suffix whose users
+	// don't exist or have no keys there, i.e. for organizations migrating
+	// between instances.
+	FallbackProviders []string
+
+	// Cache, if set, is used instead of on-disk cache in CacheDir, i.e.
+	// to keep keys in browser storage.
+	Cache Cache
+
+	// PinMaxAge, if positive, is how long keys from KeysDir files are
+	// trusted after file was last modified. Users with older files fail
+	// with ErrPinExpired until file is touched, or rewritten, again.
+	PinMaxAge time.Duration
+
+	// Deny lists keys, by their SHA256 fingerprints ("SHA256:..."), and
+	// user handles, which must never be used. Denied keys are silently
+	// dropped from keys users publish, denied users fail to resolve.
+	Deny []string
+
+	// KeyTypes lists key types allowed as recipients, i.e. "ssh-ed25519".
+	// If empty, all types age supports are allowed. Users having no keys of
+	// allowed types fail to resolve with *KeyTypeError.
+	KeyTypes []string
+
+	// RSAKeys tells what to do when ssh-rsa key is picked as user
+	// recipient, as such keys make larger headers and slower operations
+	// than ssh-ed25519 ones: one of RSAKeys* constants, defaults to
+	// RSAKeysWarn.
+	RSAKeys string
+
+	// ProfileRecipients enables fetching native age recipients users
+	// publish in their GitHub profile repository, see profileRecipients.
+	// Users who publish them are resolved to them instead of ssh keys.
+	ProfileRecipients bool
+
+	// GistRecipients enables looking up native age recipients users publish
+	// as a public gist, see gistRecipients. They're used like profile ones.
+	GistRecipients bool
+
+	// VerifyFile, if set, is called with every recipients file fetched
+	// from a repository or gist of owner, along with its Sigstore bundle,
+	// see sigstoreBundleSuffix, or nil if there's none, i.e. to verify
+	// file was signed by owner. Returned error fails resolution.
+	VerifyFile func(ctx context.Context, p *Provider, owner, name string, data, bundle []byte) error
+
+	// OrgPolicy enables enforcing policies organizations publish on keys of
+	// their members as age-policy.yml file in their .github repository.
+	OrgPolicy bool
+
+	// RequireOrg, if set, is organization, in "org" or "org@provider"
+	// form, all resolved users must be members of. Users who are not fail
+	// with ErrNotOrgMember.
+	RequireOrg string
+
+	// Require2FA additionally requires members of RequireOrg organization
+	// to have two-factor authentication enabled, users who don't fail with
+	// ErrNo2FA. Checking it requires a token of organization owner.
+	Require2FA bool
+
+	// Fetch, if set, is tried before fetching keys from provider, i.e. to
+	// get them from a shared daemon. It's called with handle in
+	// "user@provider" form, and returns newline-separated keys list. If
+	// returned error is *NetworkError, keys are fetched from provider.
+	Fetch func(ctx context.Context, handle string) ([]byte, error)
+
+	Timings *Timings // if set, fetches are timed
+
+	// Limiter, if set, is waited on before every provider API request,
+	// in addition to throttling by rate limits provider reports, i.e. to
+	// enforce API budget shared by multiple resolvers.
+	Limiter Limiter
+
+	// Fetched, if set, is called after every fetch of keys of a single user
+	// with provider name, where keys came from (see Recipient.Source), how
+	// long it took, and error, if any, i.e. to collect metrics.
+	Fetched func(provider, source string, d time.Duration, err error)
+
+	// StartSpan, if set, starts tracing span named name: "resolve" for
+	// resolution of a user, "fetch" for fetch of user keys, "cache.get"
+	// and "cache.put" for cache operations, and "prefetch" for batch fetch.
+	// Returned context holds the span, and is used for operations within
+	// it. Spans of upstream HTTP requests are left to Client transport.
+	StartSpan func(ctx context.Context, name string) (context.Context, Span)
+
+	// Verify, if set, is called after keys of user are fetched and pass
+	// all checks, as an extra check before trusting them, i.e. of identity
+	// proofs user published elsewhere. Returned error fails resolution.
+	Verify func(ctx context.Context, username string, p *Provider) error
+
+	// Audit, if set, is called after every resolution of a single user,
+	// successful or not, i.e. to keep audit log of keys used.
+	Audit func(Event)
+
+	// Middleware wraps resolution of every single user, see Resolve, the
+	// first one being the outermost, i.e. to add logging, policy checks,
+	// or approval workflows. Resolutions that middleware doesn't pass on
+	// are not audited.
+	Middleware []Middleware
+
+	// Warnf, if set, is called with warnings about partially successful
+	// resolutions, i.e. when some of user keys are ignored.
+	Warnf func(format string, args ...interface{})
+}
+
+// Key policies select which of user keys are used.
+const (
+	KeyPolicyFirst   = "first"   // first key published by user
+	KeyPolicyAll     = "all"     // all keys published by user
+	KeyPolicyEd25519 = "ed25519" // first ssh-ed25519 key, falling back to first key
+)
+
+// RSAKeys* constants tell what to do when user resolves to ssh-rsa key.
+const (
+	RSAKeysIgnore = "ignore" // use key silently
+	RSAKeysWarn   = "warn"   // use key, warning through Config.Warnf
+	RSAKeysError  = "error"  // fail with error matching ErrRSAKey
+)
+
+// Resolver resolves user handles to their public keys. It's safe for
+// concurrent use.
+type Resolver struct {
+	cfg    Config
+	cache  Cache
+	client *http.Client
+	flight flightGroup
+	denied map[string]bool // denied key fingerprints and lower-cased user cache keys
+
+	resolve ResolveFunc // resolveFallback wrapped in Config.Middleware
+
+	mu     sync.Mutex
+	mem    map[string]memEntry  // in-memory cache, keyed as cache
+	limits map[string]rateLimit // keyed by provider name and rate limit resource
+
+	policies map[string]*orgPolicy // keyed by "org@provider", nil if organization has none
+	members  map[string]bool       // RequireOrg membership, keyed by lower-cased "org/user cache key"
+
+	no2FAOnce      sync.Once
+	no2FA          map[string]bool // lower-cased names of RequireOrg members without 2FA
+	no2FAErr       error
+	memberPolicies map[string][]*orgPolicy // keyed by lower-cased user cache key
+
+	awsMu    sync.Mutex
+	awsCreds awsCreds // cached credentials of aws-iam providers
+
+	gcpMu     sync.Mutex
+	gcpToken  string // cached access token of gcp-oslogin providers
+	gcpExpiry time.Time
+}
+
+type memEntry struct {
+	data []byte
+	at   time.Time
+}
+
+// New returns resolver using settings from cfg.
+func New(cfg Config) (*Resolver, error) {
+	switch cfg.KeyPolicy {
+	case "":
+		cfg.KeyPolicy = KeyPolicyFirst
+	case KeyPolicyFirst, KeyPolicyAll, KeyPolicyEd25519:
+	default:
+		return nil, fmt.Errorf("unsupported key policy %q", cfg.KeyPolicy)
+	}
+	switch cfg.RSAKeys {
+	case "":
+		cfg.RSAKeys = RSAKeysWarn
+	case RSAKeysIgnore, RSAKeysWarn, RSAKeysError:
+	default:
+		return nil, fmt.Errorf("unsupported rsa keys mode %q", cfg.RSAKeys)
+	}
+	if _, ok := cfg.Providers[cfg.DefaultProvider]; !ok {
+		return nil, fmt.Errorf("default provider %q is not configured", cfg.DefaultProvider)
+	}
+	for _, name := range cfg.FallbackProviders {
+		if _, ok := cfg.Providers[name]; !ok {
+			return nil, fmt.Errorf("fallback provider %q is not configured", name)
+		}
+	}
+	for _, t := range cfg.KeyTypes {
+		if t != "ssh-ed25519" && t != "ssh-rsa" {
+			return nil, fmt.Errorf("key type %q is not supported by age, use ssh-ed25519 or ssh-rsa", t)
+		}
+	}
+	if cfg.MaxResponseSize <= 0 {
+		cfg.MaxResponseSize = 256 << 10
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	r := &Resolver{cfg: cfg, client: cfg.Client}
+	r.resolve = func(ctx context.Context, handle string) ([]Recipient, error) {
+		return r.resolveFallback(ctx, handle, false)
+	}
+	for i := len(cfg.Middleware) - 1; i >= 0; i-- {
+		r.resolve = cfg.Middleware[i](r.resolve)
+	}
+	if r.client == nil {
+		r.client = http.DefaultClient
+	}
+	switch {
+	case cfg.Cache != nil:
+		r.cache = cfg.Cache
+	case cfg.CacheDir != "", cfg.SharedCacheDir != "":
+		r.cache = cacheDir{dir: cfg.CacheDir, shared: cfg.SharedCacheDir, ttl: r.keyTTL,
+			maxEntries: cfg.CacheMaxEntries, maxSize: cfg.CacheMaxSize}
+	}
+	if cfg.RequireOrg != "" {
+		if _, _, err := r.LookupProvider(cfg.RequireOrg); err != nil {
+			return nil, fmt.Errorf("required organization %q: %w", cfg.RequireOrg, err)
+		}
+	} else if cfg.Require2FA {
+		return nil, errors.New("two-factor authentication can only be required of members of required organization")
+	}
+	r.denied = make(map[string]bool, len(cfg.Deny))
+	for _, s := range cfg.Deny {
+		if strings.HasPrefix(s, "SHA256:") {
+			r.denied[s] = true
+			continue
+		}
+		username, p, err := r.LookupProvider(strings.TrimPrefix(s, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("deny list entry %q: %w", s, err)
+		}
+		r.denied[strings.ToLower(p.cacheKey(username))] = true
+	}
+	return r, nil
+}
+
+// cached returns cached data for a key and time it was fetched, checking
+// in-memory cache first. Entries older than ttl are ignored.
+func (r *Resolver) cached(ctx context.Context, key string, ttl time.Duration) ([]byte, time.Time, error) {
+	if bypassCache(ctx) {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	r.mu.Lock()
+	e, ok := r.mem[key]
+	r.mu.Unlock()
+	if ok && time.Since(e.at) < ttl {
+		return e.data, e.at, nil
+	}
+	if r.cache == nil || ttl <= 0 {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	_, span := r.startSpan(ctx, "cache.get", "age_github.cache_key", key)
+	data, at, err := r.cache.Get(key)
+	if err == nil && time.Since(at) >= ttl {
+		data, at, err = nil, time.Time{}, os.ErrNotExist
+	}
+	span.SetAttribute("age_github.cache_hit", strconv.FormatBool(err == nil))
+	if errors.Is(err, os.ErrNotExist) {
+		span.End(nil) // miss
+	} else {
+		span.End(err)
+	}
+	return data, at, err
+}
+
+// bypassCacheKey is context key marking calls which bypass caches, see
+// WithoutCache.
+type bypassCacheKey struct{}
+
+// WithoutCache returns context making Resolver calls made with it fetch keys
+// and group members from providers, bypassing in-memory, on-disk, and
+// Config.Fetch caches, i.e. when keys were likely just changed. Keys pinned in
+// KeysDir are still used, and fetched data is still stored in caches.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+// bypassCache reports whether ctx is made with WithoutCache.
+func bypassCache(ctx context.Context) bool {
+	v, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return v
+}
+
+// cacheTTL returns how long keys of provider p are cached.
+func (r *Resolver) cacheTTL(p *Provider) time.Duration {
+	if r.cfg.CacheTTL <= 0 || p.CacheTTL == 0 {
+		return r.cfg.CacheTTL
+	}
+	return p.CacheTTL
+}
+
+// groupCacheTTL returns how long group members of provider p are cached: the
+// lesser of Config.GroupCacheTTL and cache TTL of p.
+func (r *Resolver) groupCacheTTL(p *Provider) time.Duration {
+	if ttl := r.cacheTTL(p); ttl < r.cfg.GroupCacheTTL {
+		return ttl
+	}
+	return r.cfg.GroupCacheTTL
+}
+
+// keyTTL returns how long cache entry of key is fresh. Providers sharing a
+// host share cache entries, see Provider.cacheKey, so the least cache TTL of
+// them applies.
+func (r *Resolver) keyTTL(key string) time.Duration {
+	host := cacheKeyHost(key)
+	ttl, found := r.cfg.CacheTTL, false
+	for _, p := range r.cfg.Providers {
+		if t := r.cacheTTL(p); p.Host == host && (!found || t < ttl) {
+			ttl, found = t, true
+		}
+	}
+	return ttl
+}
+
+// store saves data both in in-memory and on-disk, or configured, caches.
+func (r *Resolver) store(ctx context.Context, key string, data []byte) {
+	r.remember(key, data)
+	if r.cache == nil {
+		return
+	}
+	_, span := r.startSpan(ctx, "cache.put", "age_github.cache_key", key)
+	span.End(r.cache.Put(key, data))
+}
+
+// remember saves data in in-memory cache only.
+func (r *Resolver) remember(key string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mem == nil {
+		r.mem = make(map[string]memEntry)
+	}
+	r.mem[key] = memEntry{data: data, at: time.Now()}
+}
+
+// warnf reports a warning with Config.Warnf, if set.
+func (r *Resolver) warnf(format string, args ...interface{}) {
+	if r.cfg.Warnf != nil {
+		r.cfg.Warnf(format, args...)
+	}
+}
+
+// ExpandAlias returns handle an alias name points to, or name itself if it
+// is not an alias.
+func (r *Resolver) ExpandAlias(name string) string {
+	if v, ok := r.cfg.Aliases[name]; ok {
+		return v
+	}
+	return name
+}
+
+// LookupProvider splits handle in "user" or "user@provider" form into user
+// name and a provider, which may be given either by its configured name, or
+// its host.
+func (r *Resolver) LookupProvider(handle string) (string, *Provider, error) {
+	i := strings.LastIndexByte(handle, '@')
+	if i < 0 {
+		return handle, r.cfg.Providers[r.cfg.DefaultProvider], nil
+	}
+	user, name := handle[:i], handle[i+1:]
+	if p, ok := r.cfg.Providers[name]; ok {
+		return user, p, nil
+	}
+	for _, p := range r.cfg.Providers {
+		if p.Host == name {
+			return user, p, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w %q", ErrUnknownProvider, name)
+}
+
+// Resolve returns keys of a single user identified by handle, selected
+// according to configured key policy. Handle must not be an alias or a
+// group handle, see Recipients. Resolution goes through Config.Middleware.
+func (r *Resolver) Resolve(ctx context.Context, handle string) ([]Recipient, error) {
+	return r.resolve(ctx, handle)
+}
+
+// ResolveAll returns all keys of a single user identified by handle which
+// pass the same checks Resolve does, regardless of key policy, and without
+// preferring native age recipients over ssh keys, i.e. to authorize ssh
+// access. Unlike Resolve, it doesn't go through Config.Middleware.
+func (r *Resolver) ResolveAll(ctx context.Context, handle string) ([]Recipient, error) {
+	return r.resolveFallback(ctx, handle, true)
+}
+
+// resolveFallback is Resolve without middleware: it resolves handles without
+// @provider suffix on Config.FallbackProviders in turn, while users are not
+// found, returning error of the default provider if none has them. With all
+// set, it keeps all keys, see ResolveAll.
+func (r *Resolver) resolveFallback(ctx context.Context, handle string, all bool) ([]Recipient, error) {
+	out, err := r.resolveUser(ctx, handle, all)
+	if !isMissing(err) || strings.ContainsRune(handle, '@') {
+		return out, err
+	}
+	for _, name := range r.cfg.FallbackProviders {
+		if name == r.cfg.DefaultProvider {
+			continue
+		}
+		if out2, err2 := r.resolveUser(ctx, handle+"@"+name, all); !isMissing(err2) {
+			return out2, err2
+		}
+	}
+	return out, err
+}
+
+// isMissing reports whether error tells that user doesn't exist or has no
+// keys, so it may be looked up elsewhere.
+func isMissing(err error) bool {
+	return errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrNoKeys)
+}
+
+// resolveUser resolves handle on a single provider. With all set, it skips
+// key selection, leaving all keys that pass the checks.
+func (r *Resolver) resolveUser(ctx context.Context, handle string, all bool) (out []Recipient, err error) {
+	ctx, span := r.startSpan(ctx, "resolve", "age_github.handle", handle)
+	defer func() {
+		span.SetAttribute("age_github.keys", strconv.Itoa(len(out)))
+		span.End(err)
+	}()
+	var res fetchResult
+	username, p, err := r.LookupProvider(handle)
+	defer func() { r.audit(handle, p, username, res, out, err) }()
+	if err != nil {
+		return nil, fmt.Errorf("resolving %q: %w", handle, err)
+	}
+	res, err = r.fetch(ctx, username, p)
+	keys := res.keys
+	if err == nil && len(keys) == 0 {
+		err = ErrNoKeys
+		if p.LoadToken() != "" && (p.Type == ProviderGithub || p.Type == ProviderGitlab) {
+			if err2 := r.confirmUser(ctx, p, username); err2 != nil {
+				err = err2
+			}
+		}
+	}
+	if err == nil {
+		if keys = supportedKeys(keys); len(keys) == 0 {
+			err = ErrUnsupportedKeyType
+		}
+	}
+	if err == nil && len(r.cfg.KeyTypes) != 0 {
+		allowed := allowedKeys(keys, r.cfg.KeyTypes)
+		if len(allowed) == 0 {
+			err = &KeyTypeError{Allowed: r.cfg.KeyTypes, Keys: keys}
+		}
+		keys = allowed
+	}
+	if err == nil {
+		for _, pol := range r.policiesOf(username, p) {
+			if keys, err = pol.filter(keys); err != nil {
+				break
+			}
+		}
+		if !res.org && !all {
+			keys = preferAgeRecipients(keys)
+		}
+	}
+	if err == nil && r.cfg.RequireOrg != "" {
+		err = r.checkMember(ctx, p, username)
+	}
+	if err == nil && r.cfg.Verify != nil {
+		err = r.cfg.Verify(ctx, username, p)
+	}
+	if err != nil {
+		return nil, &ResolveError{Provider: p, User: username, Err: err}
+	}
+	if all {
+		out = make([]Recipient, len(keys))
+		for i, k := range keys {
+			out[i] = newRecipient(p, handle, username, k, res)
+		}
+		return out, nil
+	}
+	if max := r.cfg.MaxKeys; max > 0 && len(keys) > max && !res.org {
+		r.warnf("%s user %q has %d keys, only first %d are considered", p.Name, username, len(keys), max)
+		keys = keys[:max]
+	}
+	published := keys
+	switch {
+	case r.cfg.KeyPolicy == KeyPolicyAll:
+	case res.org:
+		// recipients organization publishes are all used
+	case r.cfg.KeyPolicy == KeyPolicyEd25519:
+		first := keys[:1]
+		for _, k := range keys {
+			if strings.HasPrefix(k, "ssh-ed25519 ") {
+				first = []string{k}
+				break
+			}
+		}
+		keys = first
+	default:
+		keys = keys[:1]
+	}
+	// ssh-rsa keys are deliberate if allowed key types exclude ssh-ed25519
+	ed25519Allowed := len(r.cfg.KeyTypes) == 0 || len(allowedKeys([]string{"ssh-ed25519 "}, r.cfg.KeyTypes)) != 0
+	if r.cfg.RSAKeys != RSAKeysIgnore && !res.org && ed25519Allowed {
+		if rsaErr := rsaKeyError(keys, published); rsaErr != nil {
+			if r.cfg.RSAKeys == RSAKeysError {
+				return nil, &ResolveError{Provider: p, User: username, Err: rsaErr}
+			}
+			r.warnf("%s user %q%s; ssh-rsa keys make larger headers and slower operations than ssh-ed25519 ones",
+				p.Name, username, strings.TrimPrefix(rsaErr.Error(), "user"))
+		}
+	}
+	out = make([]Recipient, len(keys))
+	for i, k := range keys {
+		out[i] = newRecipient(p, handle, username, k, res)
+	}
+	return out, nil
+}
+
+// rsaKeyError returns error matching ErrRSAKey if any of chosen keys is
+// ssh-rsa one, and none is ssh-ed25519 one, telling whether user publishes
+// ssh-ed25519 key too.
+func rsaKeyError(chosen, published []string) error {
+	for _, k := range chosen {
+		if strings.HasPrefix(k, "ssh-ed25519 ") {
+			return nil
+		}
+	}
+	for _, k := range chosen {
+		if !strings.HasPrefix(k, "ssh-rsa ") {
+			continue
+		}
+		for _, k := range published {
+			if strings.HasPrefix(k, "ssh-ed25519 ") {
+				return fmt.Errorf("%w, though ssh-ed25519 one, which %q key policy picks, is published too", ErrRSAKey, KeyPolicyEd25519)
+			}
+		}
+		return fmt.Errorf("%w, and publishes no ssh-ed25519 one", ErrRSAKey)
+	}
+	return nil
+}
+
+// FetchKeys returns all keys published by user of provider p, regardless of
+// key policy.
+func (r *Resolver) FetchKeys(ctx context.Context, username string, p *Provider) ([]string, error) {
+	res, err := r.fetch(ctx, username, p)
+	return res.keys, err
+}
+
+// FetchKeysData returns keys like FetchKeys does, as newline-separated list,
+// in the form Config.Fetch is expected to return, i.e. to serve them to other
+// resolvers.
+func (r *Resolver) FetchKeysData(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	res, err := r.fetch(ctx, username, p)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if res.org {
+		buf.Write(orgRecipientsMarker)
+	}
+	for _, k := range res.keys {
+		buf.WriteString(k + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// FetchRecipients returns all keys published by a single user identified by
+// handle, regardless of key policy.
+func (r *Resolver) FetchRecipients(ctx context.Context, handle string) (out []Recipient, err error) {
+	var res fetchResult
+	username, p, err := r.LookupProvider(handle)
+	defer func() { r.audit(handle, p, username, res, out, err) }()
+	if err != nil {
+		return nil, fmt.Errorf("resolving %q: %w", handle, err)
+	}
+	if res, err = r.fetch(ctx, username, p); err != nil {
+		return nil, &ResolveError{Provider: p, User: username, Err: err}
+	}
+	out = make([]Recipient, len(res.keys))
+	for i, k := range res.keys {
+		out[i] = newRecipient(p, handle, username, k, res)
+	}
+	return out, nil
+}
+
+// fetchResult holds keys fetched for user.
+type fetchResult struct {
+	keys   []string
+	at     time.Time // when keys were fetched from provider
+	source string    // where keys came from: local, cache, daemon, or http
+	org    bool      // keys are recipients organization publishes, see orgRecipients
+}
+
+// parseFetched parses newline-separated keys list into fetchResult.
+func parseFetched(data []byte, at time.Time, source string) (fetchResult, error) {
+	keys, err := parseReaderToKeys(bytes.NewReader(data))
+	return fetchResult{keys: keys, at: at, source: source, org: bytes.HasPrefix(data, orgRecipientsMarker)}, err
+}
+
+// fetch returns all keys published by user, and time they were fetched from
+// provider. Concurrent fetches of the same user share a single fetch.
+func (r *Resolver) fetch(ctx context.Context, username string, p *Provider) (fetchResult, error) {
+	if !p.validHandle(username) {
+		return fetchResult{}, ErrInvalidHandle
+	}
+	if r.denied[strings.ToLower(p.cacheKey(username))] {
+		return fetchResult{}, ErrUserDenied
+	}
+	res, err := r.flight.do(p.cacheKey(username), func() (fetchResult, error) {
+		return r.fetchOnce(ctx, username, p)
+	})
+	if err != nil || len(r.denied) == 0 {
+		return res, err
+	}
+	var out []string
+	for _, k := range res.keys {
+		if !r.denied[Fingerprint(k)] {
+			out = append(out, k)
+		}
+	}
+	if len(out) == 0 && len(res.keys) != 0 {
+		return fetchResult{at: res.at, source: res.source}, ErrKeysDenied
+	}
+	res.keys = out
+	return res, nil
+}
+
+func (r *Resolver) fetchOnce(ctx context.Context, username string, p *Provider) (res fetchResult, err error) {
+	ctx, span := r.startSpan(ctx, "fetch", "age_github.user", username, "age_github.provider", p.Name)
+	ctx, tm := r.cfg.Timings.begin(ctx, username+"@"+p.Name)
+	start := time.Now()
+	defer func() {
+		span.SetAttribute("age_github.source", res.source)
+		span.End(err)
+		tm.setSource(res.source)
+		r.cfg.Timings.end(tm, err)
+		if r.cfg.Fetched != nil {
+			r.cfg.Fetched(p.Name, res.source, time.Since(start), err)
+		}
+	}()
+	if data, at, err := r.localKeys(username, p); err == nil {
+		if r.cfg.PinMaxAge > 0 && time.Since(at) > r.cfg.PinMaxAge {
+			return fetchResult{at: at, source: "local"}, fmt.Errorf("%w: last confirmed on %s", ErrPinExpired, at.Format("2006-01-02"))
+		}
+		defer tm.parsed(time.Now())
+		return parseFetched(data, at, "local")
+	} else if !os.IsNotExist(err) {
+		return fetchResult{source: "local"}, err
+	}
+	cacheKey := p.cacheKey(username)
+	if data, at, err := r.cached(ctx, cacheKey, r.cacheTTL(p)); err == nil {
+		defer tm.parsed(time.Now())
+		return parseFetched(data, at, "cache")
+	}
+	if r.cfg.Fetch != nil && !bypassCache(ctx) {
+		fctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+		data, err := r.cfg.Fetch(fctx, username+"@"+p.Name)
+		cancel()
+		var netErr *NetworkError
+		switch {
+		case err == nil:
+			r.remember(cacheKey, data)
+			defer tm.parsed(time.Now())
+			return parseFetched(data, time.Now(), "daemon")
+		case !errors.As(err, &netErr):
+			return fetchResult{source: "daemon"}, err
+		}
+		// daemon is not reachable, fetch keys directly
+	}
+	res.source = "http"
+	data, err := r.fetchPublished(ctx, username, p)
+	if err != nil {
+		return res, err
+	}
+	defer tm.parsed(time.Now())
+	if res, err = parseFetched(data, time.Now(), "http"); err != nil {
+		return res, err
+	}
+	if r.cacheTTL(p) > 0 {
+		r.store(ctx, cacheKey, data)
+	}
+	return res, nil
+}
+
+// fetchPublished fetches keys user published, from provider mirrors or
+// provider itself, preceded by native age recipients from profile repository
+// or gist, if enabled. For users with no keys, recipients organization
+// publishes are returned, see orgRecipients.
+func (r *Resolver) fetchPublished(ctx context.Context, username string, p *Provider) (data []byte, err error) {
+	for _, tmpl := range p.Mirrors {
+		if data, err = r.fetchMirrorKeys(ctx, mirrorURL(tmpl, username)); err == nil {
+			break
+		}
+		// mirror may be unavailable, or out of date, fall back to
+		// the next one, and eventually to provider itself
+	}
+	if data == nil {
+		if data, err = r.fetchProviderKeys(ctx, username, p); err != nil {
+			return nil, err
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		// organizations have no keys, but may publish recipients
+		org, err := r.orgRecipients(ctx, username, p)
+		if err != nil {
+			return nil, fmt.Errorf("fetching %s of %s repository: %w", orgRecipientsFile, policyRepo, err)
+		}
+		if org != nil {
+			return append(append([]byte(nil), orgRecipientsMarker...), org...), nil
+		}
+	}
+	var native []byte
+	if r.cfg.ProfileRecipients {
+		if native, err = r.profileRecipients(ctx, username, p); err != nil {
+			return nil, fmt.Errorf("fetching %s of profile repository: %w", profileRecipientsFile, err)
+		}
+	}
+	if len(native) == 0 && r.cfg.GistRecipients {
+		if native, err = r.gistRecipients(ctx, username, p); err != nil {
+			return nil, fmt.Errorf("fetching recipients gist: %w", err)
+		}
+	}
+	return append(native, data...), nil
+}
+
+// localKeys returns keys from file maintained by user in KeysDir directory,
+// and its modification time. Such file overrides keys published by user,
+// i.e. for keys verified out of band, or to work offline. If there's no such
+// file, returned error satisfies os.IsNotExist.
+func (r *Resolver) localKeys(username string, p *Provider) ([]byte, time.Time, error) {
+	name, fi, err := r.LocalKeysFile(username, p)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := ioutil.ReadFile(name)
+	return data, fi.ModTime(), err
+}
+
+// LocalKeysFile returns name and info of KeysDir file overriding keys of user
+// of provider p. It's named "user@provider", where provider is its name or
+// host, or just "user" for users of the default provider. If there's no such
+// file, returned error satisfies os.IsNotExist.
+func (r *Resolver) LocalKeysFile(username string, p *Provider) (string, os.FileInfo, error) {
+	if r.cfg.KeysDir == "" {
+		return "", nil, os.ErrNotExist
+	}
+	names := []string{username + "@" + p.Name, username + "@" + p.Host}
+	if p.Name == r.cfg.DefaultProvider {
+		names = append(names, username)
+	}
+	for _, name := range names {
+		name = filepath.Join(r.cfg.KeysDir, name)
+		fi, err := os.Stat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", nil, err
+		}
+		return name, fi, nil
+	}
+	return "", nil, os.ErrNotExist
+}
+
+// PublishedKeys fetches keys user of provider p currently publishes,
+// bypassing KeysDir files, caches, and Config.Fetch, i.e. to compare them
+// with keys pinned in KeysDir.
+func (r *Resolver) PublishedKeys(ctx context.Context, username string, p *Provider) ([]string, error) {
+	data, err := r.fetchPublished(ctx, username, p)
+	if err != nil {
+		return nil, &ResolveError{Provider: p, User: username, Err: err}
+	}
+	return parseReaderToKeys(bytes.NewReader(data))
+}
+
+// supportedKeys returns keys of types age supports as recipients.
+func supportedKeys(keys []string) []string {
+	out := keys[:0:0]
+	for _, k := range keys {
+		if strings.HasPrefix(k, "ssh-ed25519 ") || strings.HasPrefix(k, "ssh-rsa ") || IsAgeRecipient(k) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// allowedKeys returns keys of the given types.
+func allowedKeys(keys, types []string) []string {
+	out := keys[:0:0]
+	for _, k := range keys {
+		for _, t := range types {
+			if strings.HasPrefix(k, t+" ") {
+				out = append(out, k)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// PruneCache removes CacheDir entries older than CacheTTL, and least recently
+// used ones while cache exceeds CacheMaxEntries or CacheMaxSize limits, along
+// with cached responses no entry refers to.
+func (r *Resolver) PruneCache() (CachePruneStats, error) {
+	c, ok := r.cache.(cacheDir)
+	if !ok || c.dir == "" {
+		return CachePruneStats{}, errors.New("no on-disk cache configured")
+	}
+	return c.evict(true)
+}
+
+// CacheStats describes CacheDir entries, and lookups of them.
+func (r *Resolver) CacheStats() (CacheStats, error) {
+	c, ok := r.cache.(cacheDir)
+	if !ok || c.dir == "" {
+		return CacheStats{}, errors.New("no on-disk cache configured")
+	}
+	return c.stats(func(key string) (string, string) {
+		p, user := r.cacheKeyProvider(key)
+		return p.Name, user
+	})
+}
+
+// CachedRecipients returns all keys of users found in caches, regardless of
+// key policy, and of whether cache entries are stale. Recipient handles are in
+// "user@provider" form. Config.Cache entries are not listed.
+func (r *Resolver) CachedRecipients() ([]Recipient, error) {
+	entries := make(map[string]memEntry)
+	if c, ok := r.cache.(cacheDir); ok {
+		// entries of private cache override shared ones
+		for _, dir := range []string{c.shared, c.dir} {
+			if dir == "" {
+				continue
+			}
+			c := cacheDir{dir: dir}
+			index, err := c.readIndex()
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for key, e := range index {
+				if data, err := c.readObject(e.hash); err == nil {
+					entries[key] = memEntry{data: data, at: e.at}
+				}
+			}
+		}
+	}
+	r.mu.Lock()
+	for key, e := range r.mem {
+		entries[key] = e
+	}
+	r.mu.Unlock()
+	cacheKeys := make([]string, 0, len(entries))
+	for key := range entries {
+		cacheKeys = append(cacheKeys, key)
+	}
+	sort.Strings(cacheKeys)
+	var out []Recipient
+	for _, key := range cacheKeys {
+		p, username := r.cacheKeyProvider(key)
+		if strings.ContainsRune(username, ':') {
+			continue // group members or search results, see ExpandGroup and SearchUsers
+		}
+		keys, err := parseReaderToKeys(bytes.NewReader(entries[key].data))
+		if err != nil {
+			continue
+		}
+		for _, k := range keys {
+			out = append(out, newRecipient(p, username+"@"+p.Name, username, k, fetchResult{at: entries[key].at, source: "cache"}))
+		}
+	}
+	return out, nil
+}
+
+// cacheKeyProvider returns provider and user name that cache key was made
+// from, see Provider.cacheKey. Keys of providers no longer configured are
+// attributed to providers named after their hosts, and keys of providers
+// sharing a host to the one which name sorts first.
+func (r *Resolver) cacheKeyProvider(key string) (*Provider, string) {
+	host, username := cacheKeyHost(key), key
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		username = key[i+1:]
+	}
+	var found *Provider
+	for _, p := range r.cfg.Providers {
+		if p.Host == host && (found == nil || p.Name < found.Name) {
+			found = p
+		}
+	}
+	if found == nil {
+		found = &Provider{Name: host, Host: host}
+	}
+	return found, username
+}
+
+// parseReaderToKeys parses reader, returning lines starting with "ssh-"
+// prefix
+func parseReaderToKeys(r io.Reader) ([]string, error) {
+	var out []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "ssh-") || IsAgeRecipient(line) {
+			out = append(out, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
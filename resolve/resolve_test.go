@@ -0,0 +1,33 @@
+package resolve
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRSAKeyError(t *testing.T) {
+	const (
+		ed  = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA"
+		rsa = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC7"
+		age = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	)
+	for _, tc := range []struct {
+		chosen, published []string
+		wantErr           bool
+	}{
+		{chosen: []string{ed}, published: []string{ed, rsa}},
+		{chosen: []string{ed, rsa}, published: []string{ed, rsa}}, // key = "all"
+		{chosen: []string{rsa, ed}, published: []string{rsa, ed}},
+		{chosen: []string{age}, published: []string{age, rsa}},
+		{chosen: []string{rsa}, published: []string{rsa, ed}, wantErr: true},
+		{chosen: []string{rsa}, published: []string{rsa}, wantErr: true},
+	} {
+		err := rsaKeyError(tc.chosen, tc.published)
+		if tc.wantErr != (err != nil) {
+			t.Errorf("chosen %q: got error %v, want error: %v", tc.chosen, err, tc.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrRSAKey) {
+			t.Errorf("chosen %q: error %v doesn't match ErrRSAKey", tc.chosen, err)
+		}
+	}
+}
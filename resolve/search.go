@@ -0,0 +1,58 @@
+package resolve
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// searchPrefixRe matches beginnings of GitHub logins.
+var searchPrefixRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]{0,38}$`)
+
+// searchLimit is the max number of logins SearchUsers returns.
+const searchLimit = 20
+
+// SearchUsers returns logins of provider users starting with prefix, best
+// matches first, i.e. to complete handles as they're typed. It uses GitHub
+// user search API, authenticated with provider token, if set, which allows
+// more searches per minute. Results are cached like keys of the provider.
+func (r *Resolver) SearchUsers(ctx context.Context, p *Provider, prefix string) ([]string, error) {
+	if p.Type != ProviderGithub {
+		return nil, fmt.Errorf("user search is not supported by %s provider", p.Type)
+	}
+	if !searchPrefixRe.MatchString(prefix) {
+		return nil, nil
+	}
+	cacheKey := strings.ToLower(p.cacheKey("search:" + prefix))
+	if data, _, err := r.cached(ctx, cacheKey, r.cacheTTL(p)); err == nil {
+		return strings.Fields(string(data)), nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	var res struct {
+		Items []struct {
+			Login string `json:"login"`
+		} `json:"items"`
+	}
+	q := url.Values{"q": {prefix + " in:login type:user"}, "per_page": {"100"}}
+	if _, err := r.apiGet(ctx, p, p.APIURL("/search/users?"+q.Encode()), rateLimitSearch, &res); err != nil {
+		return nil, err
+	}
+	// search matches logins containing prefix anywhere, only the ones
+	// starting with it are of use
+	var logins []string
+	for _, item := range res.Items {
+		if len(logins) == searchLimit {
+			break
+		}
+		if len(item.Login) >= len(prefix) && strings.EqualFold(item.Login[:len(prefix)], prefix) {
+			logins = append(logins, item.Login)
+		}
+	}
+	if r.cacheTTL(p) > 0 {
+		r.store(ctx, cacheKey, []byte(strings.Join(logins, "\n")+"\n"))
+	}
+	return logins, nil
+}
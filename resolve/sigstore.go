@@ -0,0 +1,50 @@
+package resolve
+
+import (
+	"context"
+	"fmt"
+)
+
+// Recipients files published in repositories and gists may be signed with
+// Sigstore, i.e. with "cosign sign-blob --bundle" from GitHub Actions
+// workflow, attesting that file comes from its owner. Such signature is
+// stored next to file, in a file with this suffix, and is passed to
+// Config.VerifyFile.
+const sigstoreBundleSuffix = ".sigstore.json"
+
+// verifiedRepoFile is repoFile which verifies fetched file with
+// Config.VerifyFile, if it's set.
+func (r *Resolver) verifiedRepoFile(ctx context.Context, p *Provider, owner, repo, name string) ([]byte, error) {
+	data, err := r.repoFile(ctx, p, owner, repo, name)
+	if data == nil || err != nil {
+		return data, err
+	}
+	if err := r.verifyRepoFile(ctx, p, owner, repo, name, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyRepoFile verifies file fetched from owner/repo repository with
+// Config.VerifyFile, if it's set, fetching its Sigstore bundle.
+func (r *Resolver) verifyRepoFile(ctx context.Context, p *Provider, owner, repo, name string, data []byte) error {
+	if r.cfg.VerifyFile == nil {
+		return nil
+	}
+	bundle, err := r.repoFile(ctx, p, owner, repo, name+sigstoreBundleSuffix)
+	if err != nil {
+		return fmt.Errorf("fetching %s signature: %w", name, err)
+	}
+	return r.verifyFile(ctx, p, owner, name, data, bundle)
+}
+
+// verifyFile calls Config.VerifyFile, if it's set.
+func (r *Resolver) verifyFile(ctx context.Context, p *Provider, owner, name string, data, bundle []byte) error {
+	if r.cfg.VerifyFile == nil {
+		return nil
+	}
+	if err := r.cfg.VerifyFile(ctx, p, owner, name, data, bundle); err != nil {
+		return fmt.Errorf("verifying %s of %s: %w", name, owner, err)
+	}
+	return nil
+}
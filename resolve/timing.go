@@ -0,0 +1,138 @@
+package resolve
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Timings collects per-user breakdown of how keys were fetched, see
+// Config.Timings.
+type Timings struct {
+	start time.Time
+
+	mu   sync.Mutex
+	list []*timing
+}
+
+// NewTimings returns Timings measuring total time from now.
+func NewTimings() *Timings { return &Timings{start: time.Now()} }
+
+// timing describes a single keys fetch. Its methods are safe to call on nil
+// timing, which is what fetch functions use when timings are not enabled.
+type timing struct {
+	name  string // user, or description of a batch request
+	start time.Time
+
+	mu                         sync.Mutex
+	source                     string // where keys came from: cache, daemon, http, graphql
+	dnsStart, connStart, tlsAt time.Time
+	dns, conn, tls, wait       time.Duration
+	parse, total               time.Duration
+	err                        error
+}
+
+// begin starts timing of fetch named name, returning context tracing HTTP
+// requests made for this fetch. If t is nil, it returns nil timing and ctx
+// as is.
+func (t *Timings) begin(ctx context.Context, name string) (context.Context, *timing) {
+	if t == nil {
+		return ctx, nil
+	}
+	tm := &timing{name: name, start: time.Now()}
+	return httptrace.WithClientTrace(ctx, tm.trace()), tm
+}
+
+// end records fetch result.
+func (t *Timings) end(tm *timing, err error) {
+	if t == nil || tm == nil {
+		return
+	}
+	tm.mu.Lock()
+	tm.total, tm.err = time.Since(tm.start), err
+	tm.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.list = append(t.list, tm)
+}
+
+func (tm *timing) setSource(source string) {
+	if tm == nil {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.source = source
+}
+
+// parsed records time spent parsing response since start.
+func (tm *timing) parsed(start time.Time) {
+	if tm == nil {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.parse += time.Since(start)
+}
+
+func (tm *timing) trace() *httptrace.ClientTrace {
+	lock := func(fn func()) {
+		tm.mu.Lock()
+		defer tm.mu.Unlock()
+		fn()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { lock(func() { tm.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { lock(func() { tm.dns = time.Since(tm.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			lock(func() {
+				if tm.connStart.IsZero() {
+					tm.connStart = time.Now()
+				}
+			})
+		},
+		ConnectDone:       func(string, string, error) { lock(func() { tm.conn = time.Since(tm.connStart) }) },
+		TLSHandshakeStart: func() { lock(func() { tm.tlsAt = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func() { tm.tls = time.Since(tm.tlsAt) })
+		},
+		GotFirstResponseByte: func() { lock(func() { tm.wait = time.Since(tm.start) }) },
+	}
+}
+
+// Print writes timings summary to w. It does nothing if t is nil.
+func (t *Timings) Print(w io.Writer) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FETCH\tSOURCE\tDNS\tCONNECT\tTLS\tRESPONSE\tPARSE\tTOTAL\t")
+	var hits, misses int
+	for _, tm := range t.list {
+		tm.mu.Lock()
+		source := tm.source
+		if tm.err != nil {
+			source += " (" + tm.err.Error() + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%v\t%v\t%v\t%v\t%v\t\n", tm.name, source,
+			round(tm.dns), round(tm.conn), round(tm.tls), round(tm.wait), round(tm.parse), round(tm.total))
+		if tm.source == "cache" {
+			hits++
+		} else {
+			misses++
+		}
+		tm.mu.Unlock()
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "%d fetch(es), %d cache hit(s), %d miss(es), total time %v\n",
+		len(t.list), hits, misses, round(time.Since(t.start)))
+}
+
+func round(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
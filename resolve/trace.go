@@ -0,0 +1,30 @@
+package resolve
+
+import "context"
+
+// Span is a tracing span started by Config.StartSpan, i.e. an adapter of
+// OpenTelemetry span.
+type Span interface {
+	SetAttribute(key, value string)
+	End(err error) // err is what operation failed with, if any
+}
+
+// noSpan is used when tracing is not enabled.
+type noSpan struct{}
+
+func (noSpan) SetAttribute(key, value string) {}
+func (noSpan) End(error)                      {}
+
+// startSpan starts span with Config.StartSpan, setting attributes from
+// key, value pairs. If tracing is not enabled, it returns ctx as is, and a
+// no-op span.
+func (r *Resolver) startSpan(ctx context.Context, name string, attrs ...string) (context.Context, Span) {
+	if r.cfg.StartSpan == nil {
+		return ctx, noSpan{}
+	}
+	ctx, span := r.cfg.StartSpan(ctx, name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		span.SetAttribute(attrs[i], attrs[i+1])
+	}
+	return ctx, span
+}
@@ -0,0 +1,107 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// defaultVaultPath is secret path of Vault provider users without Path set:
+// "age-recipients" directory of KV version 2 engine mounted at "secret".
+const defaultVaultPath = "secret/data/age-recipients/%s"
+
+// vaultKeysField is the field of Vault KV secret holding user keys, unless
+// Path names another one.
+const vaultKeysField = "keys"
+
+var vaultNameRe = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+
+// fetchVaultKeys fetches keys of user from Vault provider p. Keys are read
+// from secret at p.Path, with %s replaced by user name, and optional
+// "#field" suffix naming the field holding them, "keys" by default. Field of
+// KV secret, of either engine version, holds newline-separated keys, or an
+// array of them. Responses that are not JSON are used as is, so Path may point
+// to SSH secrets engine CA public key, i.e. "%s/public_key" resolves
+// @ssh-client-signer@vault to the key of CA mounted at ssh-client-signer.
+func (r *Resolver) fetchVaultKeys(ctx context.Context, username string, p *Provider) ([]byte, error) {
+	path, field := p.Path, vaultKeysField
+	if path == "" {
+		path = defaultVaultPath
+	}
+	if i := strings.LastIndexByte(path, '#'); i >= 0 {
+		path, field = path[:i], path[i+1:]
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	u := p.APIURL("/" + strings.TrimPrefix(mirrorURL(path, username), "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrUserNotFound
+	default:
+		return nil, statusError(resp)
+	}
+	data, truncated, err := readLimited(resp.Body, r.cfg.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, fmt.Errorf("response is larger than max_response_size of %d bytes", r.cfg.MaxResponseSize)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return data, nil // plain keys list
+	}
+	return parseVaultSecret(data, field)
+}
+
+// parseVaultSecret returns newline-separated keys list from field of Vault
+// KV secret response.
+func parseVaultSecret(data []byte, field string) ([]byte, error) {
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, err
+	}
+	fields := secret.Data
+	if v2, ok := fields["data"]; ok && fields["metadata"] != nil {
+		// KV version 2 nests secret data
+		fields = nil
+		if err := json.Unmarshal(v2, &fields); err != nil {
+			return nil, fmt.Errorf("secret data: %w", err)
+		}
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("secret has no %q field", field)
+	}
+	var keys []string
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		keys = strings.Split(s, "\n")
+	} else if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, errors.New("secret " + field + " field is neither a string nor an array of strings")
+	}
+	var buf bytes.Buffer
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k != "" {
+			buf.WriteString(k + "\n")
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// resolver is resolve.Resolver along with settings and http client used by
+// subcommands.
+type resolver struct {
+	*resolve.Resolver
+	cfg     *config
+	client  *http.Client
+	timings *resolve.Timings // if set, fetches are timed
+	metrics *metrics
+	tracer  *tracer          // if set, spans are exported
+	tlog    *transparencyLog // if set, resolved bindings are logged
+
+	keyoxideMu sync.Mutex
+	keyoxide   map[string]error // results of verifyKeyoxide, by user and key
+}
+
+// newResolver returns resolver using settings from cfg. If daemon is not nil,
+// keys are fetched through daemon first.
+func newResolver(cfg *config, daemon *http.Client, timings *resolve.Timings) (*resolver, error) {
+	client, err := cfg.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	tr, err := newTracer(cfg, client)
+	if err != nil {
+		return nil, err
+	}
+	if tr != nil {
+		client = tracedClient(client, tr)
+		if daemon != nil {
+			daemon = tracedClient(daemon, tr)
+		}
+	}
+	rcfg := resolve.Config{
+		Providers:         cfg.Providers,
+		DefaultProvider:   cfg.DefaultProvider,
+		FallbackProviders: cfg.FallbackProviders,
+		Aliases:           cfg.Aliases,
+		Org:               cfg.Org,
+		KeyPolicy:         cfg.KeyPolicy,
+		MaxKeys:           cfg.MaxKeys,
+		MaxResponseSize:   cfg.MaxResponseSize,
+		Timeout:           cfg.Timeout,
+		CacheDir:          cfg.CacheDir,
+		SharedCacheDir:    cfg.SharedCacheDir,
+		KeysDir:           cfg.KeysDir,
+		PinMaxAge:         cfg.PinMaxAge,
+		Deny:              cfg.Deny,
+		KeyTypes:          cfg.KeyTypes,
+		RSAKeys:           cfg.RSAKeys,
+		OrgPolicy:         cfg.OrgPolicy,
+		ProfileRecipients: cfg.ProfileRecipients,
+		GistRecipients:    cfg.GistRecipients,
+		RequireOrg:        cfg.RequireOrg,
+		Require2FA:        cfg.Require2FA,
+		CacheTTL:          cfg.CacheTTL,
+		GroupCacheTTL:     cfg.GroupCacheTTL,
+		CacheMaxEntries:   cfg.CacheMaxEntries,
+		CacheMaxSize:      cfg.CacheMaxSize,
+		Client:            client,
+		Timings:           timings,
+		Warnf:             warnf,
+	}
+	m := newMetrics()
+	rcfg.Fetched = m.fetched
+	audit := []func(resolve.Event){m.resolved}
+	if cfg.AuditLog != "" {
+		log, err := openAuditLog(cfg.AuditLog)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		audit = append(audit, log.record)
+	}
+	var tlog *transparencyLog
+	if cfg.TransparencyLog != "" {
+		if tlog, err = newTransparencyLog(cfg, client); err != nil {
+			return nil, err
+		}
+		audit = append(audit, tlog.record)
+	}
+	rcfg.Audit = func(ev resolve.Event) {
+		for _, fn := range audit {
+			fn(ev)
+		}
+	}
+	r := &resolver{cfg: cfg, client: client, timings: timings, metrics: m, tracer: tr, tlog: tlog}
+	if tr != nil {
+		rcfg.StartSpan = tr.startSpan
+	}
+	if len(cfg.Keyoxide) != 0 {
+		rcfg.Verify = r.verifyKeyoxide
+	}
+	if cfg.Sigstore {
+		rcfg.VerifyFile = r.verifySigstore
+	}
+	if daemon != nil {
+		rcfg.Fetch = func(ctx context.Context, handle string) ([]byte, error) {
+			return daemonKeys(ctx, daemon, handle)
+		}
+	}
+	if r.Resolver, err = resolve.New(rcfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// recipients returns keys for a handle in authorized_keys format, see
+// resolve.Resolver.Recipients.
+func (r *resolver) recipients(ctx context.Context, handle string) ([]string, error) {
+	list, err := r.Recipients(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	return keyStrings(list), nil
+}
+
+// presentRecipients is like recipients, but users that don't exist or have no
+// usable keys, including group members, are skipped with a warning. It
+// returns number of skipped users.
+func (r *resolver) presentRecipients(ctx context.Context, handle string) ([]string, int, error) {
+	handle = r.cfg.Aliases.expand(handle)
+	handles := []string{handle}
+	if resolve.IsGroupHandle(handle) {
+		var err error
+		if handles, err = r.ExpandGroup(ctx, handle); err != nil {
+			return nil, 0, fmt.Errorf("expanding group %q: %w", handle, err)
+		}
+		r.Prefetch(ctx, handles)
+	}
+	var out []string
+	var skipped int
+	for _, h := range handles {
+		list, err := r.Resolve(ctx, h)
+		switch {
+		case isMissing(err):
+			warnf("%v, skipping", err)
+			skipped++
+			continue
+		case err != nil:
+			return nil, skipped, err
+		}
+		out = append(out, keyStrings(list)...)
+	}
+	return out, skipped, nil
+}
+
+// isMissing reports whether err tells that user does not exist, or has no
+// keys that can be used.
+func isMissing(err error) bool {
+	return errors.Is(err, resolve.ErrUserNotFound) || errors.Is(err, resolve.ErrUserSuspended) ||
+		errors.Is(err, resolve.ErrNoKeys) || errors.Is(err, resolve.ErrUnsupportedKeyType)
+}
+
+// keyStrings returns keys of recipients in authorized_keys format.
+func keyStrings(list []resolve.Recipient) []string {
+	out := make([]string, len(list))
+	for i, rc := range list {
+		out[i] = rc.String()
+	}
+	return out
+}
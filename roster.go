@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readRoster reads handles from a roster file, see scanHandles for its format.
+func readRoster(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []string
+	if err := scanHandles(f, func(handle string) error {
+		out = append(out, handle)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("%s:%w", name, err)
+	}
+	return out, nil
+}
+
+// scanHandles reads handles from r, one handle per line, with optional @
+// prefix, and calls fn for each of them. Empty lines and lines starting with
+// # are ignored, as are trailing # comments.
+func scanHandles(r io.Reader, fn func(handle string) error) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if strings.ContainsAny(line, " \t") {
+			return fmt.Errorf("%d: want a single handle per line", n)
+		}
+		if err := fn(strings.TrimPrefix(line, "@")); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runRotate re-encrypts files to freshly resolved keys of the given handles,
+// decrypting them with provided identities.
+func runRotate(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	var identities stringList
+	fs.Var(&identities, "i", "identity `file` to decrypt files with, may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github rotate -i identity file.age... @handle...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var files, handles []string
+	for _, arg := range fs.Args() {
+		if strings.HasPrefix(arg, "@") {
+			handles = append(handles, arg)
+		} else {
+			files = append(files, arg)
+		}
+	}
+	if len(identities) == 0 || len(files) == 0 || len(handles) == 0 {
+		fs.Usage()
+		return errors.New("identity, files, and handles are required")
+	}
+	// keys are likely rotated, so don't trust caches
+	keys, err := r.resolveList(resolve.WithoutCache(ctx), handles)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		_, armored, err := readAgeHeader(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := reencryptFile(ctx, r.cfg.Backend, name, armored, identities, keys); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
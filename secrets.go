@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// lazyTokens makes providers get tokens from secret managers when tokens are
+// first needed, see resolve.Provider.TokenFunc, so that runs not using
+// providers, i.e. decryption, don't call them: tokens configured as secret
+// references are replaced with secrets they refer to, see secretToken, and
+// providers with no token get it from token_command output, credential
+// helper, or OS keychain, see providerToken.
+func (c *config) lazyTokens() {
+	for name, p := range c.Providers {
+		var ref string
+		if isSecretRef(p.Token) {
+			ref, p.Token = p.Token, ""
+		}
+		if p.Token != "" || (ref == "" && c.TokenCommands[name] == "" && c.CredentialHelper == "" && !c.Keychain) {
+			continue
+		}
+		name, host := name, p.Host
+		p.TokenFunc = func() string { return c.providerToken(name, host, ref) }
+	}
+}
+
+// providerToken returns token of provider from the first source having one:
+// secret manager entry ref refers to, if not empty, token_command output,
+// credential helper, see credentialHelperToken, or OS keychain, if enabled.
+// Failures are reported as warnings.
+func (c *config) providerToken(name, host, ref string) string {
+	if ref != "" {
+		token, _, err := secretToken(ref)
+		if err == nil {
+			return token
+		}
+		warnf("getting %s token from %s: %v", host, ref, err)
+	}
+	if command := c.TokenCommands[name]; command != "" {
+		token, err := commandToken(command)
+		if err == nil {
+			return token
+		}
+		warnf("getting %s token from token command: %v", host, err)
+	}
+	if c.CredentialHelper != "" {
+		token, err := credentialHelperToken(c.CredentialHelper, host)
+		if err == nil && token != "" {
+			return token
+		}
+		if err != nil {
+			warnf("getting %s token from credential helper: %v", host, err)
+		}
+	}
+	if c.Keychain {
+		token, err := keychainGet(host)
+		if err == nil {
+			return token
+		}
+		warnf("reading %s token from keychain: %v", host, err)
+	}
+	return ""
+}
+
+// isSecretRef reports whether token is a reference to secret manager entry,
+// see secretToken.
+func isSecretRef(token string) bool {
+	for _, prefix := range []string{"op://", "pass:", "aws-sm:", "vault:"} {
+		if strings.HasPrefix(token, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretToken returns secret that ref refers to, if it's a reference to
+// a secret manager entry:
+//
+//	op://vault/item/field    1Password, read with op CLI
+//	pass:path                first line of pass entry
+//	aws-sm:secret-id[#key]   AWS Secrets Manager secret, or its JSON key
+//	vault:path[#field]       HashiCorp Vault KV secret field, "token" by default
+//
+// Otherwise, ok is false, and ref is a token itself.
+func secretToken(ref string) (token string, ok bool, err error) {
+	var cmd *exec.Cmd
+	var jsonKey string
+	switch {
+	case strings.HasPrefix(ref, "op://"):
+		cmd = exec.Command("op", "read", "--no-newline", ref)
+	case strings.HasPrefix(ref, "pass:"):
+		cmd = exec.Command("pass", "show", strings.TrimPrefix(ref, "pass:"))
+	case strings.HasPrefix(ref, "aws-sm:"):
+		id := strings.TrimPrefix(ref, "aws-sm:")
+		if i := strings.IndexByte(id, '#'); i >= 0 {
+			id, jsonKey = id[:i], id[i+1:]
+		}
+		cmd = exec.Command("aws", "secretsmanager", "get-secret-value",
+			"--secret-id", id, "--query", "SecretString", "--output", "text")
+	case strings.HasPrefix(ref, "vault:"):
+		path, field := strings.TrimPrefix(ref, "vault:"), "token"
+		if i := strings.IndexByte(path, '#'); i >= 0 {
+			path, field = path[:i], path[i+1:]
+		}
+		cmd = exec.Command("vault", "kv", "get", "-field="+field, path)
+	default:
+		return ref, false, nil
+	}
+	out, err := secretCommandOutput(cmd)
+	if err != nil {
+		return "", true, err
+	}
+	if jsonKey != "" {
+		var m map[string]interface{}
+		if err := json.Unmarshal(out, &m); err != nil {
+			return "", true, fmt.Errorf("secret is not a JSON object: %w", err)
+		}
+		s, ok := m[jsonKey].(string)
+		if !ok {
+			return "", true, fmt.Errorf("secret has no %q string key", jsonKey)
+		}
+		return s, true, nil
+	}
+	token, err = firstLine(out)
+	return token, true, err
+}
+
+// commandToken returns the first line printed by shell command, see
+// token_command setting.
+func commandToken(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	out, err := secretCommandOutput(cmd)
+	if err != nil {
+		return "", err
+	}
+	return firstLine(out)
+}
+
+// secretCommandOutput runs command, returning its output. Stdin is not
+// passed to command, as it may be input of age.
+func secretCommandOutput(cmd *exec.Cmd) ([]byte, error) {
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return out, nil
+}
+
+func firstLine(out []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if scanner.Scan() {
+		if s := strings.TrimSpace(scanner.Text()); s != "" {
+			return s, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("empty output")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runServe serves resolution requests over HTTP:
+//
+//	GET /v1/resolve/<handle>
+//
+// Response is a JSON object with "handle" and "recipients" fields, or an age
+// recipients file, if "format=recipients" query parameter is set, or if
+// request Accept header prefers text/plain.
+//
+// Metrics are served at /metrics, in Prometheus text format, and health
+// check at /healthz, see healthCheck. On SIGINT or SIGTERM, server stops
+// accepting connections, and waits for requests in flight to finish.
+//
+// Clients may be required to authenticate, and be rate limited, see
+// serveAuth.
+func runServe(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("listen", "localhost:8080", "`address` to listen at")
+	drain := fs.Duration("shutdown-timeout", 30*time.Second, "how long to wait for requests in flight on shutdown")
+	tokensFile := fs.String("tokens", "", "`file` with \"name token\" lines, clients must present one of tokens as bearer token")
+	certFile := fs.String("tls-cert", "", "TLS certificate `file`, if set, HTTPS is served")
+	keyFile := fs.String("tls-key", "", "TLS private key `file`")
+	clientCA := fs.String("client-ca", "", "CA certificates `file`, if set, clients must present certificates it signed")
+	rateLimit := fs.Int("rate-limit", 0, "max `requests` per minute per client, 0 means no limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: age-github serve [-listen addr] [-tokens file] [-tls-cert file -tls-key file [-client-ca file]] [-rate-limit n] [-shutdown-timeout duration]")
+	}
+	if (*certFile == "") != (*keyFile == "") || (*clientCA != "" && *certFile == "") {
+		return errors.New("-tls-cert and -tls-key must be set together, and are required by -client-ca")
+	}
+	auth := &serveAuth{clientCert: *clientCA != "", limit: *rateLimit}
+	if *tokensFile != "" {
+		var err error
+		if auth.tokens, err = loadServeTokens(*tokensFile); err != nil {
+			return err
+		}
+	}
+	if host, _, err := net.SplitHostPort(*addr); err == nil && auth.tokens == nil && !auth.clientCert {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			warnf("serving on %s without client authentication, anyone who can connect uses configured tokens; see -tokens and -client-ca flags", *addr)
+		}
+	}
+	h := &healthCheck{r: r}
+	api := http.NewServeMux()
+	api.HandleFunc("/v1/resolve/", r.metrics.instrument("resolve", r.tracer.handler("GET /v1/resolve/", r.handleResolve)))
+	api.HandleFunc("/metrics", r.handleMetrics)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.serveHTTP) // load balancers don't authenticate
+	mux.Handle("/", auth.wrap(api))
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if auth.clientCert {
+		var err error
+		if srv.TLSConfig, err = tlsConfig(*clientCA); err != nil {
+			return err
+		}
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() {
+		<-sigCh
+		h.setDraining()
+		sctx, cancel := context.WithTimeout(context.Background(), *drain)
+		defer cancel()
+		done <- srv.Shutdown(sctx)
+	}()
+	var err error
+	if *certFile != "" {
+		err = srv.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
+
+// healthCheckInterval is how long result of health check is reused, so that
+// frequent probes of load balancers don't hit providers.
+const healthCheckInterval = 30 * time.Second
+
+// healthCheckTimeout limits how long a single health check runs.
+const healthCheckTimeout = 20 * time.Second
+
+// healthCheck serves /healthz: it checks that cache directory is writable,
+// and that providers can be reached and accept their tokens, see doctor
+// subcommand. Once server starts shutting down, it always fails, so that load
+// balancers stop sending requests while ones in flight are finished.
+//
+// As /healthz is served without authentication, it only responds with "ok" or
+// "fail", failed checks are logged.
+type healthCheck struct {
+	r *resolver
+
+	mu       sync.Mutex
+	draining bool
+	checked  time.Time
+	ok       bool
+	running  chan struct{} // closed once check in progress finishes, nil if none runs
+}
+
+func (h *healthCheck) setDraining() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.draining = true
+}
+
+func (h *healthCheck) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	ok, draining := h.status(req.Context())
+	switch {
+	case draining:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case !ok:
+		http.Error(w, "fail", http.StatusServiceUnavailable)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// status returns result of the last check, running a new one if it is older
+// than healthCheckInterval. Concurrent calls share a single check, which runs
+// with its own timeout, so that it completes and its result is reused even if
+// callers give up waiting for it.
+func (h *healthCheck) status(ctx context.Context) (ok, draining bool) {
+	h.mu.Lock()
+	if h.draining || time.Since(h.checked) <= healthCheckInterval {
+		defer h.mu.Unlock()
+		return h.ok, h.draining
+	}
+	if h.running == nil {
+		h.running = make(chan struct{})
+		go h.run(h.running)
+	}
+	running := h.running
+	h.mu.Unlock()
+	select {
+	case <-running:
+	case <-ctx.Done():
+		return false, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ok, h.draining
+}
+
+// run runs checks, storing their result, and closes done.
+func (h *healthCheck) run(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	ok := h.check(ctx)
+	h.mu.Lock()
+	h.ok, h.checked, h.running = ok, time.Now(), nil
+	h.mu.Unlock()
+	close(done)
+}
+
+// check runs checks, logging failed ones, and reports whether all of them
+// passed.
+func (h *healthCheck) check(ctx context.Context) bool {
+	ok := true
+	if _, err := checkCacheDir(h.r.cfg.CacheDir); err != nil {
+		warnf("health check: cache: %v", err)
+		ok = false
+	}
+	names := make([]string, 0, len(h.r.cfg.Providers))
+	for name := range h.r.cfg.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := h.r.checkProvider(ctx, h.r.cfg.Providers[name]); err != nil {
+			warnf("health check: %s provider: %v", name, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (r *resolver) handleResolve(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	handle := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/v1/resolve/"), "@")
+	if handle == "" {
+		http.Error(w, "empty handle", http.StatusBadRequest)
+		return
+	}
+	list, err := r.Recipients(req.Context(), handle)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatusCode(err))
+		return
+	}
+	format := req.URL.Query().Get("format")
+	if format == "" && strings.HasPrefix(req.Header.Get("Accept"), "text/plain") {
+		format = "recipients"
+	}
+	switch format {
+	case "", "json":
+		resp := struct {
+			Handle       string   `json:"handle"`
+			Recipients   []string `json:"recipients"`
+			Fingerprints []string `json:"fingerprints"`
+		}{Handle: handle, Recipients: keyStrings(list)}
+		for _, rc := range list {
+			resp.Fingerprints = append(resp.Fingerprints, rc.Fingerprint)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	case "recipients":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "# @%s\n", handle)
+		for _, rc := range list {
+			fmt.Fprintln(w, rc)
+		}
+	default:
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+	}
+}
+
+// errorStatusCode returns HTTP status code matching resolution error.
+func errorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, resolve.ErrInvalidHandle), errors.Is(err, resolve.ErrUnknownProvider):
+		return http.StatusBadRequest
+	case errors.Is(err, resolve.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, resolve.ErrUserSuspended):
+		return http.StatusGone
+	case errors.Is(err, resolve.ErrNoKeys), errors.Is(err, resolve.ErrUnsupportedKeyType), errors.Is(err, resolve.ErrKeyTypeNotAllowed),
+		errors.Is(err, resolve.ErrPolicyViolation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, resolve.ErrUserDenied), errors.Is(err, resolve.ErrKeysDenied), errors.Is(err, resolve.ErrNotOrgMember),
+		errors.Is(err, resolve.ErrNo2FA):
+		return http.StatusForbidden
+	case errors.Is(err, resolve.ErrRateLimited):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serveAuth authenticates and rate limits clients of serve subcommand, as it
+// effectively proxies provider tokens, and must not be an open relay.
+//
+// Clients authenticate with bearer tokens listed in a tokens file, and, if
+// server requires them, with TLS client certificates, see tlsConfig. Each
+// client, identified by token name, certificate subject, or remote address,
+// if neither is required, is allowed limit requests per minute.
+type serveAuth struct {
+	tokens     map[[sha256.Size]byte]string // client names by token hash
+	clientCert bool                         // whether client certificates are required
+	limit      int                          // requests per minute per client, 0 means no limit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxBuckets is how many clients are tracked before idle ones are forgotten.
+const maxBuckets = 10000
+
+// loadServeTokens reads tokens file, holding "name token" lines, where name
+// identifies client. Empty lines and lines starting with # are ignored.
+func loadServeTokens(name string) (map[[sha256.Size]byte]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tokens := make(map[[sha256.Size]byte]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"name token\" line", name, n)
+		}
+		tokens[sha256.Sum256([]byte(fields[1]))] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", name)
+	}
+	return tokens, nil
+}
+
+// tlsConfig returns server TLS config verifying client certificates against
+// CA from caFile. Certificates are only required by serveAuth, so that health
+// checks don't need them.
+func tlsConfig(caFile string) (*tls.Config, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates found", caFile)
+	}
+	return &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}, nil
+}
+
+// wrap returns handler authenticating and rate limiting requests before
+// passing them to h.
+func (a *serveAuth) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client, err := a.authenticate(req)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="age-github"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if wait := a.allow(client); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// authenticate returns client name.
+func (a *serveAuth) authenticate(req *http.Request) (string, error) {
+	var client string
+	if a.clientCert {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+			return "", errors.New("client certificate required")
+		}
+		client = req.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	if a.tokens != nil {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return "", errors.New("bearer token required")
+		}
+		name, ok := a.tokens[sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))]
+		if !ok {
+			return "", errors.New("invalid token")
+		}
+		client = name
+	}
+	if client == "" {
+		client, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+	return client, nil
+}
+
+// allow takes a request from client's bucket, returning how long to wait if
+// it's empty.
+func (a *serveAuth) allow(client string) time.Duration {
+	if a.limit <= 0 {
+		return 0
+	}
+	rate := float64(a.limit) / float64(time.Minute) // tokens per nanosecond
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.buckets == nil {
+		a.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := a.buckets[client]
+	if !ok {
+		if len(a.buckets) >= maxBuckets {
+			// buckets refilled since are the same as new ones
+			for name, b := range a.buckets {
+				if now.Sub(b.last) >= time.Minute {
+					delete(a.buckets, name)
+				}
+			}
+		}
+		b = &tokenBucket{tokens: float64(a.limit), last: now}
+		a.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(a.limit), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate)
+	}
+	b.tokens--
+	return 0
+}
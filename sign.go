@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// Signatures of bundles, rosters and recipients files are detached, stored
+// next to signed file: "file.sig" made with ssh-keygen -Y sign, or
+// "file.minisig" made with minisign.
+const (
+	sshSigSuffix      = ".sig"
+	minisignSigSuffix = ".minisig"
+	sigNamespace      = "age-github" // ssh signature namespace
+)
+
+// runSign signs files with ssh or minisign key.
+func runSign(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	sshKey := fs.String("ssh-key", "", "ssh private key `file` to sign with")
+	minisignKey := fs.String("minisign", "", "minisign secret key `file` to sign with")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github sign -ssh-key ~/.ssh/id_ed25519|-minisign key.sec file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || (*sshKey == "") == (*minisignKey == "") {
+		fs.Usage()
+		return errors.New("files and exactly one of -ssh-key and -minisign are required")
+	}
+	for _, name := range fs.Args() {
+		if err := signFile(ctx, name, *sshKey, *minisignKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signFile writes detached signature of file made with either ssh or
+// minisign key next to it.
+func signFile(ctx context.Context, name, sshKey, minisignKey string) error {
+	var cmd *exec.Cmd
+	if sshKey != "" {
+		cmd = exec.CommandContext(ctx, "ssh-keygen", "-Y", "sign", "-f", sshKey, "-n", sigNamespace, name)
+	} else {
+		cmd = exec.CommandContext(ctx, "minisign", "-S", "-s", minisignKey, "-m", name)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("signing %s: %w", name, err)
+	}
+	return nil
+}
+
+// runVerify verifies signatures of files, see verifySignature.
+func runVerify(ctx context.Context, r *resolver, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: age-github verify file...")
+	}
+	for _, name := range args {
+		signer, err := r.verifySignature(ctx, name)
+		if err != nil {
+			return err
+		}
+		if signer == "" {
+			return fmt.Errorf("%s is not signed", name)
+		}
+		fmt.Printf("%s: signed by %s\n", name, signer)
+	}
+	return nil
+}
+
+// verifySignature verifies detached signature of file, if it has one, and
+// returns who signed it. Ssh signatures are verified against keys published by
+// users from "signers" setting, minisign ones against "minisign_key" setting.
+// If file has no signature, it returns an empty string, or an error if
+// "require_signature" setting is enabled.
+func (r *resolver) verifySignature(ctx context.Context, name string) (string, error) {
+	switch {
+	case fileExists(name + sshSigSuffix):
+		return r.verifySSHSignature(ctx, name)
+	case fileExists(name + minisignSigSuffix):
+		if r.cfg.MinisignKey == "" {
+			return "", fmt.Errorf("%s is signed with minisign, but minisign_key setting is empty", name)
+		}
+		cmd := exec.CommandContext(ctx, "minisign", "-V", "-q", "-P", r.cfg.MinisignKey, "-m", name, "-x", name+minisignSigSuffix)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("%s: minisign signature verification failed: %v: %s", name, err, bytes.TrimSpace(out))
+		}
+		return "minisign key " + r.cfg.MinisignKey, nil
+	case r.cfg.RequireSignature:
+		return "", fmt.Errorf("%s is not signed, and require_signature setting is enabled", name)
+	}
+	return "", nil
+}
+
+func (r *resolver) verifySSHSignature(ctx context.Context, name string) (string, error) {
+	if len(r.cfg.Signers) == 0 {
+		return "", fmt.Errorf("%s is signed with ssh key, but signers setting is empty", name)
+	}
+	var buf bytes.Buffer
+	for _, handle := range r.cfg.Signers {
+		h := r.ExpandAlias(handle)
+		users := []string{h}
+		if resolve.IsGroupHandle(h) {
+			var err error
+			if users, err = r.ExpandGroup(ctx, h); err != nil {
+				return "", fmt.Errorf("expanding signers group %q: %w", h, err)
+			}
+		}
+		for _, u := range users {
+			keys, err := r.signerKeys(ctx, u)
+			if err != nil {
+				return "", fmt.Errorf("fetching keys of signer @%s: %w", u, err)
+			}
+			for _, k := range keys {
+				fmt.Fprintf(&buf, "@%s namespaces=%q %s\n", displayHandle(u, r.cfg.DefaultProvider), sigNamespace, k)
+			}
+		}
+	}
+	f, err := ioutil.TempFile("", "age-github-signers-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	sig := name + sshSigSuffix
+	out, err := exec.CommandContext(ctx, "ssh-keygen", "-Y", "find-principals", "-f", f.Name(), "-s", sig).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not signed by any of signers", name)
+	}
+	principal := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	data, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer data.Close()
+	cmd := exec.CommandContext(ctx, "ssh-keygen", "-Y", "verify", "-f", f.Name(), "-I", principal, "-n", sigNamespace, "-s", sig)
+	cmd.Stdin = data
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%s: ssh signature verification failed: %v: %s", name, err, bytes.TrimSpace(out))
+	}
+	return principal, nil
+}
+
+// signerKeys returns both authentication and signing keys of user.
+func (r *resolver) signerKeys(ctx context.Context, handle string) ([]string, error) {
+	list, err := r.FetchRecipients(ctx, handle)
+	if err != nil && !errors.Is(err, resolve.ErrNoKeys) {
+		return nil, err
+	}
+	var keys []string
+	for _, rc := range list {
+		if !resolve.IsAgeRecipient(rc.PublicKey) {
+			keys = append(keys, rc.PublicKey)
+		}
+	}
+	signing, err := r.signingKeys(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueStrings(append(keys, signing...)), nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// verifySigstore verifies Sigstore bundle of recipients file fetched from a
+// repository or gist of owner with cosign. Signature must be keyless, made
+// from GitHub Actions workflow of a repository of owner, so that it attests
+// file comes from owner account. Unsigned files are only refused with
+// require_signature setting.
+func (r *resolver) verifySigstore(ctx context.Context, p *resolve.Provider, owner, name string, data, bundle []byte) error {
+	if bundle == nil {
+		if r.cfg.RequireSignature {
+			return errors.New("file has no Sigstore signature, and require_signature setting is enabled")
+		}
+		return nil
+	}
+	issuer := "https://" + p.Host + "/_services/token"
+	if p.Host == "github.com" {
+		issuer = "https://token.actions.githubusercontent.com"
+	}
+	dir, err := ioutil.TempDir("", "age-github-sigstore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file, bundleFile := filepath.Join(dir, "file"), filepath.Join(dir, "bundle")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(bundleFile, bundle, 0600); err != nil {
+		return err
+	}
+	identity := "^https://" + regexp.QuoteMeta(p.Host) + "/(?i:" + regexp.QuoteMeta(owner) + ")/"
+	cmd := exec.CommandContext(ctx, "cosign", "verify-blob", "--bundle", bundleFile,
+		"--certificate-oidc-issuer", issuer, "--certificate-identity-regexp", identity, file)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Sigstore signature verification failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
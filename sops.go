@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+	"gopkg.in/yaml.v3"
+)
+
+// runSopsConfig resolves handles to age recipients and writes them into
+// creation_rules of .sops.yaml file, optionally running "sops updatekeys" on
+// files matching the rule.
+func runSopsConfig(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("sops-config", flag.ContinueOnError)
+	file := fs.String("file", ".sops.yaml", "sops config `file` to create or update")
+	pathRegex := fs.String("path-regex", "", "path_regex of the creation rule to update, empty for the catch-all rule")
+	updateKeys := fs.Bool("updatekeys", false, "run \"sops updatekeys\" on files matching -path-regex")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github sops-config [flags] @handle...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *updateKeys && *pathRegex == "" {
+		return errors.New("-updatekeys requires -path-regex")
+	}
+	var re *regexp.Regexp
+	if *pathRegex != "" {
+		var err error
+		if re, err = regexp.Compile(*pathRegex); err != nil {
+			return fmt.Errorf("-path-regex: %w", err)
+		}
+	}
+	handles, err := collectHandles(fs.Args(), os.Stdin)
+	if err != nil {
+		return err
+	}
+	r.Prefetch(ctx, handles)
+	var recipients []string
+	for _, h := range handles {
+		keys, err := r.recipients(ctx, h)
+		if err != nil {
+			return err
+		}
+		var n int
+		for _, k := range keys {
+			rcpt, err := sopsRecipient(k)
+			if err != nil {
+				warnf("@%s: skipping key %s: %v", h, resolve.Fingerprint(k), err)
+				continue
+			}
+			recipients = append(recipients, rcpt)
+			n++
+		}
+		if n == 0 {
+			return fmt.Errorf("@%s has no keys usable as sops age recipients, only ssh-ed25519 keys can be converted", h)
+		}
+	}
+	recipients = uniqueStrings(recipients)
+	if err := updateSopsConfig(*file, *pathRegex, recipients, handles); err != nil {
+		return err
+	}
+	if !*updateKeys {
+		return nil
+	}
+	return sopsUpdateKeys(filepath.Dir(*file), re)
+}
+
+// sopsRecipient converts key to age recipient that sops can use.
+func sopsRecipient(key string) (string, error) {
+	if strings.HasPrefix(key, "age1") {
+		return key, nil
+	}
+	return sshToAgeRecipient(key)
+}
+
+// updateSopsConfig sets age recipients of creation rule with the given
+// path_regex (or the rule without path_regex, if pathRegex is empty) in sops
+// config file, adding the rule if necessary. Other content of the file is
+// preserved.
+func updateSopsConfig(name, pathRegex string, recipients, handles []string) error {
+	var doc yaml.Node
+	data, err := ioutil.ReadFile(name)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	case os.IsNotExist(err):
+	default:
+		return err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: top level is not a mapping", name)
+	}
+	rules := mappingValue(root, "creation_rules")
+	if rules == nil {
+		rules = &yaml.Node{Kind: yaml.SequenceNode}
+		root.Content = append(root.Content, scalarNode("creation_rules"), rules)
+	}
+	if rules.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s: creation_rules is not a list", name)
+	}
+	var rule *yaml.Node
+	catchAll := -1 // index of the first rule without path_regex
+	for i, n := range rules.Content {
+		if n.Kind != yaml.MappingNode {
+			continue
+		}
+		re := mappingValue(n, "path_regex")
+		if re == nil && catchAll < 0 {
+			catchAll = i
+		}
+		if (re == nil && pathRegex == "") || (re != nil && re.Value == pathRegex) {
+			rule = n
+			break
+		}
+	}
+	if rule == nil {
+		rule = &yaml.Node{Kind: yaml.MappingNode}
+		if pathRegex != "" {
+			rule.Content = append(rule.Content, scalarNode("path_regex"), scalarNode(pathRegex))
+		}
+		// rules are matched in order, so rules with path_regex go before
+		// the catch-all one
+		if pathRegex != "" && catchAll >= 0 {
+			rules.Content = append(rules.Content[:catchAll], append([]*yaml.Node{rule}, rules.Content[catchAll:]...)...)
+		} else {
+			rules.Content = append(rules.Content, rule)
+		}
+	}
+	value := scalarNode(strings.Join(recipients, ","))
+	value.LineComment = "@" + strings.Join(handles, " @")
+	if v := mappingValue(rule, "age"); v != nil {
+		*v = *value
+	} else {
+		rule.Content = append(rule.Content, scalarNode("age"), value)
+	}
+	buf := new(bytes.Buffer)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, buf.Bytes(), 0666)
+}
+
+// mappingValue returns value node of the given key in a mapping node, or nil
+// if there's no such key.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarNode(s string) *yaml.Node { return &yaml.Node{Kind: yaml.ScalarNode, Value: s} }
+
+// sopsUpdateKeys runs "sops updatekeys" on every file under dir which path
+// relative to dir matches re.
+func sopsUpdateKeys(dir string, re *regexp.Regexp) error {
+	sops, err := exec.LookPath("sops")
+	if err != nil {
+		return err
+	}
+	var failed int
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || !info.Mode().IsRegular() || !re.MatchString(filepath.ToSlash(rel)) {
+			return err
+		}
+		cmd := exec.Command(sops, "updatekeys", "-y", path)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			warnf("sops updatekeys %s: %v", path, err)
+			failed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed != 0 {
+		return fmt.Errorf("sops updatekeys failed for %d file(s)", failed)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifest is a checked-in declaration of who can decrypt which files:
+//
+//	rules:
+//	  - files: ["secrets/**/*.age"]
+//	    recipients: ["@alice", "@team:corp/sre", "age1..."]
+//
+// File globs are relative to manifest directory, "**" matches any number of
+// directories. Each file is governed by the first rule matching it.
+type manifest struct {
+	Rules []manifestRule `yaml:"rules"`
+}
+
+type manifestRule struct {
+	Files      []string `yaml:"files"`
+	Recipients []string `yaml:"recipients"`
+}
+
+func readManifest(name string) (*manifest, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	m := new(manifest)
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for i, rule := range m.Rules {
+		if len(rule.Files) == 0 || len(rule.Recipients) == 0 {
+			return nil, fmt.Errorf("%s: rule #%d must have both files and recipients", name, i+1)
+		}
+		for _, g := range rule.Files {
+			if _, err := path.Match(g, ""); err != nil {
+				return nil, fmt.Errorf("%s: rule #%d: %q: %w", name, i+1, g, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// match returns index of the first rule matching file name, which must be
+// slash-separated and relative to manifest directory, or -1.
+func (m *manifest) match(name string) int {
+	for i, rule := range m.Rules {
+		for _, g := range rule.Files {
+			if matchGlob(g, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// runSync verifies that files governed by manifest are encrypted to the
+// recipients it declares, and optionally re-encrypts those that are not.
+func runSync(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	manifestFile := fs.String("manifest", ".age-github.yaml", "manifest `file`")
+	reencrypt := fs.Bool("reencrypt", false, "re-encrypt files not matching manifest")
+	var identities stringList
+	fs.Var(&identities, "i", "identity `file` to decrypt files with when re-encrypting, may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: age-github sync [-manifest file] [-reencrypt -i identity]")
+	}
+	if *reencrypt && len(identities) == 0 {
+		return errors.New("-reencrypt requires at least one -i identity")
+	}
+	m, err := readManifest(*manifestFile)
+	if err != nil {
+		return err
+	}
+	ruleKeys := make([][]string, len(m.Rules)) // resolved on first use
+	dir := filepath.Dir(*manifestFile)
+	var stale, failed int
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		i := m.match(filepath.ToSlash(rel))
+		if i < 0 || !info.Mode().IsRegular() {
+			return nil
+		}
+		if ruleKeys[i] == nil {
+			if ruleKeys[i], err = r.resolveList(ctx, m.Rules[i].Recipients); err != nil {
+				return fmt.Errorf("rule #%d: %w", i+1, err)
+			}
+		}
+		stanzas, armored, err := readAgeHeader(name)
+		if err != nil {
+			warnf("%s: %v", name, err)
+			failed++
+			return nil
+		}
+		if sameRecipients(ruleKeys[i], stanzas) {
+			return nil
+		}
+		stale++
+		if !*reencrypt {
+			fmt.Printf("%s: recipients differ from manifest\n", name)
+			return nil
+		}
+		if err := reencryptFile(ctx, r.cfg.Backend, name, armored, identities, ruleKeys[i]); err != nil {
+			warnf("%s: %v", name, err)
+			failed++
+			return nil
+		}
+		fmt.Printf("%s: re-encrypted\n", name)
+		return nil
+	})
+	switch {
+	case err != nil:
+		return err
+	case failed != 0:
+		return fmt.Errorf("%d file(s) failed", failed)
+	case stale != 0 && !*reencrypt:
+		return fmt.Errorf("%d file(s) have recipients different from manifest", stale)
+	}
+	return nil
+}
+
+// resolveList resolves a list of recipients, which are either @handles or
+// literal keys.
+func (r *resolver) resolveList(ctx context.Context, recipients []string) ([]string, error) {
+	var handles []string
+	for _, s := range recipients {
+		if strings.HasPrefix(s, "@") {
+			handles = append(handles, s[1:])
+		}
+	}
+	r.Prefetch(ctx, handles)
+	var out []string
+	for _, s := range recipients {
+		if !strings.HasPrefix(s, "@") {
+			out = append(out, s)
+			continue
+		}
+		keys, err := r.recipients(ctx, s[1:])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, keys...)
+	}
+	return uniqueStrings(out), nil
+}
+
+// sameRecipients reports whether file header stanzas match keys.
+func sameRecipients(keys []string, stanzas []stanza) bool {
+	var want, got []string
+	for _, k := range keys {
+		want = append(want, recipientID(k))
+	}
+	for _, s := range stanzas {
+		got = append(got, stanzaID(s))
+	}
+	if len(want) != len(got) {
+		return false
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	for i := range want {
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reencryptFile decrypts file with identities and encrypts it again to keys,
+// replacing the original file.
+func reencryptFile(ctx context.Context, backend, name string, armored bool, identities, keys []string) error {
+	ageBin, err := exec.LookPath(backend)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".age-github-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	decArgs := []string{"-d"}
+	for _, id := range identities {
+		decArgs = append(decArgs, "-i", id)
+	}
+	decArgs = append(decArgs, name)
+	encArgs := []string{"-e"}
+	if armored {
+		encArgs = append(encArgs, "-a")
+	}
+	for _, k := range keys {
+		encArgs = append(encArgs, "-r", k)
+	}
+	dec := exec.CommandContext(ctx, ageBin, decArgs...)
+	enc := exec.CommandContext(ctx, ageBin, encArgs...)
+	dec.Stderr, enc.Stderr = os.Stderr, os.Stderr
+	enc.Stdout = tmp
+	if enc.Stdin, err = dec.StdoutPipe(); err != nil {
+		return err
+	}
+	if err := enc.Start(); err != nil {
+		return err
+	}
+	if err := dec.Run(); err != nil {
+		_ = enc.Wait()
+		return fmt.Errorf("decrypting: %w", err)
+	}
+	if err := enc.Wait(); err != nil {
+		return fmt.Errorf("encrypting: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(name); err == nil {
+		_ = os.Chmod(tmp.Name(), fi.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// matchGlob reports whether slash-separated name matches pattern, where "**"
+// path element matches any number of directories.
+func matchGlob(pattern, name string) bool {
+	if pattern == "**" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "**/") {
+		rest := pattern[3:]
+		for {
+			if matchGlob(rest, name) {
+				return true
+			}
+			i := strings.IndexByte(name, '/')
+			if i < 0 {
+				return false
+			}
+			name = name[i+1:]
+		}
+	}
+	i := strings.IndexByte(pattern, '/')
+	if i < 0 {
+		ok, _ := path.Match(pattern, name)
+		return ok && !strings.Contains(name, "/")
+	}
+	j := strings.IndexByte(name, '/')
+	if j < 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[:i], name[:j]); !ok {
+		return false
+	}
+	return matchGlob(pattern[i+1:], name[j+1:])
+}
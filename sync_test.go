@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.age", "a.age", true},
+		{"*.age", "dir/a.age", false},
+		{"dir/*.age", "dir/a.age", true},
+		{"dir/*.age", "dir/sub/a.age", false},
+		{"*/a.age", "dir/a.age", true},
+		{"**", "a/b/c.age", true},
+		{"**/*.age", "a.age", true},
+		{"**/*.age", "a/b/c.age", true},
+		{"**/*.age", "a/b/c.txt", false},
+		{"secrets/**/*.age", "secrets/a.age", true},
+		{"secrets/**/*.age", "secrets/x/y/a.age", true},
+		{"secrets/**/*.age", "other/x/a.age", false},
+		{"secrets/**", "secrets/x/a.age", true},
+		{"a/**/b/*.age", "a/x/y/b/c.age", true},
+		{"a/**/b/*.age", "a/b/c.age", true},
+		{"a/**/b/*.age", "a/x/c.age", false},
+		{"[ab].age", "b.age", true},
+		{"?.age", "ab.age", false},
+		{"dir", "dir/a.age", false},
+	} {
+		if got := matchGlob(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// publicRekor is the public Rekor instance used by "rekor" transparency_log
+// setting.
+const publicRekor = "https://rekor.sigstore.dev"
+
+// transparencyLog records hashes of handle to keys bindings of successful
+// resolutions to Rekor transparency log, so that keys substituted for those
+// of a user, i.e. by compromised provider or network, leave a trace that can
+// be neither altered nor removed, see bindingStatement.
+//
+// Entries are hashedrekord ones signed with ECDSA key kept in cache
+// directory, so entries logged from one machine can be found by its public
+// key. Bindings already logged from the machine within
+// transparencyMarkerTTL are not logged again.
+//
+// Entries are submitted in background, so resolution doesn't wait for the
+// log; flush waits for queued ones to be submitted.
+type transparencyLog struct {
+	url     string
+	client  *http.Client
+	timeout time.Duration
+	dir     string            // where key and logged bindings are kept, if empty, key is ephemeral
+	hosts   map[string]string // provider hosts, by provider name
+	key     *ecdsa.PrivateKey
+	pubPEM  []byte
+	queue   chan transparencyEntry
+
+	mu      sync.Mutex
+	idle    *sync.Cond      // signalled when pending drops to zero
+	logged  map[string]bool // hashes of bindings logged or queued by this process
+	pending int             // entries queued or being submitted
+	dropped int             // entries dropped, as queue was full
+}
+
+// transparencyEntry is a binding queued for submission.
+type transparencyEntry struct {
+	hash, user, provider string
+	digest               []byte
+}
+
+const (
+	// transparencyQueueSize is how many entries can wait for submission,
+	// entries over it are dropped.
+	transparencyQueueSize = 256
+
+	// transparencyMarkerTTL is how long a binding logged from the machine
+	// is not logged again. Older markers are removed.
+	transparencyMarkerTTL = 30 * 24 * time.Hour
+)
+
+func newTransparencyLog(cfg *config, client *http.Client) (*transparencyLog, error) {
+	t := &transparencyLog{
+		url:     strings.TrimSuffix(cfg.TransparencyLog, "/"),
+		client:  client,
+		timeout: cfg.Timeout,
+		hosts:   make(map[string]string, len(cfg.Providers)),
+		queue:   make(chan transparencyEntry, transparencyQueueSize),
+		logged:  make(map[string]bool),
+	}
+	t.idle = sync.NewCond(&t.mu)
+	if t.url == "rekor" {
+		t.url = publicRekor
+	}
+	for name, p := range cfg.Providers {
+		t.hosts[name] = p.Host
+	}
+	if cfg.CacheDir != "" {
+		t.dir = filepath.Join(cfg.CacheDir, "transparency")
+	}
+	if err := t.loadKey(); err != nil {
+		return nil, fmt.Errorf("transparency log key: %w", err)
+	}
+	t.prune()
+	go t.run()
+	return t, nil
+}
+
+// loadKey loads signing key from directory, creating one if there's none.
+func (t *transparencyLog) loadKey() error {
+	name := filepath.Join(t.dir, "key.pem")
+	if t.dir != "" {
+		data, err := ioutil.ReadFile(name)
+		switch {
+		case err == nil:
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return fmt.Errorf("%s: no PEM data", name)
+			}
+			if t.key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+	}
+	if t.key == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		t.key = key
+		if t.dir != "" {
+			der, err := x509.MarshalECPrivateKey(key)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(t.dir, 0700); err != nil {
+				return err
+			}
+			data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+			if err := ioutil.WriteFile(name, data, 0600); err != nil {
+				return err
+			}
+		}
+	}
+	der, err := x509.MarshalPKIXPublicKey(&t.key.PublicKey)
+	if err != nil {
+		return err
+	}
+	t.pubPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return nil
+}
+
+// bindingStatement returns statement of binding of user to keys, which hash
+// is logged. It's reproducible, so whoever knows user and fingerprints, i.e.
+// from audit log, can find the entry by hash:
+//
+//	age-github binding v1
+//	host: github.com
+//	user: alice
+//	keys: SHA256:... SHA256:...
+func bindingStatement(host, user string, keys []string) []byte {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	return []byte(fmt.Sprintf("age-github binding v1\nhost: %s\nuser: %s\nkeys: %s\n",
+		host, strings.ToLower(user), strings.Join(keys, " ")))
+}
+
+// record queues binding of successful resolution to be logged, reporting
+// failures as warnings, as resolution itself has already happened.
+func (t *transparencyLog) record(ev resolve.Event) {
+	if ev.Err != nil || len(ev.Keys) == 0 {
+		return
+	}
+	sum := sha256.Sum256(bindingStatement(t.hosts[ev.Provider], ev.User, ev.Keys))
+	hash := hex.EncodeToString(sum[:])
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.logged[hash] {
+		return
+	}
+	t.logged[hash] = true
+	if t.dir != "" {
+		if fi, err := os.Stat(filepath.Join(t.dir, hash)); err == nil && time.Since(fi.ModTime()) < transparencyMarkerTTL {
+			return
+		}
+	}
+	select {
+	case t.queue <- transparencyEntry{hash: hash, user: ev.User, provider: ev.Provider, digest: sum[:]}:
+		t.pending++
+	default:
+		delete(t.logged, hash)
+		t.dropped++
+	}
+}
+
+// run submits queued entries, marking logged bindings in directory.
+func (t *transparencyLog) run() {
+	for e := range t.queue {
+		if err := t.submit(e.digest); err != nil {
+			warnf("transparency log: recording binding of @%s@%s: %v", e.user, e.provider, err)
+			t.mu.Lock()
+			delete(t.logged, e.hash) // so it's retried on next resolution
+			t.mu.Unlock()
+		} else if t.dir != "" {
+			if err := ioutil.WriteFile(filepath.Join(t.dir, e.hash), nil, 0600); err != nil {
+				warnf("transparency log: %v", err)
+			}
+		}
+		t.mu.Lock()
+		if t.pending--; t.pending == 0 {
+			t.idle.Broadcast()
+		}
+		t.mu.Unlock()
+	}
+}
+
+// prune removes markers of bindings logged more than transparencyMarkerTTL
+// ago.
+func (t *transparencyLog) prune() {
+	if t.dir == "" {
+		return
+	}
+	entries, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range entries {
+		if len(fi.Name()) != sha256.Size*2 || !fi.Mode().IsRegular() || time.Since(fi.ModTime()) < transparencyMarkerTTL {
+			continue
+		}
+		if _, err := hex.DecodeString(fi.Name()); err == nil {
+			_ = os.Remove(filepath.Join(t.dir, fi.Name()))
+		}
+	}
+}
+
+// flush waits for queued entries to be submitted, i.e. before exit, or
+// before process is replaced with age.
+func (t *transparencyLog) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.pending != 0 {
+		t.idle.Wait()
+	}
+	if t.dropped != 0 {
+		warnf("transparency log: %d binding(s) not recorded, as queue was full", t.dropped)
+		t.dropped = 0
+	}
+}
+
+// submit adds hashedrekord entry for SHA-256 digest to the log. Entry that's
+// already there is not an error.
+func (t *transparencyLog) submit(digest []byte) error {
+	r, s, err := ecdsa.Sign(rand.Reader, t.key, digest)
+	if err != nil {
+		return err
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		return err
+	}
+	var entry struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Spec       struct {
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content   string `json:"content"`
+				PublicKey struct {
+					Content string `json:"content"`
+				} `json:"publicKey"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	entry.APIVersion, entry.Kind = "0.0.1", "hashedrekord"
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(digest)
+	entry.Spec.Signature.Content = base64.StdEncoding.EncodeToString(sig)
+	entry.Spec.Signature.PublicKey.Content = base64.StdEncoding.EncodeToString(t.pubPEM)
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url+"/api/v1/log/entries", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "github.com/artyom/age-github")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return &resolve.NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusConflict:
+		return nil
+	}
+	return &resolve.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// Release artifacts are expected to follow naming convention:
+//
+//	age-github-<GOOS>-<GOARCH>[.exe]   binary
+//	SHA256SUMS                         checksums in sha256sum format
+//	SHA256SUMS.sig                     ssh signature of SHA256SUMS made with
+//	                                   one of release owner's GitHub keys
+//
+// Releases and owner keys are always fetched from github.com, regardless of
+// github provider settings, which may point to another instance, or to local
+// keys and mirrors.
+const (
+	releaseURL      = "https://api.github.com/repos/artyom/age-github/releases/latest"
+	releaseOwner    = "artyom"
+	ownerKeysURL    = "https://github.com/" + releaseOwner + ".keys"
+	checksumsAsset  = "SHA256SUMS"
+	signatureAsset  = "SHA256SUMS.sig"
+	signatureNSpace = "file"
+)
+
+// version is the program version, set at build time with
+// -ldflags="-X main.version=v1.2.3", or taken from module build info.
+var version = ""
+
+func programVersion() string {
+	if version != "" {
+		return version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	return "(devel)"
+}
+
+// runUpdate replaces current binary with the one from the latest GitHub
+// release, after verifying its checksum and signature.
+func runUpdate(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	check := fs.Bool("check", false, "only check whether a newer release is available")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: age-github update [-check]")
+	}
+	var release struct {
+		Tag    string `json:"tag_name"`
+		Assets []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	data, err := r.download(ctx, releaseURL)
+	if err != nil {
+		return fmt.Errorf("checking latest release: %w", err)
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return fmt.Errorf("checking latest release: %w", err)
+	}
+	current := programVersion()
+	cmp, err := compareVersions(release.Tag, current)
+	if err != nil {
+		return fmt.Errorf("latest release: %w", err)
+	}
+	if cmp <= 0 {
+		fmt.Printf("age-github %s is up to date (latest release: %s)\n", current, release.Tag)
+		return nil
+	}
+	fmt.Printf("age-github %s is available (current: %s)\n", release.Tag, current)
+	if *check {
+		return nil
+	}
+	binName := fmt.Sprintf("age-github-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		binName += ".exe"
+	}
+	urls := make(map[string]string)
+	for _, a := range release.Assets {
+		urls[a.Name] = a.URL
+	}
+	// checksums file comes from the same release as binary, so it's only
+	// trusted if signed
+	if urls[binName] == "" || urls[checksumsAsset] == "" || urls[signatureAsset] == "" {
+		return fmt.Errorf("release %s has no %s, %s, or %s assets", release.Tag, binName, checksumsAsset, signatureAsset)
+	}
+	sums, err := r.download(ctx, urls[checksumsAsset])
+	if err != nil {
+		return err
+	}
+	sig, err := r.download(ctx, urls[signatureAsset])
+	if err != nil {
+		return err
+	}
+	if err := r.verifyOwnerSignature(ctx, sums, sig); err != nil {
+		return fmt.Errorf("verifying %s signature: %w", checksumsAsset, err)
+	}
+	want, err := findChecksum(sums, binName)
+	if err != nil {
+		return err
+	}
+	bin, err := r.download(ctx, urls[binName])
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(bin); hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("%s checksum mismatch", binName)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".age-github-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(bin); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	if err := replaceExecutable(tmp.Name(), exe); err != nil {
+		return err
+	}
+	fmt.Printf("updated %s to %s\n", exe, release.Tag)
+	return nil
+}
+
+// replaceExecutable renames file name over exe. Windows doesn't allow
+// replacing executable that is running, but allows renaming it, so there exe
+// is renamed aside first, and removed on the next update.
+func replaceExecutable(name, exe string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(name, exe)
+	}
+	old := exe + ".old"
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(name, exe); err != nil {
+		_ = os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
+
+// download fetches url, limiting response size to 64 MiB, and time to
+// configured timeout.
+func (r *resolver) download(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "github.com/artyom/age-github")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &resolve.NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &resolve.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+}
+
+// verifyOwnerSignature verifies ssh signature of data made with one of the
+// keys release owner published on github.com, using ssh-keygen. Keys are
+// fetched directly, bypassing caches, pinned keys, and mirrors.
+func (r *resolver) verifyOwnerSignature(ctx context.Context, data, sig []byte) error {
+	published, err := r.download(ctx, ownerKeysURL)
+	if err != nil {
+		return fmt.Errorf("fetching %s keys: %w", releaseOwner, err)
+	}
+	var keys []string
+	for _, line := range strings.Split(string(published), "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "ssh-") {
+			keys = append(keys, line)
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s publishes no ssh keys", releaseOwner)
+	}
+	dir, err := ioutil.TempDir("", "age-github-update-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	var signers bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&signers, "%s %s\n", releaseOwner, k)
+	}
+	signersFile := filepath.Join(dir, "allowed_signers")
+	sigFile := filepath.Join(dir, "sig")
+	if err := ioutil.WriteFile(signersFile, signers.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(sigFile, sig, 0600); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "ssh-keygen", "-Y", "verify",
+		"-f", signersFile, "-I", releaseOwner, "-n", signatureNSpace, "-s", sigFile)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// findChecksum returns hex-encoded checksum of file name from sha256sum
+// output.
+func findChecksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// compareVersions compares release tag with current program version, both in
+// "vMAJOR.MINOR.PATCH[-PRERELEASE]" form, returning -1, 0, or +1. Current
+// versions of other forms, i.e. of development builds, are older than any
+// release.
+func compareVersions(tag, current string) (int, error) {
+	a, ok := parseVersion(tag)
+	if !ok {
+		return 0, fmt.Errorf("unsupported version tag %q", tag)
+	}
+	b, ok := parseVersion(current)
+	if !ok {
+		return 1, nil
+	}
+	for i := 0; i < 3; i++ {
+		switch {
+		case a.nums[i] > b.nums[i]:
+			return 1, nil
+		case a.nums[i] < b.nums[i]:
+			return -1, nil
+		}
+	}
+	switch {
+	case a.pre == b.pre:
+		return 0, nil
+	case a.pre == "": // release is newer than its pre-releases
+		return 1, nil
+	case b.pre == "", a.pre < b.pre:
+		return -1, nil
+	}
+	return 1, nil
+}
+
+type semver struct {
+	nums [3]int
+	pre  string
+}
+
+// parseVersion parses "vMAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]" version.
+func parseVersion(s string) (semver, bool) {
+	var v semver
+	if !strings.HasPrefix(s, "v") {
+		return v, false
+	}
+	s = s[1:]
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.nums[i] = n
+	}
+	return v, true
+}
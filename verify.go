@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runVerifyCommit verifies ssh signatures of git commits and tags against
+// keys published by the given user: both authentication keys, and, on
+// GitHub, dedicated signing keys.
+func runVerifyCommit(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("verify-commit", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github verify-commit @handle [revision...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("handle is required")
+	}
+	handle := strings.TrimPrefix(fs.Arg(0), "@")
+	if resolve.IsGroupHandle(handle) {
+		return errors.New("group handles are not supported, name a single user")
+	}
+	keys, err := r.signerKeys(ctx, handle)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("@%s: %w", handle, resolve.ErrNoKeys)
+	}
+	f, err := ioutil.TempFile("", "age-github-signers-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	for _, k := range keys {
+		fmt.Fprintf(f, "%s namespaces=\"git\" %s\n", handle, k)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	revs := fs.Args()[1:]
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	for _, rev := range revs {
+		out, err := exec.CommandContext(ctx, "git", "cat-file", "-t", rev).Output()
+		if err != nil {
+			return fmt.Errorf("%s: not a git object: %w", rev, err)
+		}
+		verify := "verify-commit"
+		if string(bytes.TrimSpace(out)) == "tag" {
+			verify = "verify-tag"
+		}
+		cmd := exec.CommandContext(ctx, "git", "-c", "gpg.ssh.allowedSignersFile="+f.Name(), verify, rev)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s is not signed by any of %d key(s) of @%s: %w", rev, len(keys), handle, err)
+		}
+	}
+	return nil
+}
+
+// signingKeys returns ssh keys user published for signing commits. Only
+// GitHub distinguishes such keys, for other providers it returns no keys.
+func (r *resolver) signingKeys(ctx context.Context, handle string) ([]string, error) {
+	name, p, err := r.LookupProvider(handle)
+	if err != nil {
+		return nil, err
+	}
+	if p.Type != resolve.ProviderGithub {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	var out []string
+	for next := p.APIURL("/users/"+url.PathEscape(name)+"/ssh_signing_keys") + "?per_page=100"; next != ""; {
+		var keys []struct {
+			Key string `json:"key"`
+		}
+		if next, err = r.APIGet(ctx, p, next, &keys); err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if fields := strings.Fields(k.Key); len(fields) >= 2 {
+				out = append(out, fields[0]+" "+fields[1])
+			}
+		}
+	}
+	return out, nil
+}
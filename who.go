@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/artyom/age-github/resolve"
+)
+
+// runWho prints handles of users owning ssh keys with the given fingerprints,
+// searching cached keys and keys of organization members.
+func runWho(ctx context.Context, r *resolver, args []string) error {
+	fs := flag.NewFlagSet("who", flag.ContinueOnError)
+	var orgs stringList
+	fs.Var(&orgs, "org", "also search keys of organization `members`, may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: age-github who [-org name]... SHA256:fingerprint...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no fingerprints given")
+	}
+	list, err := r.knownRecipients(ctx, orgs)
+	if err != nil {
+		return err
+	}
+	var missing int
+	for _, arg := range fs.Args() {
+		fp := normalizeFingerprint(arg)
+		var found bool
+		for _, rc := range list {
+			if rc.Fingerprint == fp {
+				found = true
+				fmt.Printf("%s @%s %s\n", fp, displayHandle(rc.Handle, r.cfg.DefaultProvider), rc.PublicKey)
+			}
+		}
+		if !found {
+			missing++
+			fmt.Fprintf(os.Stderr, "%s: no known user has this key\n", fp)
+		}
+	}
+	if missing != 0 {
+		return fmt.Errorf("%d key(s) not found", missing)
+	}
+	return nil
+}
+
+// normalizeFingerprint returns SHA256 fingerprint in the form printed by
+// ssh-keygen -l. Argument may be a fingerprint with or without "SHA256:"
+// prefix, or an ssh key.
+func normalizeFingerprint(s string) string {
+	if strings.HasPrefix(s, "ssh-") {
+		return resolve.Fingerprint(s)
+	}
+	return "SHA256:" + strings.TrimRight(strings.TrimPrefix(s, "SHA256:"), "=")
+}